@@ -0,0 +1,365 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gitee.com/baixudong/gospider/tools"
+)
+
+// 证书颁发机构,签发网站证书,签发的证书缓存在内存和磁盘中
+type Client struct {
+	rootCert *x509.Certificate
+	rootKey  crypto.Signer
+	cacheDir string
+	validity time.Duration
+	certs    sync.Map //key:证书的域名和ip,val:*tls.Certificate
+	lock     sync.Mutex
+}
+type ClientOption struct {
+	CertFile string            //根证书的路径,pem 格式,文件不存在时创建新的根证书并保存
+	KeyFile  string            //根证书私钥的路径,pem 格式,为空时从CertFile 中读取
+	RootCert *x509.Certificate //根证书,设置后不读取CertFile
+	RootKey  crypto.Signer     //根证书的私钥
+	CacheDir string            //签发的证书的缓存目录,为空时只缓存在内存中
+	Validity time.Duration     //签发的证书的有效期,默认：365天
+}
+
+// 创建证书颁发机构,没有设置根证书和根证书路径时使用新建的根证书
+func NewClient(options ...ClientOption) (*Client, error) {
+	var option ClientOption
+	if len(options) > 0 {
+		option = options[0]
+	}
+	if option.Validity == 0 {
+		option.Validity = time.Hour * 24 * 365
+	}
+	client := &Client{
+		rootCert: option.RootCert,
+		rootKey:  option.RootKey,
+		cacheDir: option.CacheDir,
+		validity: option.Validity,
+	}
+	var err error
+	if client.rootCert == nil || client.rootKey == nil {
+		if option.CertFile != "" {
+			client.rootCert, client.rootKey, err = loadOrCreateRoot(option.CertFile, option.KeyFile)
+		} else {
+			client.rootCert, client.rootKey, err = createRoot()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if client.cacheDir != "" {
+		if err = os.MkdirAll(client.cacheDir, 0700); err != nil {
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+// 默认的根证书路径,用户配置目录下的gospider/ca.pem,每个用户使用自己的根证书
+func DefaultCertFile() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "gospider", "ca.pem"), nil
+}
+
+// 新建根证书
+func createRoot() (*x509.Certificate, crypto.Signer, error) {
+	key, err := tools.CreateCertKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := tools.CreateRootCert(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+// 读取根证书,不存在时创建并保存
+func loadOrCreateRoot(certFile, keyFile string) (*x509.Certificate, crypto.Signer, error) {
+	certData, err := os.ReadFile(certFile)
+	if errors.Is(err, os.ErrNotExist) {
+		cert, key, err := createRoot()
+		if err != nil {
+			return nil, nil, err
+		}
+		keyData, err := marshalKey(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		if dir := filepath.Dir(certFile); dir != "" {
+			if err = os.MkdirAll(dir, 0700); err != nil {
+				return nil, nil, err
+			}
+		}
+		if keyFile == "" {
+			return cert, key, os.WriteFile(certFile, append(tools.GetCertData(cert), keyData...), 0600)
+		}
+		if err = os.WriteFile(keyFile, keyData, 0600); err != nil {
+			return nil, nil, err
+		}
+		return cert, key, os.WriteFile(certFile, tools.GetCertData(cert), 0644)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	keyData := certData
+	if keyFile != "" {
+		if keyData, err = os.ReadFile(keyFile); err != nil {
+			return nil, nil, err
+		}
+	}
+	cert, key, err := parsePem(certData, keyData)
+	if err != nil {
+		return nil, nil, tools.WrapError(err, "根证书读取错误")
+	}
+	if !cert.IsCA {
+		return nil, nil, errors.New("证书不是根证书: " + certFile)
+	}
+	return cert, key, nil
+}
+
+// 从pem 数据中读取第一个证书和私钥
+func parsePem(certData, keyData []byte) (cert *x509.Certificate, key crypto.Signer, err error) {
+	for block, rest := pem.Decode(certData); block != nil && cert == nil; block, rest = pem.Decode(rest) {
+		if block.Type == "CERTIFICATE" {
+			if cert, err = x509.ParseCertificate(block.Bytes); err != nil {
+				return
+			}
+		}
+	}
+	for block, rest := pem.Decode(keyData); block != nil && key == nil; block, rest = pem.Decode(rest) {
+		switch block.Type {
+		case "EC PRIVATE KEY":
+			key, err = x509.ParseECPrivateKey(block.Bytes)
+		case "RSA PRIVATE KEY":
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "PRIVATE KEY":
+			var pkcs8Key any
+			if pkcs8Key, err = x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+				var ok bool
+				if key, ok = pkcs8Key.(crypto.Signer); !ok {
+					err = errors.New("不支持的私钥类型")
+				}
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+	if cert == nil {
+		err = errors.New("没有找到证书")
+	} else if key == nil {
+		err = errors.New("没有找到私钥")
+	}
+	return
+}
+
+// 私钥转pem 格式
+func marshalKey(key crypto.Signer) ([]byte, error) {
+	switch key := key.(type) {
+	case *ecdsa.PrivateKey:
+		return tools.GetCertKeyData(key)
+	case *rsa.PrivateKey:
+		return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), nil
+	default:
+		keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), nil
+	}
+}
+
+// 证书的缓存key,names 排序去重
+func certKey(names []string) (string, []string) {
+	names = tools.CopySlices(names)
+	sort.Strings(names)
+	uniqNames := names[:0]
+	for i, name := range names {
+		if i == 0 || name != names[i-1] {
+			uniqNames = append(uniqNames, name)
+		}
+	}
+	return strings.Join(uniqNames, ","), uniqNames
+}
+
+// 证书是否可用,有效期剩余不足一天时重新签发
+func (obj *Client) certOk(cert *x509.Certificate) bool {
+	return time.Now().Add(time.Hour*24).Before(cert.NotAfter) && cert.CheckSignatureFrom(obj.rootCert) == nil
+}
+
+// 获取names 的证书,支持域名,ip,通配符域名,例如：example.com,*.example.com,127.0.0.1
+func (obj *Client) Issue(names ...string) (*tls.Certificate, error) {
+	if len(names) == 0 {
+		return nil, errors.New("没有设置证书的域名")
+	}
+	key, names := certKey(names)
+	if val, ok := obj.certs.Load(key); ok {
+		if tlsCert := val.(*tls.Certificate); obj.certOk(tlsCert.Leaf) {
+			return tlsCert, nil
+		}
+	}
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	if val, ok := obj.certs.Load(key); ok {
+		if tlsCert := val.(*tls.Certificate); obj.certOk(tlsCert.Leaf) {
+			return tlsCert, nil
+		}
+	}
+	var cachePath string
+	if obj.cacheDir != "" {
+		cachePath = filepath.Join(obj.cacheDir, tools.Hex(tools.Md5(key))+".pem")
+		if tlsCert, err := obj.loadCert(cachePath); err == nil {
+			obj.certs.Store(key, tlsCert)
+			return tlsCert, nil
+		}
+	}
+	cert, certKey, err := obj.createCert(names)
+	if err != nil {
+		return nil, err
+	}
+	tlsCert := &tls.Certificate{
+		Certificate: [][]byte{cert.Raw},
+		PrivateKey:  certKey,
+		Leaf:        cert,
+	}
+	if cachePath != "" {
+		keyData, err := marshalKey(certKey)
+		if err != nil {
+			return nil, err
+		}
+		if err = os.WriteFile(cachePath, append(tools.GetCertData(cert), keyData...), 0600); err != nil {
+			return nil, err
+		}
+	}
+	obj.certs.Store(key, tlsCert)
+	return tlsCert, nil
+}
+
+// 读取磁盘中缓存的证书
+func (obj *Client) loadCert(cachePath string) (*tls.Certificate, error) {
+	data, err := os.ReadFile(cachePath)
+	if err != nil {
+		return nil, err
+	}
+	cert, key, err := parsePem(data, data)
+	if err != nil {
+		return nil, err
+	}
+	if !obj.certOk(cert) {
+		return nil, errors.New("证书已过期")
+	}
+	return &tls.Certificate{
+		Certificate: [][]byte{cert.Raw},
+		PrivateKey:  key,
+		Leaf:        cert,
+	}, nil
+}
+
+// 签发证书,有效期不超过根证书
+func (obj *Client) createCert(names []string) (*x509.Certificate, crypto.Signer, error) {
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	notAfter := time.Now().Add(obj.validity)
+	if notAfter.After(obj.rootCert.NotAfter) {
+		notAfter = obj.rootCert.NotAfter
+	}
+	csr := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               obj.rootCert.Subject,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              notAfter,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	csr.Subject.CommonName = names[0]
+	for _, name := range names {
+		if ip := net.ParseIP(name); ip != nil {
+			csr.IPAddresses = append(csr.IPAddresses, ip)
+		} else {
+			csr.DNSNames = append(csr.DNSNames, name)
+		}
+	}
+	key, err := tools.CreateCertKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	der, err := x509.CreateCertificate(rand.Reader, csr, obj.rootCert, key.Public(), obj.rootKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	return cert, key, err
+}
+
+// 用于tls.Config 的GetCertificate,根据sni 签发证书,没有sni 时使用本地ip
+func (obj *Client) GetCertificate(chi *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if chi.ServerName != "" {
+		return obj.Issue(chi.ServerName)
+	}
+	if chi.Conn != nil {
+		if host, _, err := net.SplitHostPort(chi.Conn.LocalAddr().String()); err == nil {
+			return obj.Issue(host)
+		}
+	}
+	return nil, errors.New("没有sni")
+}
+
+// 服务端的tls 配置,根据sni 签发证书
+func (obj *Client) TlsConfig() *tls.Config {
+	return &tls.Config{GetCertificate: obj.GetCertificate}
+}
+
+// 根证书
+func (obj *Client) RootCert() *x509.Certificate {
+	return obj.rootCert
+}
+
+// 根证书的私钥
+func (obj *Client) RootKey() crypto.Signer {
+	return obj.rootKey
+}
+
+// 根证书的pem 格式
+func (obj *Client) RootPem() []byte {
+	return tools.GetCertData(obj.rootCert)
+}
+
+// 根证书的der 格式
+func (obj *Client) RootDer() []byte {
+	return obj.rootCert.Raw
+}
+
+// 导出根证书,用于在设备上安装,后缀是.der 或.cer 时使用der 格式,其它使用pem 格式
+func (obj *Client) Export(filePath string) error {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".der", ".cer":
+		return os.WriteFile(filePath, obj.RootDer(), 0644)
+	default:
+		return os.WriteFile(filePath, obj.RootPem(), 0644)
+	}
+}
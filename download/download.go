@@ -0,0 +1,495 @@
+package download
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gitee.com/baixudong/gospider/bar"
+	"gitee.com/baixudong/gospider/requests"
+	"gitee.com/baixudong/gospider/tools"
+)
+
+type ClientOption struct {
+	ReqCli   *requests.Client //下载使用的客户端,使用客户端的代理,指纹等设置,默认新建一个
+	Thread   int              //并发连接数,default:4
+	PartSize int64            //每个连接最少下载的大小,default:1MB
+	TryNum   int              //每个分片中断后的重试次数,default:3
+	Bar      bool             //是否显示进度条
+}
+
+// 下载参数
+type DownloadOption struct {
+	requests.RequestOption        //请求参数,支持headers,cookies,proxy 等
+	Thread                 int    //并发连接数,覆盖ClientOption.Thread
+	Checksum               string //校验和,例如：sha256:xxxx,支持md5,sha1,sha256,sha512
+	DisResume              bool   //关闭断点续传,重新下载
+}
+
+type Client struct {
+	reqCli   *requests.Client
+	thread   int
+	partSize int64
+	tryNum   int
+	bar      bool
+}
+
+func NewClient(preCtx context.Context, options ...ClientOption) (*Client, error) {
+	var option ClientOption
+	if len(options) > 0 {
+		option = options[0]
+	}
+	if option.ReqCli == nil {
+		reqCli, err := requests.NewClient(preCtx)
+		if err != nil {
+			return nil, err
+		}
+		option.ReqCli = reqCli
+	}
+	if option.Thread <= 0 {
+		option.Thread = 4
+	}
+	if option.PartSize <= 0 {
+		option.PartSize = 1024 * 1024
+	}
+	if option.TryNum <= 0 {
+		option.TryNum = 3
+	}
+	return &Client{
+		reqCli:   option.ReqCli,
+		thread:   option.Thread,
+		partSize: option.PartSize,
+		tryNum:   option.TryNum,
+		bar:      option.Bar,
+	}, nil
+}
+
+// 分片
+type part struct {
+	Start int64 //开始位置
+	End   int64 //结束位置,包含
+	Cur   int64 //已下载到的位置
+}
+
+// 断点续传的状态,保存在文件同目录下的.part.json 中
+type state struct {
+	Url          string
+	Size         int64
+	Etag         string
+	LastModified string
+	Parts        []*part
+}
+
+// 下载过程中的任务
+type task struct {
+	option    DownloadOption
+	href      string
+	file      *os.File
+	state     *state
+	lock      sync.Mutex
+	bar       *bar.Client
+	statePath string
+}
+
+// 从Content-Disposition 和url 中获取文件名
+func fileName(resp *requests.Response) string {
+	if _, params, err := mime.ParseMediaType(resp.Headers().Get("Content-Disposition")); err == nil {
+		if name := filepath.Base(strings.ReplaceAll(params["filename"], "\\", "/")); name != "" && name != "." && name != "/" {
+			return name
+		}
+	}
+	if name := path.Base(resp.Url().Path); name != "" && name != "." && name != "/" {
+		return name
+	}
+	return "download"
+}
+
+// 解析Content-Range,例如：bytes 0-0/1234,返回文件总大小,未知时返回-1
+func parseContentRange(val string) int64 {
+	_, total, ok := strings.Cut(val, "/")
+	if !ok {
+		return -1
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(total), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return size
+}
+
+// 克隆请求参数,设置请求头
+func (obj *task) requestOption(header http.Header) requests.RequestOption {
+	option := obj.option.RequestOption
+	option.DisRead = true
+	option.Bar = false
+	optionCallBack := option.OptionCallBack
+	option.OptionCallBack = func(ctx context.Context, ro *requests.RequestOption) error {
+		if optionCallBack != nil {
+			if err := optionCallBack(ctx, ro); err != nil {
+				return err
+			}
+		}
+		headers, err := cloneHeaders(ro.Headers)
+		if err != nil {
+			return err
+		}
+		headers.Set("Accept-Encoding", "identity") //分片下载不能压缩
+		for key, vals := range header {
+			headers[key] = vals
+		}
+		ro.Headers = headers
+		return nil
+	}
+	return option
+}
+func cloneHeaders(headers any) (http.Header, error) {
+	switch value := headers.(type) {
+	case nil:
+		return requests.DefaultHeaders(), nil
+	case http.Header:
+		return value.Clone(), nil
+	default:
+		jsonData, err := tools.Any2json(value)
+		if err != nil {
+			return nil, err
+		}
+		results := http.Header{}
+		for key, vals := range jsonData.Map() {
+			if vals.IsArray() {
+				for _, val := range vals.Array() {
+					results.Add(key, val.String())
+				}
+			} else {
+				results.Add(key, vals.String())
+			}
+		}
+		return results, nil
+	}
+}
+
+// 下载文件到filePath,filePath 为空或者是文件夹时,使用Content-Disposition 或url 中的文件名,返回文件路径
+func (obj *Client) Download(preCtx context.Context, href string, filePath string, options ...DownloadOption) (string, error) {
+	if preCtx == nil {
+		preCtx = context.TODO()
+	}
+	var option DownloadOption
+	if len(options) > 0 {
+		option = options[0]
+	}
+	if option.Thread <= 0 {
+		option.Thread = obj.thread
+	}
+	tk := &task{option: option, href: href}
+	//探测文件大小和是否支持Range
+	resp, err := obj.reqCli.Request(preCtx, "get", href, tk.requestOption(http.Header{"Range": []string{"bytes=0-0"}}))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Close()
+	var size int64 = -1
+	var rangeOk bool
+	switch resp.StatusCode() {
+	case 206:
+		if size = parseContentRange(resp.Headers().Get("Content-Range")); size >= 0 {
+			rangeOk = true
+		}
+	case 200:
+		size = resp.ContentLength()
+		if resp.Headers().Get("Content-Length") == "" {
+			size = -1
+		}
+	default:
+		return "", errors.New("下载失败,状态码: " + resp.Status())
+	}
+	if filePath == "" || strings.HasSuffix(filePath, "/") || strings.HasSuffix(filePath, string(filepath.Separator)) || isDir(filePath) {
+		filePath = filepath.Join(filePath, fileName(resp))
+	}
+	if dir := filepath.Dir(filePath); !tools.PathExist(dir) {
+		if err = tools.MkDir(dir); err != nil {
+			return filePath, err
+		}
+	}
+	partPath := filePath + ".part"
+	tk.statePath = partPath + ".json"
+	if rangeOk {
+		resp.Close()
+		if !option.DisResume {
+			tk.loadState(resp, size)
+		}
+		if tk.state == nil {
+			tk.newState(resp, size, obj.partSize, option.Thread)
+		}
+		if tk.file, err = os.OpenFile(partPath, os.O_CREATE|os.O_WRONLY, 0644); err != nil {
+			return filePath, err
+		}
+		if err = tk.file.Truncate(size); err != nil {
+			tk.file.Close()
+			return filePath, err
+		}
+		if obj.bar && size > 0 {
+			tk.bar = bar.NewClient(size, bar.ClientOption{Cur: tk.state.done()})
+		}
+		err = tk.run(preCtx, obj)
+	} else { //不支持Range,单线程下载
+		os.Remove(tk.statePath)
+		if tk.file, err = os.Create(partPath); err != nil {
+			return filePath, err
+		}
+		if obj.bar && size > 0 {
+			tk.bar = bar.NewClient(size)
+		}
+		if resp.StatusCode() == 206 { //文件大小未知,重新请求完整的文件
+			resp.Close()
+			if resp, err = obj.reqCli.Request(preCtx, "get", href, tk.requestOption(nil)); err != nil {
+				tk.file.Close()
+				return filePath, err
+			}
+			defer resp.Close()
+		}
+		err = tk.copy(preCtx, resp, 0, nil)
+	}
+	if closeErr := tk.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return filePath, err
+	}
+	if option.Checksum != "" {
+		if err = checksum(partPath, option.Checksum); err != nil {
+			os.Remove(partPath)
+			os.Remove(tk.statePath)
+			return filePath, err
+		}
+	}
+	if err = os.Rename(partPath, filePath); err != nil {
+		return filePath, err
+	}
+	os.Remove(tk.statePath)
+	return filePath, nil
+}
+func isDir(filePath string) bool {
+	info, err := os.Stat(filePath)
+	return err == nil && info.IsDir()
+}
+
+// 加载断点续传的状态,文件发生变化时重新下载
+func (obj *task) loadState(resp *requests.Response, size int64) {
+	con, err := os.ReadFile(obj.statePath)
+	if err != nil {
+		return
+	}
+	var val state
+	if err = tools.JsonUnMarshal(con, &val); err != nil {
+		return
+	}
+	if val.Url != obj.href || val.Size != size || val.Etag != resp.Headers().Get("Etag") || val.LastModified != resp.Headers().Get("Last-Modified") {
+		return
+	}
+	if !tools.PathExist(strings.TrimSuffix(obj.statePath, ".json")) {
+		return
+	}
+	obj.state = &val
+}
+
+// 按照线程数和分片大小切分文件
+func (obj *task) newState(resp *requests.Response, size int64, partSize int64, thread int) {
+	obj.state = &state{
+		Url:          obj.href,
+		Size:         size,
+		Etag:         resp.Headers().Get("Etag"),
+		LastModified: resp.Headers().Get("Last-Modified"),
+	}
+	num := size / partSize
+	if num > int64(thread) {
+		num = int64(thread)
+	}
+	if num < 1 {
+		num = 1
+	}
+	step := size / num
+	for i := int64(0); i < num; i++ {
+		start := i * step
+		end := start + step - 1
+		if i == num-1 {
+			end = size - 1
+		}
+		obj.state.Parts = append(obj.state.Parts, &part{Start: start, End: end, Cur: start})
+	}
+}
+
+// 已下载的大小
+func (obj *state) done() int64 {
+	var total int64
+	for _, p := range obj.Parts {
+		total += p.Cur - p.Start
+	}
+	return total
+}
+
+// 保存断点续传的状态
+func (obj *task) saveState() error {
+	obj.lock.Lock()
+	con, err := tools.JsonMarshal(obj.state)
+	obj.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(obj.statePath, con, 0644)
+}
+
+// 并发下载所有分片
+func (obj *task) run(preCtx context.Context, cli *Client) error {
+	ctx, cnl := context.WithCancel(preCtx)
+	defer cnl()
+	var wg sync.WaitGroup
+	var err error
+	var errOnce sync.Once
+	for _, p := range obj.state.Parts {
+		if p.Cur > p.End {
+			continue
+		}
+		wg.Add(1)
+		go func(p *part) {
+			defer wg.Done()
+			if partErr := obj.downloadPart(ctx, cli, p); partErr != nil {
+				errOnce.Do(func() {
+					err = partErr
+					cnl()
+				})
+			}
+		}(p)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			if saveErr := obj.saveState(); err == nil && saveErr != nil {
+				err = saveErr
+			}
+			return err
+		case <-ticker.C:
+			obj.saveState()
+		}
+	}
+}
+
+// 下载一个分片,中断后从已下载的位置重试
+func (obj *task) downloadPart(ctx context.Context, cli *Client, p *part) error {
+	var err error
+	for i := 0; i <= cli.tryNum; i++ {
+		obj.lock.Lock()
+		cur := p.Cur
+		obj.lock.Unlock()
+		if cur > p.End {
+			return nil
+		}
+		var resp *requests.Response
+		resp, err = cli.reqCli.Request(ctx, "get", obj.href, obj.requestOption(http.Header{"Range": []string{fmt.Sprintf("bytes=%d-%d", cur, p.End)}}))
+		if err == nil {
+			if resp.StatusCode() != 206 {
+				err = errors.New("服务器不支持分片下载,状态码: " + resp.Status())
+			} else {
+				err = obj.copy(ctx, resp, cur, p)
+			}
+			resp.Close()
+		}
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+	}
+	return tools.WrapError(err, "分片下载失败")
+}
+
+// 把body 写入文件的offset 位置,p 不为空时更新分片进度
+func (obj *task) copy(ctx context.Context, resp *requests.Response, offset int64, p *part) error {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := resp.Read(buf)
+		if n > 0 {
+			if p != nil && offset+int64(n) > p.End+1 { //服务器返回的内容超过分片大小
+				n = int(p.End + 1 - offset)
+			}
+			if _, werr := obj.file.WriteAt(buf[:n], offset); werr != nil {
+				return werr
+			}
+			offset += int64(n)
+			if p != nil {
+				obj.lock.Lock()
+				p.Cur = offset
+				obj.lock.Unlock()
+			}
+			if obj.bar != nil {
+				obj.bar.Print(int64(n))
+			}
+			if p != nil && offset > p.End {
+				return nil
+			}
+		}
+		if err == io.EOF {
+			if p != nil {
+				return io.ErrUnexpectedEOF
+			}
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// 校验文件,例如：sha256:xxxx
+func checksum(filePath string, val string) error {
+	algorithm, sum, ok := strings.Cut(val, ":")
+	if !ok {
+		algorithm, sum = "sha256", val
+	}
+	var h hash.Hash
+	switch strings.ToLower(strings.ReplaceAll(algorithm, "-", "")) {
+	case "md5":
+		h = md5.New()
+	case "sha1":
+		h = sha1.New()
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return errors.New("不支持的校验算法: " + algorithm)
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err = io.Copy(h, file); err != nil {
+		return err
+	}
+	if result := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(result, sum) {
+		return fmt.Errorf("校验失败,期望: %s,实际: %s", sum, result)
+	}
+	return nil
+}
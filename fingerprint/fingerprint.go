@@ -0,0 +1,314 @@
+package fingerprint
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+
+	"gitee.com/baixudong/gospider/http2"
+	"gitee.com/baixudong/gospider/ja3"
+	"gitee.com/baixudong/gospider/tools"
+)
+
+// 本地指纹回显服务,返回客户端的tls,h2 指纹,请求头顺序和cookies,用于没有网络时验证客户端的指纹
+type Server struct {
+	ctx      context.Context
+	cnl      context.CancelFunc
+	listener net.Listener
+	server   *http.Server
+	upg      *http2.Upg
+	h1Conns  chan net.Conn
+	rootCert *x509.Certificate
+	tlsCert  tls.Certificate
+	protos   []string
+}
+type ServerOption struct {
+	Addr       string   //监听地址,默认：127.0.0.1:0
+	NextProtos []string //支持的应用协议,默认：[]string{"h2", "http/1.1"}
+}
+
+// tls 指纹
+type TlsResult struct {
+	ja3.TlsData
+	Ja3      string `json:"ja3"`
+	Ja3Md5   string `json:"ja3Md5"`
+	Ja4      string `json:"ja4"`
+	Ja4r     string `json:"ja4r"`
+	Protocol string `json:"protocol"` //协商的应用协议
+}
+
+// h2 指纹
+type H2Result struct {
+	http2.H2Ja3ContextData
+	Akamai    string `json:"akamai"`
+	AkamaiMd5 string `json:"akamaiMd5"`
+}
+
+// 回显的结果
+type Result struct {
+	Proto        string            `json:"proto"`
+	Method       string            `json:"method"`
+	Url          string            `json:"url"`
+	RemoteAddr   string            `json:"remoteAddr"`
+	Tls          *TlsResult        `json:"tls"`
+	H2           *H2Result         `json:"h2"`
+	OrderHeaders []string          `json:"orderHeaders"` //请求头顺序,保持客户端发送时的大小写,不包含伪标头
+	Headers      http.Header       `json:"headers"`
+	Cookies      map[string]string `json:"cookies"`
+	Body         string            `json:"body"`
+}
+
+type keyPrincipal string
+
+const keyPrincipalID keyPrincipal = "fingerprintConnData"
+
+type connData struct {
+	ja3Data *ja3.Ja3ContextData
+	conn    *recordConn
+	proto   string
+}
+
+// 记录http1.1 原始请求头的连接
+type recordConn struct {
+	*tls.Conn
+	data *connData
+	lock sync.Mutex
+	buf  []byte
+}
+
+const maxRecordLen = 1024 * 1024
+
+func (obj *recordConn) Read(b []byte) (n int, err error) {
+	n, err = obj.Conn.Read(b)
+	if n > 0 {
+		obj.lock.Lock()
+		obj.buf = append(obj.buf, b[:n]...)
+		if len(obj.buf) > maxRecordLen {
+			obj.buf = obj.buf[len(obj.buf)-maxRecordLen:]
+		}
+		obj.lock.Unlock()
+	}
+	return
+}
+
+// 从原始数据中找到当前请求的请求头顺序
+func (obj *recordConn) orderHeaders(r *http.Request) []string {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	start := bytes.Index(obj.buf, []byte(r.Method+" "+r.RequestURI+" "))
+	if start == -1 {
+		return nil
+	}
+	headerData := obj.buf[start:]
+	end := bytes.Index(headerData, []byte("\r\n\r\n"))
+	if end == -1 {
+		return nil
+	}
+	obj.buf = obj.buf[start+end+4:]
+	orderHeaders := []string{}
+	for i, line := range bytes.Split(headerData[:end], []byte("\r\n")) {
+		if i == 0 {
+			continue
+		}
+		if key, _, ok := bytes.Cut(line, []byte(":")); ok {
+			orderHeaders = append(orderHeaders, string(key))
+		}
+	}
+	return orderHeaders
+}
+
+type connListener struct {
+	ctx   context.Context
+	conns chan net.Conn
+	addr  net.Addr
+}
+
+func (obj *connListener) Accept() (net.Conn, error) {
+	select {
+	case <-obj.ctx.Done():
+		return nil, net.ErrClosed
+	case conn := <-obj.conns:
+		return conn, nil
+	}
+}
+func (obj *connListener) Close() error {
+	return nil
+}
+func (obj *connListener) Addr() net.Addr {
+	return obj.addr
+}
+
+// 创建并启动指纹回显服务,使用 tools.CreateRootCert 生成的自签名证书
+func NewServer(preCtx context.Context, options ...ServerOption) (*Server, error) {
+	var option ServerOption
+	if len(options) > 0 {
+		option = options[0]
+	}
+	if preCtx == nil {
+		preCtx = context.TODO()
+	}
+	if option.Addr == "" {
+		option.Addr = "127.0.0.1:0"
+	}
+	if option.NextProtos == nil {
+		option.NextProtos = []string{"h2", "http/1.1"}
+	}
+	key, err := tools.CreateCertKey()
+	if err != nil {
+		return nil, err
+	}
+	rootCert, err := tools.CreateRootCert(key)
+	if err != nil {
+		return nil, err
+	}
+	host, _, err := net.SplitHostPort(option.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	cert, err := tools.GetCertWithCN(rootCert, key, host)
+	if err != nil {
+		return nil, err
+	}
+	tlsCert, err := tools.GetTlsCert(cert, key)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", option.Addr)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cnl := context.WithCancel(preCtx)
+	server := &Server{
+		ctx:      ctx,
+		cnl:      cnl,
+		listener: listener,
+		upg:      http2.NewUpg(nil, http2.UpgOption{Server: true}),
+		h1Conns:  make(chan net.Conn),
+		rootCert: rootCert,
+		tlsCert:  tlsCert,
+		protos:   option.NextProtos,
+	}
+	server.server = &http.Server{
+		Handler: server,
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			return context.WithValue(ctx, keyPrincipalID, c.(*recordConn).data)
+		},
+	}
+	go server.server.Serve(&connListener{ctx: ctx, conns: server.h1Conns, addr: listener.Addr()})
+	go server.run()
+	return server, nil
+}
+func (obj *Server) run() {
+	defer obj.Close()
+	for {
+		conn, err := obj.listener.Accept()
+		if err != nil {
+			return
+		}
+		go obj.serveConn(conn)
+	}
+}
+func (obj *Server) serveConn(conn net.Conn) {
+	ctx := ja3.ConnContext(obj.ctx, conn)
+	tlsConn := tls.Server(ja3.NewHelloConn(conn), &tls.Config{
+		Certificates:       []tls.Certificate{obj.tlsCert},
+		NextProtos:         obj.protos,
+		GetConfigForClient: ja3.GetConfigForClient,
+	})
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return
+	}
+	data := &connData{
+		ja3Data: ja3.GetContextJa3Data(ctx),
+		proto:   tlsConn.ConnectionState().NegotiatedProtocol,
+	}
+	if data.proto == "h2" {
+		defer tlsConn.Close()
+		obj.upg.ServerConn(context.WithValue(ctx, keyPrincipalID, data), tlsConn, obj)
+		return
+	}
+	data.conn = &recordConn{Conn: tlsConn, data: data}
+	select {
+	case <-obj.ctx.Done():
+		conn.Close()
+	case obj.h1Conns <- data.conn:
+	}
+}
+
+// 返回请求的指纹
+func (obj *Server) Result(r *http.Request) Result {
+	result := Result{
+		Proto:      r.Proto,
+		Method:     r.Method,
+		Url:        r.URL.String(),
+		RemoteAddr: r.RemoteAddr,
+		Headers:    r.Header,
+		Cookies:    map[string]string{},
+	}
+	for _, cookie := range r.Cookies() {
+		result.Cookies[cookie.Name] = cookie.Value
+	}
+	data, _ := r.Context().Value(keyPrincipalID).(*connData)
+	if data == nil {
+		return result
+	}
+	if data.ja3Data != nil && data.ja3Data.TlsData != nil {
+		result.Tls = &TlsResult{
+			TlsData:  *data.ja3Data.TlsData,
+			Ja3:      data.ja3Data.Ja3(),
+			Ja3Md5:   data.ja3Data.Ja3Md5(),
+			Ja4:      data.ja3Data.Ja4(),
+			Ja4r:     data.ja3Data.Ja4r(),
+			Protocol: data.proto,
+		}
+	}
+	if h2Ja3Data := http2.GetRequestH2Ja3Data(r); h2Ja3Data != nil {
+		akamai := h2Ja3Data.String()
+		result.H2 = &H2Result{
+			H2Ja3ContextData: *h2Ja3Data,
+			Akamai:           akamai,
+			AkamaiMd5:        tools.Hex(tools.Md5(akamai)),
+		}
+		result.OrderHeaders = h2Ja3Data.Headers
+	} else if data.conn != nil {
+		result.OrderHeaders = data.conn.orderHeaders(r)
+	}
+	return result
+}
+func (obj *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	result := obj.Result(r)
+	body, _ := io.ReadAll(r.Body)
+	result.Body = string(body)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// 监听地址
+func (obj *Server) Addr() string {
+	return obj.listener.Addr().String()
+}
+
+// 服务地址,例如：https://127.0.0.1:8080
+func (obj *Server) Url() string {
+	return "https://" + obj.Addr()
+}
+
+// 自签名的根证书,客户端验证证书时使用
+func (obj *Server) RootCert() *x509.Certificate {
+	return obj.rootCert
+}
+func (obj *Server) Close() error {
+	obj.cnl()
+	obj.server.Close()
+	return obj.listener.Close()
+}
@@ -7,6 +7,7 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/tls"
+	"encoding/base64"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -46,6 +47,8 @@ type UpgOption struct {
 	H2Ja3Spec             ja3.H2Ja3Spec
 	DisableCompression    bool
 	DialTLSContext        func(ctx context.Context, network string, addr string, cfg *tls.Config) (net.Conn, error)
+	H2c                   bool                                                                     //客户端使用h2c,http 请求不经过tls 直接使用http2
+	DialContext           func(ctx context.Context, network string, addr string) (net.Conn, error) //h2c 建立tcp 连接
 	IdleConnTimeout       time.Duration
 	TLSHandshakeTimeout   time.Duration
 	ResponseHeaderTimeout time.Duration
@@ -133,6 +136,16 @@ func NewUpg(t1 *http.Transport, options ...UpgOption) *Upg {
 		PingTimeout:      option.TLSHandshakeTimeout,
 		WriteByteTimeout: option.ResponseHeaderTimeout,
 	}
+	if option.H2c { //h2c 的连接由连接池自己建立
+		if option.DialContext == nil {
+			option.DialContext = (&net.Dialer{}).DialContext
+		}
+		t2.ConnPool = connPool
+		t2.AllowHTTP = true
+		t2.DialTLSContext = func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return option.DialContext(ctx, network, addr)
+		}
+	}
 	connPool.t = t2
 	if t1 != nil {
 		t1.RegisterProtocol("https", http2noDialH2RoundTripper{t2})
@@ -157,11 +170,317 @@ func (obj *Upg) UpgradeFn(authority string, c net.Conn) http.RoundTripper {
 	}
 	return obj.t
 }
+
+// 标记连接不再复用,正在进行的请求完成后关闭,不是这个Upg 的连接时返回false
+func (obj *Upg) SetDoNotReuse(c net.Conn) bool {
+	if obj.connPool == nil {
+		return false
+	}
+	var cc *http2ClientConn
+	obj.connPool.mu.Lock()
+	for _, ccs := range obj.connPool.conns {
+		for _, val := range ccs {
+			if val.tconn == c {
+				cc = val
+			}
+		}
+	}
+	obj.connPool.mu.Unlock()
+	if cc == nil {
+		return false
+	}
+	cc.SetDoNotReuse()
+	cc.closeIfIdle()
+	return true
+}
+
+// 不放入连接池的http2 客户端连接,用于http2 代理
+type ClientConn struct {
+	cc *http2ClientConn
+}
+
+// 在已经建立的连接上创建http2 客户端连接,使用Upg 的h2指纹
+func (obj *Upg) NewClientConn(c net.Conn) (*ClientConn, error) {
+	cc, err := obj.t.NewClientConn(c)
+	if err != nil {
+		return nil, err
+	}
+	return &ClientConn{cc: cc}, nil
+}
+func (obj *ClientConn) RoundTrip(req *http.Request) (*http.Response, error) {
+	return obj.cc.RoundTrip(req)
+}
+
+// 是否可以发送新的请求
+func (obj *ClientConn) CanTakeNewRequest() bool {
+	return obj.cc.CanTakeNewRequest()
+}
+
+// 正在进行的stream 数量
+func (obj *ClientConn) StreamsActive() int {
+	return obj.cc.State().StreamsActive
+}
+func (obj *ClientConn) Close() error {
+	return obj.cc.Close()
+}
+
+// 发送http2 请求,h2c 客户端的http 请求使用prior knowledge
+func (obj *Upg) RoundTrip(req *http.Request) (*http.Response, error) {
+	return obj.t.RoundTrip(req)
+}
+
+// h2c 客户端的http 请求,没有可以复用的连接时通过Upgrade: h2c 升级到http2,服务端不支持时返回http1.1 的响应
+func (obj *Upg) UpgradeRoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "http" || !obj.t.AllowHTTP {
+		return nil, errors.New("http2: unsupported scheme")
+	}
+	addr := http2authorityAddr(req.URL.Scheme, req.URL.Host)
+	if _, err := obj.connPool.getClientConn(req, addr, false); err == nil {
+		return obj.t.RoundTrip(req)
+	}
+	return obj.t.upgradeRoundTrip(req, addr)
+}
+
+// 服务端处理连接,tls 连接直接使用http2,其它的连接按h2c 处理
 func (obj *Upg) ServerConn(ctx context.Context, c net.Conn, h http.Handler) {
-	obj.server.ServeConn(c, &http2ServeConnOpts{
-		Context: ctx,
-		Handler: h,
-	})
+	if _, ok := c.(http2connectionStater); ok {
+		obj.server.ServeConn(c, &http2ServeConnOpts{
+			Context: ctx,
+			Handler: h,
+		})
+		return
+	}
+	obj.serveH2c(ctx, c, h)
+}
+
+// 服务端记录的客户端h2 指纹
+type H2Ja3ContextData struct {
+	InitialSetting []ja3.Setting       `json:"initialSetting"` //第一个SETTINGS 帧
+	ConnFlow       uint32              `json:"connFlow"`       //连接的WINDOW_UPDATE 增量
+	PriorityFrames []ja3.PriorityFrame `json:"priorityFrames"` //第一个请求之前收到的PRIORITY 帧
+	Priority       ja3.Priority        `json:"priority"`       //当前请求HEADERS 帧中的优先级
+	OrderHeaders   []string            `json:"orderHeaders"`   //当前请求的伪标头顺序
+	Headers        []string            `json:"headers"`        //当前请求的请求头顺序,不包含伪标头
+	settingInit    bool
+	headersInit    bool
+}
+
+// 转换成 H2Ja3Spec
+func (obj H2Ja3ContextData) Spec() ja3.H2Ja3Spec {
+	return ja3.H2Ja3Spec{
+		InitialSetting: obj.InitialSetting,
+		ConnFlow:       obj.ConnFlow,
+		OrderHeaders:   obj.OrderHeaders,
+		Priority:       obj.Priority,
+		PriorityFrames: obj.PriorityFrames,
+	}
+}
+
+// akamai 格式的h2 指纹
+func (obj H2Ja3ContextData) String() string {
+	return obj.Spec().String()
+}
+
+type keyPrincipal string
+
+const keyPrincipalID keyPrincipal = "H2Ja3ContextData"
+
+const orderHeadersKey keyPrincipal = "OrderHeaders"
+
+// 设置单个请求的请求头顺序,伪标头没有设置时使用 H2Ja3Spec 中的顺序
+func ContextWithOrderHeaders(ctx context.Context, orderHeaders []string) context.Context {
+	return context.WithValue(ctx, orderHeadersKey, orderHeaders)
+}
+func orderHeadersWithContext(ctx context.Context, orderHeaders []string) []string {
+	ctxOrderHeaders, _ := ctx.Value(orderHeadersKey).([]string)
+	if len(ctxOrderHeaders) == 0 {
+		return orderHeaders
+	}
+	results := []string{}
+	hasPseudo := false
+	for _, kk := range ctxOrderHeaders {
+		if strings.HasPrefix(kk, ":") {
+			hasPseudo = true
+			break
+		}
+	}
+	if !hasPseudo {
+		for _, kk := range orderHeaders {
+			if strings.HasPrefix(kk, ":") {
+				results = append(results, kk)
+			}
+		}
+	}
+	return append(results, ctxOrderHeaders...)
+}
+
+// 获取服务端记录的客户端h2 指纹,只有 Upg.ServerConn 处理的请求才有
+func GetRequestH2Ja3Data(r *http.Request) *H2Ja3ContextData {
+	h2Ja3Data, _ := r.Context().Value(keyPrincipalID).(*H2Ja3ContextData)
+	return h2Ja3Data
+}
+
+// h2c 服务,prior knowledge 直接使用http2,Upgrade: h2c 的请求升级到http2,其它的请求按http1.1 处理
+func (obj *Upg) serveH2c(ctx context.Context, c net.Conn, h http.Handler) {
+	reader := bufio.NewReader(c)
+	head, err := reader.Peek(3)
+	if err != nil {
+		c.Close()
+		return
+	}
+	conn := &http2h2cConn{Conn: c, reader: reader}
+	if string(head) == "PRI" {
+		obj.server.ServeConn(conn, &http2ServeConnOpts{
+			Context: ctx,
+			Handler: h,
+		})
+		return
+	}
+	listener := http2newH2cListener(conn)
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !http2isH2cUpgrade(r) || !http2h2cReadBody(r) { //请求体太大时不升级
+				h.ServeHTTP(w, r)
+				return
+			}
+			upgradeConn, settings, err := http2h2cUpgrade(w, r)
+			if err != nil {
+				return
+			}
+			defer listener.Close()
+			defer upgradeConn.Close()
+			obj.server.ServeConn(upgradeConn, &http2ServeConnOpts{
+				Context:        ctx,
+				Handler:        h,
+				UpgradeRequest: r,
+				Settings:       settings,
+			})
+		}),
+		BaseContext: func(net.Listener) context.Context { return ctx },
+		ConnState: func(c net.Conn, state http.ConnState) {
+			if state == http.StateClosed {
+				listener.Close()
+			}
+		},
+	}
+	server.Serve(listener)
+}
+
+// 是否为Upgrade: h2c 的请求
+func http2isH2cUpgrade(r *http.Request) bool {
+	return httpguts.HeaderValuesContainsToken(r.Header["Upgrade"], "h2c") &&
+		httpguts.HeaderValuesContainsToken(r.Header["Connection"], "HTTP2-Settings") &&
+		len(r.Header["Http2-Settings"]) == 1
+}
+
+// 升级请求的请求体最大长度,请求体需要在升级前读完
+const http2h2cMaxBody = 1 << 16
+
+// 读取升级请求的请求体,超过http2h2cMaxBody 时返回false,已经读取的数据放回请求体
+func http2h2cReadBody(r *http.Request) bool {
+	if r.ContentLength > http2h2cMaxBody {
+		return false
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, http2h2cMaxBody+1))
+	if err != nil || len(body) > http2h2cMaxBody {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		return false
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return true
+}
+
+// 返回101,请求转为流1 上的http2 请求,请求体已经由http2h2cReadBody 读取
+func http2h2cUpgrade(w http.ResponseWriter, r *http.Request) (net.Conn, []byte, error) {
+	settings, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(r.Header.Get("Http2-Settings"), "="))
+	if err != nil {
+		http.Error(w, "invalid HTTP2-Settings", http.StatusBadRequest)
+		return nil, nil, err
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "h2c upgrade not supported", http.StatusInternalServerError)
+		return nil, nil, errors.New("http2: ResponseWriter does not implement http.Hijacker")
+	}
+	c, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: h2c\r\n\r\n"); err == nil {
+		err = rw.Flush()
+	}
+	if err != nil {
+		c.Close()
+		return nil, nil, err
+	}
+	r.Header.Del("Upgrade")
+	r.Header.Del("Connection")
+	r.Header.Del("Http2-Settings")
+	r.Proto, r.ProtoMajor, r.ProtoMinor = "HTTP/2.0", 2, 0
+	return &http2h2cConn{Conn: c, reader: rw.Reader}, settings, nil
+}
+
+// 先读取缓冲中数据的连接
+type http2h2cConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (obj *http2h2cConn) Read(b []byte) (int, error) {
+	return obj.reader.Read(b)
+}
+func (obj *http2h2cConn) NetConn() net.Conn {
+	return obj.Conn
+}
+
+// 只有一个连接的listener,h2c 服务中http1.1 的请求交给http.Server 处理
+type http2h2cListener struct {
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+	addr      net.Addr
+}
+
+func http2newH2cListener(conn net.Conn) *http2h2cListener {
+	listener := &http2h2cListener{
+		conns:  make(chan net.Conn, 1),
+		closed: make(chan struct{}),
+		addr:   conn.LocalAddr(),
+	}
+	listener.conns <- conn
+	return listener
+}
+func (obj *http2h2cListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-obj.conns:
+		return conn, nil
+	case <-obj.closed:
+		return nil, net.ErrClosed
+	}
+}
+func (obj *http2h2cListener) Close() error {
+	obj.closeOnce.Do(func() { close(obj.closed) })
+	return nil
+}
+func (obj *http2h2cListener) Addr() net.Addr {
+	return obj.addr
+}
+
+// 服务端不支持h2c 时http1.1 的响应体,关闭时关闭连接
+type http2h2cBody struct {
+	io.ReadCloser
+	conn net.Conn
+	stop func() bool
+}
+
+func (obj *http2h2cBody) Close() error {
+	obj.stop()
+	err := obj.ReadCloser.Close()
+	obj.conn.Close()
+	return err
 }
 
 // The HTTP protocols are defined in terms of ASCII, not Unicode. This file
@@ -4362,6 +4681,13 @@ func (s *http2Server) ServeConn(c net.Conn, opts *http2ServeConnOpts) {
 			http2FrameHeader: http2FrameHeader{valid: true},
 			p:                opts.Settings,
 		}
+		//h2c Upgrade 时HTTP2-Settings 为客户端的第一个SETTINGS
+		sc.h2Ja3Data.settingInit = true
+		sc.h2Ja3Data.InitialSetting = []ja3.Setting{}
+		fr.ForeachSetting(func(s http2Setting) error {
+			sc.h2Ja3Data.InitialSetting = append(sc.h2Ja3Data.InitialSetting, ja3.Setting{Id: uint16(s.ID), Val: s.Val})
+			return nil
+		})
 		if err := fr.ForeachSetting(sc.processSetting); err != nil {
 			sc.rejectConn(http2ErrCodeProtocol, "invalid settings")
 			return
@@ -4423,6 +4749,7 @@ type http2serverConn struct {
 	serveG                      http2goroutineLock // used to verify funcs are on serve()
 	pushEnabled                 bool
 	sawClientPreface            bool // preface has already been read, used in h2c upgrade
+	h2Ja3Data                   H2Ja3ContextData
 	sawFirstSettings            bool // got the initial SETTINGS frame after the preface
 	needToSendSettingsAck       bool
 	unackedSettings             int    // how many SETTINGS have we sent without ACKs?
@@ -5444,6 +5771,9 @@ func (sc *http2serverConn) processWindowUpdate(f *http2WindowUpdateFrame) error
 			return sc.countError("bad_flow", http2streamError(f.StreamID, http2ErrCodeFlowControl))
 		}
 	default: // connection-level flow control
+		if sc.h2Ja3Data.ConnFlow == 0 {
+			sc.h2Ja3Data.ConnFlow = f.Increment
+		}
 		if !sc.flow.add(int32(f.Increment)) {
 			return http2goAwayFlowError{}
 		}
@@ -5535,6 +5865,14 @@ func (sc *http2serverConn) processSettings(f *http2SettingsFrame) error {
 		// duplicate entries.
 		return sc.countError("settings_big_or_dups", http2ConnectionError(http2ErrCodeProtocol))
 	}
+	if !sc.h2Ja3Data.settingInit {
+		sc.h2Ja3Data.settingInit = true
+		sc.h2Ja3Data.InitialSetting = []ja3.Setting{}
+		f.ForeachSetting(func(s http2Setting) error {
+			sc.h2Ja3Data.InitialSetting = append(sc.h2Ja3Data.InitialSetting, ja3.Setting{Id: uint16(s.ID), Val: s.Val})
+			return nil
+		})
+	}
 	if err := f.ForeachSetting(sc.processSetting); err != nil {
 		return err
 	}
@@ -5837,6 +6175,7 @@ func (sc *http2serverConn) processHeaders(f *http2MetaHeadersFrame) error {
 		initialState = http2stateHalfClosedRemote
 	}
 	st := sc.newStream(id, 0, initialState)
+	st.ctx = context.WithValue(st.ctx, keyPrincipalID, sc.newH2Ja3Data(f))
 
 	if f.HasPriority() {
 		if err := sc.checkPriority(f.StreamID, f.Priority); err != nil {
@@ -5891,6 +6230,8 @@ func (sc *http2serverConn) upgradeRequest(req *http.Request) {
 	if st.reqTrailer != nil {
 		st.trailer = make(http.Header)
 	}
+	h2Ja3Data := sc.h2Ja3Data //http1.1 的请求没有伪标头和优先级
+	req = req.WithContext(context.WithValue(req.Context(), keyPrincipalID, &h2Ja3Data))
 	rw := sc.newResponseWriter(st, req)
 
 	// Disable any read deadline set by the net/http package
@@ -5947,10 +6288,43 @@ func (sc *http2serverConn) processPriority(f *http2PriorityFrame) error {
 	if err := sc.checkPriority(f.StreamID, f.http2PriorityParam); err != nil {
 		return err
 	}
+	if !sc.h2Ja3Data.headersInit {
+		sc.h2Ja3Data.PriorityFrames = append(sc.h2Ja3Data.PriorityFrames, ja3.PriorityFrame{
+			StreamId: f.StreamID,
+			Priority: ja3.Priority{
+				StreamDep: f.StreamDep,
+				Exclusive: f.Exclusive,
+				Weight:    f.Weight,
+			},
+		})
+	}
 	sc.writeSched.AdjustStream(f.StreamID, f.http2PriorityParam)
 	return nil
 }
 
+// 记录当前请求的h2 指纹
+func (sc *http2serverConn) newH2Ja3Data(f *http2MetaHeadersFrame) *H2Ja3ContextData {
+	sc.h2Ja3Data.headersInit = true
+	h2Ja3Data := sc.h2Ja3Data
+	h2Ja3Data.OrderHeaders = []string{}
+	h2Ja3Data.Headers = []string{}
+	for _, hf := range f.Fields {
+		if hf.IsPseudo() {
+			h2Ja3Data.OrderHeaders = append(h2Ja3Data.OrderHeaders, hf.Name)
+		} else {
+			h2Ja3Data.Headers = append(h2Ja3Data.Headers, hf.Name)
+		}
+	}
+	if f.HasPriority() {
+		h2Ja3Data.Priority = ja3.Priority{
+			StreamDep: f.Priority.StreamDep,
+			Exclusive: f.Priority.Exclusive,
+			Weight:    f.Priority.Weight,
+		}
+	}
+	return &h2Ja3Data
+}
+
 func (sc *http2serverConn) newStream(id, pusherID uint32, state http2streamState) *http2stream {
 	sc.serveG.check()
 	if id == 0 {
@@ -7742,7 +8116,7 @@ func (t *http2Transport) dialClientConn(ctx context.Context, addr string, single
 	if err != nil {
 		return nil, err
 	}
-	return t.newClientConn(tconn, singleUse)
+	return t.newClientConn(tconn, singleUse, nil)
 }
 
 func (t *http2Transport) newTLSConfig(host string) *tls.Config {
@@ -7808,10 +8182,20 @@ func (t *http2Transport) maxEncoderHeaderTableSize() uint32 {
 }
 
 func (t *http2Transport) NewClientConn(c net.Conn) (*http2ClientConn, error) {
-	return t.newClientConn(c, t.disableKeepAlives())
+	return t.newClientConn(c, t.disableKeepAlives(), nil)
+}
+
+// 客户端的第一个SETTINGS
+func (t *http2Transport) initialSettings() []http2Setting {
+	initialSettings := make([]http2Setting, len(t.h2Ja3Spec.InitialSetting))
+	for i, setting := range t.h2Ja3Spec.InitialSetting {
+		initialSettings[i] = http2Setting{ID: http2SettingID(setting.Id), Val: setting.Val}
+	}
+	return initialSettings
 }
 
-func (t *http2Transport) newClientConn(c net.Conn, singleUse bool) (*http2ClientConn, error) {
+// upgrade 不为空时,在开始读取帧之前调用,用于h2c Upgrade 创建流1
+func (t *http2Transport) newClientConn(c net.Conn, singleUse bool, upgrade func(cc *http2ClientConn)) (*http2ClientConn, error) {
 	cc := &http2ClientConn{
 		t:                     t,
 		tconn:                 c,
@@ -7861,34 +8245,93 @@ func (t *http2Transport) newClientConn(c net.Conn, singleUse bool) (*http2Client
 	cc.henc.SetMaxDynamicTableSizeLimit(t.maxEncoderHeaderTableSize())
 	cc.peerMaxHeaderTableSize = http2initialHeaderTableSize
 
-	if t.AllowHTTP {
-		cc.nextStreamID = 3
-	}
-
 	if cs, ok := c.(http2connectionStater); ok {
 		state := cs.ConnectionState()
 		cc.tlsState = &state
 	}
 
-	initialSettings := make([]http2Setting, len(t.h2Ja3Spec.InitialSetting))
-	for i, setting := range t.h2Ja3Spec.InitialSetting {
-		initialSettings[i] = http2Setting{ID: http2SettingID(setting.Id), Val: setting.Val}
-	}
 	cc.bw.Write(http2clientPreface)
-	cc.fr.WriteSettings(initialSettings...)
+	cc.fr.WriteSettings(t.initialSettings()...)
 	cc.fr.WriteWindowUpdate(0, t.h2Ja3Spec.ConnFlow)
 	cc.inflow.add(int32(t.h2Ja3Spec.ConnFlow) + http2initialWindowSize)
+	for _, frame := range t.h2Ja3Spec.PriorityFrames {
+		cc.fr.WritePriority(frame.StreamId, http2PriorityParam{
+			StreamDep: frame.Priority.StreamDep,
+			Exclusive: frame.Priority.Exclusive,
+			Weight:    frame.Priority.Weight,
+		})
+		//PRIORITY 帧占用的流不再用于请求
+		if frame.StreamId >= cc.nextStreamID {
+			cc.nextStreamID = frame.StreamId + 1 + frame.StreamId%2
+		}
+	}
 
 	cc.bw.Flush()
 	if cc.werr != nil {
 		cc.Close()
 		return nil, cc.werr
 	}
+	if upgrade != nil {
+		upgrade(cc)
+	}
 
 	go cc.readLoop()
 	return cc, nil
 }
 
+// h2c Upgrade,请求通过http1.1 发送,服务端返回101 后在流1 上读取http2 的响应
+func (t *http2Transport) upgradeRoundTrip(req *http.Request, addr string) (*http.Response, error) {
+	ctx := req.Context()
+	c, err := t.dialTLS(ctx, "tcp", addr, nil)
+	if err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() { c.Close() })
+	var settings bytes.Buffer
+	http2NewFramer(&settings, nil).WriteSettings(t.initialSettings()...)
+	upgradeReq := req.Clone(ctx)
+	upgradeReq.Header.Set("Connection", "Upgrade, HTTP2-Settings")
+	upgradeReq.Header.Set("Upgrade", "h2c")
+	upgradeReq.Header.Set("HTTP2-Settings", base64.RawURLEncoding.EncodeToString(settings.Bytes()[http2frameHeaderLen:]))
+	reader := bufio.NewReader(c)
+	var res *http.Response
+	if err = upgradeReq.Write(c); err == nil {
+		res, err = http.ReadResponse(reader, req)
+	}
+	if err != nil {
+		stop()
+		c.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols { //服务端不支持h2c
+		res.Body = &http2h2cBody{ReadCloser: res.Body, conn: c, stop: stop}
+		return res, nil
+	}
+	res.Body.Close()
+	if !stop() {
+		c.Close()
+		return nil, ctx.Err()
+	}
+	var cs *http2clientStream
+	cc, err := t.newClientConn(&http2h2cConn{Conn: c, reader: reader}, t.disableKeepAlives(), func(cc *http2ClientConn) {
+		cs = cc.newUpgradeStream(req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if p, ok := t.connPool().(*http2clientConnPool); ok {
+		p.mu.Lock()
+		p.addConnLocked(addr, cc)
+		p.mu.Unlock()
+	}
+	http2traceGotConn(req, cc, false)
+	go cs.doUpgradeRequest()
+	return cs.readResponse(req)
+}
+
 func (cc *http2ClientConn) healthCheck() {
 	pingTimeout := cc.t.pingTimeout()
 	// We don't need to periodically ping in the health check, because the readLoop of ClientConn will
@@ -8282,8 +8725,14 @@ func (cc *http2ClientConn) decrStreamReservationsLocked() {
 }
 
 func (cc *http2ClientConn) RoundTrip(req *http.Request) (*http.Response, error) {
+	cs := cc.newClientStream(req)
+	go cs.doRequest(req)
+	return cs.readResponse(req)
+}
+
+func (cc *http2ClientConn) newClientStream(req *http.Request) *http2clientStream {
 	ctx := req.Context()
-	cs := &http2clientStream{
+	return &http2clientStream{
 		cc:                   cc,
 		ctx:                  ctx,
 		reqCancel:            req.Cancel,
@@ -8296,8 +8745,33 @@ func (cc *http2ClientConn) RoundTrip(req *http.Request) (*http.Response, error)
 		respHeaderRecv:       make(chan struct{}),
 		donec:                make(chan struct{}),
 	}
-	go cs.doRequest(req)
+}
 
+// h2c Upgrade 的请求已经通过http1.1 发送,响应在流1 上返回
+func (cc *http2ClientConn) newUpgradeStream(req *http.Request) *http2clientStream {
+	cs := cc.newClientStream(req)
+	cs.reqBody = nil
+	cs.reqBodyContentLength = 0
+	cs.sentHeaders = true
+	cs.sentEndStream = true
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.idleTimer != nil {
+		cc.idleTimer.Stop()
+	}
+	nextStreamID := cc.nextStreamID
+	cc.nextStreamID = 1
+	cc.addStreamLocked(cs)
+	if nextStreamID > cc.nextStreamID { //PRIORITY 帧占用的流
+		cc.nextStreamID = nextStreamID
+	}
+	return cs
+}
+
+// 等待响应头
+func (cs *http2clientStream) readResponse(req *http.Request) (*http.Response, error) {
+	cc := cs.cc
+	ctx := cs.ctx
 	waitDone := func() error {
 		select {
 		case <-cs.donec:
@@ -8371,6 +8845,13 @@ func (cs *http2clientStream) doRequest(req *http.Request) {
 	cs.cleanupWriteRequest(err)
 }
 
+// h2c Upgrade 的请求只需要等待响应
+func (cs *http2clientStream) doUpgradeRequest() {
+	http2traceWroteRequest(cs.trace, nil)
+	err := cs.waitResponse()
+	cs.cleanupWriteRequest(err)
+}
+
 // writeRequest sends a request.
 //
 // It returns nil after the request is written, the response read,
@@ -8492,7 +8973,13 @@ func (cs *http2clientStream) writeRequest(req *http.Request) (err error) {
 	}
 
 	http2traceWroteRequest(cs.trace, err)
+	return cs.waitResponse()
+}
 
+// 等待对端关闭流
+func (cs *http2clientStream) waitResponse() error {
+	cc := cs.cc
+	ctx := cs.ctx
 	var respHeaderTimer <-chan time.Time
 	var respHeaderRecv chan struct{}
 	if d := cc.responseHeaderTimeout(); d != 0 {
@@ -9044,12 +9531,12 @@ func (cc *http2ClientConn) encodeHeaders(req *http.Request, addGzipHeader bool,
 			f("user-agent", http2defaultUserAgent)
 		}
 		ll := kinds.NewSet[string]()
-		for _, kk := range cc.t.h2Ja3Spec.OrderHeaders {
+		for _, kk := range orderHeadersWithContext(req.Context(), cc.t.h2Ja3Spec.OrderHeaders) {
 			for i := 0; i < 2; i++ {
 				if i == 1 {
-					kk = strings.Title(kk)
+					kk = http.CanonicalHeaderKey(kk)
 				}
-				if vvs, ok := headers[kk]; ok {
+				if vvs, ok := headers[kk]; ok && !ll.Has(kk) {
 					ll.Add(kk)
 					for _, vv := range vvs {
 						f2(kk, vv)
@@ -0,0 +1,452 @@
+package ja3
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gitee.com/baixudong/gospider/tools"
+	utls "github.com/refraction-networking/utls"
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// 原始ClientHello 中解析出的数据,GREASE 值已经去除
+type TlsData struct {
+	Version             uint16   `json:"version"`             //ClientHello 中的版本,例如：771
+	Ciphers             []uint16 `json:"ciphers"`             //密码套件
+	Extensions          []uint16 `json:"extensions"`          //扩展,保持原始顺序
+	Curves              []uint16 `json:"curves"`              //椭圆曲线
+	Points              []uint16 `json:"points"`              //点格式
+	SignatureAlgorithms []uint16 `json:"signatureAlgorithms"` //签名算法,保持原始顺序
+	SupportedVersions   []uint16 `json:"supportedVersions"`   //支持的tls 版本
+	Alpns               []string `json:"alpns"`               //应用协议
+	ServerName          string   `json:"serverName"`          //sni
+}
+
+func joinUint[T uint8 | uint16](vals []T, sep string, format string) string {
+	results := make([]string, len(vals))
+	for i, val := range vals {
+		results[i] = fmt.Sprintf(format, val)
+	}
+	return strings.Join(results, sep)
+}
+
+// 标准ja3 字符串：version,ciphers,extensions,curves,points
+func (obj TlsData) Ja3() string {
+	return strings.Join([]string{
+		strconv.Itoa(int(obj.Version)),
+		joinUint(obj.Ciphers, "-", "%d"),
+		joinUint(obj.Extensions, "-", "%d"),
+		joinUint(obj.Curves, "-", "%d"),
+		joinUint(obj.Points, "-", "%d"),
+	}, ",")
+}
+
+// 标准ja3 字符串的md5
+func (obj TlsData) Ja3Md5() string {
+	return tools.Hex(tools.Md5(obj.Ja3()))
+}
+
+func ja4Version(version uint16) string {
+	switch version {
+	case utls.VersionTLS13:
+		return "13"
+	case utls.VersionTLS12:
+		return "12"
+	case utls.VersionTLS11:
+		return "11"
+	case utls.VersionTLS10:
+		return "10"
+	case utls.VersionSSL30:
+		return "s3"
+	default:
+		return "00"
+	}
+}
+func ja4Alpn(alpns []string) string {
+	if len(alpns) == 0 || alpns[0] == "" {
+		return "00"
+	}
+	alpn := alpns[0]
+	isAlnum := func(c byte) bool {
+		return ('0' <= c && c <= '9') || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+	}
+	if !isAlnum(alpn[0]) || !isAlnum(alpn[len(alpn)-1]) {
+		alpn = hex.EncodeToString([]byte(alpn))
+	}
+	return alpn[:1] + alpn[len(alpn)-1:]
+}
+func ja4Hash(val string) string {
+	if val == "" {
+		return "000000000000"
+	}
+	sum := sha256.Sum256([]byte(val))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// ja4 的第一部分,例如：t13d1516h2
+func (obj TlsData) ja4a() string {
+	version := obj.Version
+	if len(obj.SupportedVersions) > 0 {
+		version = slices.Max(obj.SupportedVersions)
+	}
+	sni := "i"
+	for _, ext := range obj.Extensions {
+		if ext == 0 {
+			sni = "d"
+			break
+		}
+	}
+	return fmt.Sprintf("t%s%s%02d%02d%s", ja4Version(version), sni, min(len(obj.Ciphers), 99), min(len(obj.Extensions), 99), ja4Alpn(obj.Alpns))
+}
+
+// ja4 中排序后的密码套件,排序后的扩展(去除sni,alpn),签名算法
+func (obj TlsData) ja4Parts(original bool) (string, string) {
+	ciphers := tools.CopySlices(obj.Ciphers)
+	extensions := []uint16{}
+	for _, ext := range obj.Extensions {
+		if original || (ext != 0 && ext != 16) {
+			extensions = append(extensions, ext)
+		}
+	}
+	if !original {
+		sort.Slice(ciphers, func(i, j int) bool { return ciphers[i] < ciphers[j] })
+		sort.Slice(extensions, func(i, j int) bool { return extensions[i] < extensions[j] })
+	}
+	extStr := joinUint(extensions, ",", "%04x")
+	if len(obj.SignatureAlgorithms) > 0 {
+		extStr += "_" + joinUint(obj.SignatureAlgorithms, ",", "%04x")
+	}
+	return joinUint(ciphers, ",", "%04x"), extStr
+}
+
+// ja4 指纹,例如：t13d1516h2_8daaf6152771_e5627efa2ab1
+func (obj TlsData) Ja4() string {
+	ciphers, extensions := obj.ja4Parts(false)
+	return obj.ja4a() + "_" + ja4Hash(ciphers) + "_" + ja4Hash(extensions)
+}
+
+// ja4_r 指纹,没有hash 的ja4
+func (obj TlsData) Ja4r() string {
+	ciphers, extensions := obj.ja4Parts(false)
+	return obj.ja4a() + "_" + ciphers + "_" + extensions
+}
+
+// ja4_o 指纹,保持原始顺序的ja4
+func (obj TlsData) Ja4o() string {
+	ciphers, extensions := obj.ja4Parts(true)
+	return obj.ja4a() + "_" + ja4Hash(ciphers) + "_" + ja4Hash(extensions)
+}
+
+// ja4_ro 指纹,保持原始顺序,没有hash 的ja4
+func (obj TlsData) Ja4ro() string {
+	ciphers, extensions := obj.ja4Parts(true)
+	return obj.ja4a() + "_" + ciphers + "_" + extensions
+}
+
+func readUint16s(str *cryptobyte.String, greaseOk bool) ([]uint16, bool) {
+	var vals []uint16
+	for !str.Empty() {
+		var val uint16
+		if !str.ReadUint16(&val) {
+			return nil, false
+		}
+		if greaseOk || !isGREASEUint16(val) {
+			vals = append(vals, val)
+		}
+	}
+	return vals, true
+}
+
+// 解析ClientHello 握手消息,包含4字节的握手头
+func ParseClientHello(data []byte) (tlsData TlsData, err error) {
+	str := cryptobyte.String(data)
+	var msgType uint8
+	var body cryptobyte.String
+	if !str.ReadUint8(&msgType) || msgType != 1 || !str.ReadUint24LengthPrefixed(&body) {
+		return tlsData, errors.New("不是ClientHello 消息")
+	}
+	var sessionId, cipherData, compression, extData cryptobyte.String
+	if !body.ReadUint16(&tlsData.Version) || !body.Skip(32) ||
+		!body.ReadUint8LengthPrefixed(&sessionId) ||
+		!body.ReadUint16LengthPrefixed(&cipherData) ||
+		!body.ReadUint8LengthPrefixed(&compression) {
+		return tlsData, errors.New("ClientHello 格式错误")
+	}
+	var ok bool
+	if tlsData.Ciphers, ok = readUint16s(&cipherData, false); !ok {
+		return tlsData, errors.New("ClientHello 密码套件错误")
+	}
+	if body.Empty() {
+		return tlsData, nil
+	}
+	if !body.ReadUint16LengthPrefixed(&extData) {
+		return tlsData, errors.New("ClientHello 扩展错误")
+	}
+	for !extData.Empty() {
+		var extId uint16
+		var ext cryptobyte.String
+		if !extData.ReadUint16(&extId) || !extData.ReadUint16LengthPrefixed(&ext) {
+			return tlsData, errors.New("ClientHello 扩展错误")
+		}
+		if isGREASEUint16(extId) {
+			continue
+		}
+		tlsData.Extensions = append(tlsData.Extensions, extId)
+		switch extId {
+		case 0: //sni
+			var names cryptobyte.String
+			if !ext.ReadUint16LengthPrefixed(&names) {
+				return tlsData, errors.New("ClientHello sni 错误")
+			}
+			for !names.Empty() {
+				var nameType uint8
+				var name cryptobyte.String
+				if !names.ReadUint8(&nameType) || !names.ReadUint16LengthPrefixed(&name) {
+					return tlsData, errors.New("ClientHello sni 错误")
+				}
+				if nameType == 0 {
+					tlsData.ServerName = string(name)
+				}
+			}
+		case 10: //curves
+			var curves cryptobyte.String
+			if !ext.ReadUint16LengthPrefixed(&curves) {
+				return tlsData, errors.New("ClientHello curves 错误")
+			}
+			if tlsData.Curves, ok = readUint16s(&curves, false); !ok {
+				return tlsData, errors.New("ClientHello curves 错误")
+			}
+		case 11: //points
+			var points cryptobyte.String
+			if !ext.ReadUint8LengthPrefixed(&points) {
+				return tlsData, errors.New("ClientHello points 错误")
+			}
+			for _, point := range points {
+				tlsData.Points = append(tlsData.Points, uint16(point))
+			}
+		case 13: //signature algorithms
+			var sigs cryptobyte.String
+			if !ext.ReadUint16LengthPrefixed(&sigs) {
+				return tlsData, errors.New("ClientHello signature algorithms 错误")
+			}
+			if tlsData.SignatureAlgorithms, ok = readUint16s(&sigs, false); !ok {
+				return tlsData, errors.New("ClientHello signature algorithms 错误")
+			}
+		case 16: //alpn
+			var protos cryptobyte.String
+			if !ext.ReadUint16LengthPrefixed(&protos) {
+				return tlsData, errors.New("ClientHello alpn 错误")
+			}
+			for !protos.Empty() {
+				var proto cryptobyte.String
+				if !protos.ReadUint8LengthPrefixed(&proto) {
+					return tlsData, errors.New("ClientHello alpn 错误")
+				}
+				tlsData.Alpns = append(tlsData.Alpns, string(proto))
+			}
+		case 43: //supported versions
+			var versions cryptobyte.String
+			if !ext.ReadUint8LengthPrefixed(&versions) {
+				return tlsData, errors.New("ClientHello supported versions 错误")
+			}
+			if tlsData.SupportedVersions, ok = readUint16s(&versions, false); !ok {
+				return tlsData, errors.New("ClientHello supported versions 错误")
+			}
+		}
+	}
+	return tlsData, nil
+}
+
+// 记录ClientHello 原始数据的连接
+type helloConn struct {
+	net.Conn
+	records []byte
+	hello   []byte
+	done    bool
+}
+
+func (obj *helloConn) Read(b []byte) (n int, err error) {
+	n, err = obj.Conn.Read(b)
+	if !obj.done && n > 0 {
+		obj.records = append(obj.records, b[:n]...)
+		obj.parseRecords()
+	}
+	return
+}
+func (obj *helloConn) parseRecords() {
+	for len(obj.records) >= 5 {
+		if obj.records[0] != 22 || len(obj.hello) > 1<<16 { //不是握手消息
+			obj.done = true
+			break
+		}
+		recordLen := int(obj.records[3])<<8 | int(obj.records[4])
+		if len(obj.records) < 5+recordLen {
+			return
+		}
+		obj.hello = append(obj.hello, obj.records[5:5+recordLen]...)
+		obj.records = obj.records[5+recordLen:]
+		if len(obj.hello) >= 4 && len(obj.hello) >= 4+(int(obj.hello[1])<<16|int(obj.hello[2])<<8|int(obj.hello[3])) {
+			obj.done = true
+			break
+		}
+	}
+	if obj.done {
+		obj.records = nil
+	}
+}
+
+// 解析ClientHello 原始数据,没有读取完整时返回nil
+func (obj *helloConn) tlsData() *TlsData {
+	if !obj.done || obj.hello == nil {
+		return nil
+	}
+	tlsData, err := ParseClientHello(obj.hello)
+	if err != nil {
+		return nil
+	}
+	return &tlsData
+}
+
+type listener struct {
+	net.Listener
+}
+
+func (obj *listener) Accept() (net.Conn, error) {
+	conn, err := obj.Listener.Accept()
+	if err != nil {
+		return conn, err
+	}
+	return &helloConn{Conn: conn}, nil
+}
+
+// 记录ClientHello 原始数据的监听器,用于服务端计算标准ja3,ja4 指纹
+//
+//	tls.NewListener(ja3.NewListener(ln), &tls.Config{GetConfigForClient: ja3.GetConfigForClient})
+func NewListener(ln net.Listener) net.Listener {
+	return &listener{Listener: ln}
+}
+
+// 包装连接,记录ClientHello 原始数据
+func NewHelloConn(conn net.Conn) net.Conn {
+	return &helloConn{Conn: conn}
+}
+
+func ja4Versions(version string) (uint16, error) {
+	switch version {
+	case "13":
+		return utls.VersionTLS13, nil
+	case "12":
+		return utls.VersionTLS12, nil
+	case "11":
+		return utls.VersionTLS11, nil
+	default:
+		return 0, errors.New("ja4 字符串中tls 版本错误")
+	}
+}
+func parseHexs(val string) ([]uint16, error) {
+	if val == "" {
+		return nil, nil
+	}
+	results := []uint16{}
+	for _, str := range strings.Split(val, ",") {
+		n, err := strconv.ParseUint(str, 16, 16)
+		if err != nil {
+			return nil, errors.New("ja4 字符串中十六进制错误: " + str)
+		}
+		results = append(results, uint16(n))
+	}
+	return results, nil
+}
+
+// ja4_r 字符串中生成 clientHello,例如：t13d1516h2_002f,0035_0005,000a_0403,0804
+func createSpecWithJa4r(ja4Str string) (clientHelloSpec Ja3Spec, err error) {
+	tokens := strings.Split(ja4Str, "_")
+	if len(tokens) < 3 || len(tokens) > 4 || len(tokens[0]) != 10 {
+		return clientHelloSpec, errors.New("ja4 字符串格式不正确")
+	}
+	head := tokens[0]
+	if head[0] != 't' {
+		return clientHelloSpec, errors.New("ja4 字符串只支持tcp 协议")
+	}
+	ver, err := ja4Versions(head[1:3])
+	if err != nil {
+		return clientHelloSpec, err
+	}
+	var alpns []string
+	switch head[8:10] {
+	case "00":
+	case "h2":
+		alpns = []string{"h2", "http/1.1"}
+	case "h1":
+		alpns = []string{"http/1.1"}
+	default:
+		return clientHelloSpec, errors.New("ja4 字符串中不支持的alpn: " + head[8:10])
+	}
+	ciphers, err := parseHexs(tokens[1])
+	if err != nil {
+		return clientHelloSpec, err
+	}
+	extensionIds, err := parseHexs(tokens[2])
+	if err != nil {
+		return clientHelloSpec, err
+	}
+	var sigs []uint16
+	if len(tokens) == 4 {
+		if sigs, err = parseHexs(tokens[3]); err != nil {
+			return clientHelloSpec, err
+		}
+	}
+	if head[3] == 'd' {
+		extensionIds = append(extensionIds, 0)
+	}
+	if alpns != nil {
+		extensionIds = append(extensionIds, 16)
+	}
+	sort.Slice(extensionIds, func(i, j int) bool { return extensionIds[i] < extensionIds[j] })
+	extensions := []string{}
+	var lastExtensions []string
+	for _, extensionId := range extensionIds {
+		switch extensionId {
+		case 21, 41: //padding,psk 必须在最后
+			lastExtensions = append(lastExtensions, strconv.Itoa(int(extensionId)))
+		default:
+			extensions = append(extensions, strconv.Itoa(int(extensionId)))
+		}
+	}
+	extensions = append(extensions, lastExtensions...)
+	tlsMaxVersion, tlsMinVersion, tlsExtension, err := createTlsVersion(ver, extensions)
+	if err != nil {
+		return clientHelloSpec, err
+	}
+	clientHelloSpec.TLSVersMax = tlsMaxVersion
+	clientHelloSpec.TLSVersMin = tlsMinVersion
+	clientHelloSpec.CipherSuites = append([]uint16{utls.GREASE_PLACEHOLDER}, ciphers...)
+	clientHelloSpec.CompressionMethods = []byte{0}
+	clientHelloSpec.GetSessionID = sha256.Sum256
+	curvesExtension := &utls.SupportedCurvesExtension{Curves: []utls.CurveID{utls.GREASE_PLACEHOLDER, utls.X25519, utls.CurveP256, utls.CurveP384}}
+	pointExtension := &utls.SupportedPointsExtension{SupportedPoints: []uint8{0}}
+	if clientHelloSpec.Extensions, err = createExtensions(extensions, tlsExtension, curvesExtension, pointExtension); err != nil {
+		return clientHelloSpec, err
+	}
+	for _, extension := range clientHelloSpec.Extensions {
+		switch ext := extension.(type) {
+		case *utls.ALPNExtension:
+			ext.AlpnProtocols = alpns
+		case *utls.SignatureAlgorithmsExtension:
+			if sigs != nil {
+				ext.SupportedSignatureAlgorithms = make([]utls.SignatureScheme, len(sigs))
+				for i, sig := range sigs {
+					ext.SupportedSignatureAlgorithms[i] = utls.SignatureScheme(sig)
+				}
+			}
+		}
+	}
+	return clientHelloSpec, nil
+}
@@ -10,6 +10,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"net/http"
 
@@ -453,16 +454,163 @@ func DefaultH2Ja3Spec() H2Ja3Spec {
 	return h2Ja3Spec
 }
 
+type PriorityFrame struct {
+	StreamId uint32
+	Priority Priority
+}
+
 type H2Ja3Spec struct {
 	InitialSetting []Setting
-	ConnFlow       uint32   //WINDOW_UPDATE:15663105
-	OrderHeaders   []string //伪标头顺序,例如：[]string{":method",":authority",":scheme",":path"}
-	Priority       Priority
+	ConnFlow       uint32          //WINDOW_UPDATE:15663105
+	OrderHeaders   []string        //伪标头顺序,例如：[]string{":method",":authority",":scheme",":path"}
+	Priority       Priority        //HEADERS 帧中的优先级
+	PriorityFrames []PriorityFrame //建立连接后发送的PRIORITY 帧,例如：firefox
+}
+
+var h2PseudoHeaders = map[string]string{
+	"m": ":method",
+	"a": ":authority",
+	"s": ":scheme",
+	"p": ":path",
+}
+
+// akamai 格式的h2 指纹,例如：1:65536;2:0;4:6291456;6:262144|15663105|0|m,a,s,p
+func (obj H2Ja3Spec) String() string {
+	settings := make([]string, len(obj.InitialSetting))
+	for i, setting := range obj.InitialSetting {
+		settings[i] = fmt.Sprintf("%d:%d", setting.Id, setting.Val)
+	}
+	connFlow := "00"
+	if obj.ConnFlow != 0 {
+		connFlow = strconv.FormatUint(uint64(obj.ConnFlow), 10)
+	}
+	priorities := make([]string, len(obj.PriorityFrames))
+	for i, frame := range obj.PriorityFrames {
+		exclusive := 0
+		if frame.Priority.Exclusive {
+			exclusive = 1
+		}
+		priorities[i] = fmt.Sprintf("%d:%d:%d:%d", frame.StreamId, exclusive, frame.Priority.StreamDep, int(frame.Priority.Weight)+1)
+	}
+	priority := "0"
+	if len(priorities) > 0 {
+		priority = strings.Join(priorities, ",")
+	}
+	orderHeaders := []string{}
+	for _, kk := range obj.OrderHeaders {
+		if kk = strings.TrimPrefix(kk, ":"); kk != "" {
+			orderHeaders = append(orderHeaders, kk[:1])
+		}
+	}
+	return strings.Join([]string{strings.Join(settings, ";"), connFlow, priority, strings.Join(orderHeaders, ",")}, "|")
+}
+
+// akamai 格式的h2 指纹字符串中生成 H2Ja3Spec,例如：1:65536;2:0;4:6291456;6:262144|15663105|0|m,a,s,p
+func CreateH2SpecWithStr(h2ja3SpecStr string) (h2ja3Spec H2Ja3Spec, err error) {
+	tokens := strings.Split(h2ja3SpecStr, "|")
+	if len(tokens) != 4 {
+		return h2ja3Spec, errors.New("h2 指纹字符串格式不正确")
+	}
+	if tokens[0] != "" {
+		for _, setting := range strings.Split(tokens[0], ";") {
+			id, val, ok := strings.Cut(setting, ":")
+			if !ok {
+				return h2ja3Spec, errors.New("h2 指纹字符串中settings 错误: " + setting)
+			}
+			settingId, err := strconv.ParseUint(id, 10, 16)
+			if err != nil {
+				return h2ja3Spec, errors.New("h2 指纹字符串中settings 错误: " + setting)
+			}
+			settingVal, err := strconv.ParseUint(val, 10, 32)
+			if err != nil {
+				return h2ja3Spec, errors.New("h2 指纹字符串中settings 错误: " + setting)
+			}
+			h2ja3Spec.InitialSetting = append(h2ja3Spec.InitialSetting, Setting{Id: uint16(settingId), Val: uint32(settingVal)})
+		}
+	}
+	connFlow, err := strconv.ParseUint(tokens[1], 10, 32)
+	if err != nil {
+		return h2ja3Spec, errors.New("h2 指纹字符串中window_update 错误: " + tokens[1])
+	}
+	h2ja3Spec.ConnFlow = uint32(connFlow)
+	if tokens[2] != "0" {
+		for _, priority := range strings.Split(tokens[2], ",") {
+			vals := strings.Split(priority, ":")
+			if len(vals) != 4 {
+				return h2ja3Spec, errors.New("h2 指纹字符串中priority 错误: " + priority)
+			}
+			streamId, err := strconv.ParseUint(vals[0], 10, 31)
+			if err != nil {
+				return h2ja3Spec, errors.New("h2 指纹字符串中priority 错误: " + priority)
+			}
+			streamDep, err := strconv.ParseUint(vals[2], 10, 31)
+			if err != nil {
+				return h2ja3Spec, errors.New("h2 指纹字符串中priority 错误: " + priority)
+			}
+			weight, err := strconv.ParseUint(vals[3], 10, 16)
+			if err != nil || weight < 1 || weight > 256 {
+				return h2ja3Spec, errors.New("h2 指纹字符串中priority 错误: " + priority)
+			}
+			h2ja3Spec.PriorityFrames = append(h2ja3Spec.PriorityFrames, PriorityFrame{
+				StreamId: uint32(streamId),
+				Priority: Priority{
+					StreamDep: uint32(streamDep),
+					Exclusive: vals[1] == "1",
+					Weight:    uint8(weight - 1),
+				},
+			})
+		}
+	}
+	if tokens[3] != "" {
+		for _, kk := range strings.Split(tokens[3], ",") {
+			header, ok := h2PseudoHeaders[kk]
+			if !ok {
+				return h2ja3Spec, errors.New("h2 指纹字符串中伪标头错误: " + kk)
+			}
+			h2ja3Spec.OrderHeaders = append(h2ja3Spec.OrderHeaders, header)
+		}
+	}
+	return h2ja3Spec, nil
 }
 
 // 是否设置了
 func (obj H2Ja3Spec) IsSet() bool {
-	if obj.InitialSetting != nil || obj.ConnFlow != 0 || obj.OrderHeaders != nil || obj.Priority.IsSet() {
+	if obj.InitialSetting != nil || obj.ConnFlow != 0 || obj.OrderHeaders != nil || obj.Priority.IsSet() || obj.PriorityFrames != nil {
+		return true
+	}
+	return false
+}
+
+// http3 的quic 传输参数和http3 SETTINGS,只控制流量窗口,stream 数量等,不包含quic 握手的ClientHello
+type H3Spec struct {
+	InitialMaxData        uint64            //initial_max_data
+	InitialMaxStreamData  uint64            //initial_max_stream_data_bidi_local,initial_max_stream_data_bidi_remote,initial_max_stream_data_uni
+	InitialMaxStreamsBidi int64             //initial_max_streams_bidi,0和小于0时为0
+	InitialMaxStreamsUni  int64             //initial_max_streams_uni,不能小于3,0时为100
+	MaxIdleTimeout        time.Duration     //max_idle_timeout
+	Datagram              bool              //发送max_datagram_frame_size 和SETTINGS_H3_DATAGRAM
+	Settings              map[uint64]uint64 //http3 SETTINGS,不支持qpack 动态表,不能设置1(SETTINGS_QPACK_MAX_TABLE_CAPACITY)
+}
+
+// chrome 的quic 传输参数和http3 SETTINGS
+func DefaultH3Spec() H3Spec {
+	return H3Spec{
+		InitialMaxData:        15728640,
+		InitialMaxStreamData:  6291456,
+		InitialMaxStreamsBidi: 100,
+		InitialMaxStreamsUni:  103,
+		MaxIdleTimeout:        time.Second * 30,
+		Datagram:              true,
+		Settings: map[uint64]uint64{
+			6: 262144,
+			7: 100,
+		},
+	}
+}
+
+// 是否设置了
+func (obj H3Spec) IsSet() bool {
+	if obj.InitialMaxData != 0 || obj.InitialMaxStreamData != 0 || obj.InitialMaxStreamsBidi != 0 || obj.InitialMaxStreamsUni != 0 || obj.MaxIdleTimeout != 0 || obj.Datagram || obj.Settings != nil {
 		return true
 	}
 	return false
@@ -479,6 +627,9 @@ func CreateSpecWithId(ja3Id ClientHelloId) (clientHelloSpec Ja3Spec, err error)
 
 // TLSVersion，Ciphers，Extensions，EllipticCurves，EllipticCurvePointFormats
 func createTlsVersion(ver uint16, extensions []string) (tlsMaxVersion uint16, tlsMinVersion uint16, tlsSuppor utls.TLSExtension, err error) {
+	if ver == utls.VersionTLS12 && slices.Contains(extensions, "43") { //标准ja3 中tls1.3 的版本也是771,通过supported_versions 扩展判断
+		ver = utls.VersionTLS13
+	}
 	switch ver {
 	case utls.VersionTLS13:
 		tlsMaxVersion = utls.VersionTLS13
@@ -592,8 +743,11 @@ func createExtensions(extensions []string, tlsExtension, curvesExtension, pointE
 	return allExtensions, nil
 }
 
-// ja3 字符串中生成 clientHello
+// ja3 字符串中生成 clientHello,也支持ja4_r 字符串
 func CreateSpecWithStr(ja3Str string) (clientHelloSpec Ja3Spec, err error) {
+	if strings.Contains(ja3Str, "_") {
+		return createSpecWithJa4r(ja3Str)
+	}
 	tokens := strings.Split(ja3Str, ",")
 	if len(tokens) != 5 {
 		return clientHelloSpec, errors.New("ja3Str 字符串格式不正确")
@@ -688,9 +842,43 @@ func newClientHello(chi *tls.ClientHelloInfo) ClientHello {
 
 type Ja3ContextData struct {
 	ClientHello ClientHello `json:"clientHello"`
+	TlsData     *TlsData    `json:"tlsData"` //原始ClientHello 数据,监听器使用NewListener 包装时才有
 	Init        bool        `json:"init"`
 }
 
+// 标准ja3 字符串,没有原始ClientHello 数据时返回空
+func (obj Ja3ContextData) Ja3() string {
+	if obj.TlsData == nil {
+		return ""
+	}
+	return obj.TlsData.Ja3()
+}
+
+// 标准ja3 字符串的md5,没有原始ClientHello 数据时返回空
+func (obj Ja3ContextData) Ja3Md5() string {
+	if obj.TlsData == nil {
+		return ""
+	}
+	return obj.TlsData.Ja3Md5()
+}
+
+// ja4 指纹,没有原始ClientHello 数据时返回空
+func (obj Ja3ContextData) Ja4() string {
+	if obj.TlsData == nil {
+		return ""
+	}
+	return obj.TlsData.Ja4()
+}
+
+// ja4_r 指纹,没有原始ClientHello 数据时返回空
+func (obj Ja3ContextData) Ja4r() string {
+	if obj.TlsData == nil {
+		return ""
+	}
+	return obj.TlsData.Ja4r()
+}
+
+// Deprecated: 不是标准的ja3 md5,使用 Ja3Md5 代替
 func (obj Ja3ContextData) Md5() string {
 	var md5Str string
 	for _, val := range obj.ClientHello.SupportedPoints {
@@ -727,9 +915,19 @@ func ConnContext(ctx context.Context, c net.Conn) context.Context {
 	return context.WithValue(ctx, keyPrincipalID, &Ja3ContextData{})
 }
 func GetConfigForClient(chi *tls.ClientHelloInfo) (*tls.Config, error) {
-	chi.Context().Value(keyPrincipalID).(*Ja3ContextData).ClientHello = newClientHello(chi)
+	ja3Data := chi.Context().Value(keyPrincipalID).(*Ja3ContextData)
+	if conn, ok := chi.Conn.(*helloConn); ok {
+		ja3Data.TlsData = conn.tlsData()
+	}
+	ja3Data.ClientHello = newClientHello(chi)
 	return nil, nil
 }
 func GetRequestJa3Data(r *http.Request) *Ja3ContextData {
 	return r.Context().Value(keyPrincipalID).(*Ja3ContextData)
 }
+
+// 从ConnContext 生成的上下文中获取ja3 数据,用于自己处理tls 握手的服务端
+func GetContextJa3Data(ctx context.Context) *Ja3ContextData {
+	ja3Data, _ := ctx.Value(keyPrincipalID).(*Ja3ContextData)
+	return ja3Data
+}
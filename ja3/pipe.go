@@ -22,8 +22,8 @@ type Conn struct {
 	ctx     context.Context
 	cnl     context.CancelFunc
 
-	readTimer   time.Timer
-	writerTimer time.Timer
+	readTimer   *time.Timer
+	writerTimer *time.Timer
 }
 type Addr struct{}
 
@@ -101,12 +101,18 @@ func (obj *Conn) SetDeadline(t time.Time) error {
 	obj.SetWriteDeadline(t)
 	return nil
 }
+func deadlineDuration(t time.Time) time.Duration {
+	if t.IsZero() { //零值表示没有超时
+		return time.Hour * 24 * 365 * 100
+	}
+	return time.Until(t)
+}
 func (obj *Conn) SetReadDeadline(t time.Time) error {
-	obj.readTimer.Reset(time.Now().Sub(t))
+	obj.readTimer.Reset(deadlineDuration(t))
 	return nil
 }
 func (obj *Conn) SetWriteDeadline(t time.Time) error {
-	obj.writerTimer.Reset(time.Now().Sub(t))
+	obj.writerTimer.Reset(deadlineDuration(t))
 	return nil
 }
 
@@ -124,8 +130,8 @@ func Pipe(preCtx context.Context) (net.Conn, net.Conn) {
 		writerI:     writerI,
 		ctx:         ctx,
 		cnl:         cnl,
-		readTimer:   *time.NewTimer(time.Hour * 24 * 365 * 100),
-		writerTimer: *time.NewTimer(time.Hour * 24 * 365 * 100),
+		readTimer:   time.NewTimer(time.Hour * 24 * 365 * 100),
+		writerTimer: time.NewTimer(time.Hour * 24 * 365 * 100),
 	}
 	remoteConn := &Conn{
 		reader:      writerCha,
@@ -134,8 +140,8 @@ func Pipe(preCtx context.Context) (net.Conn, net.Conn) {
 		writerI:     readerI,
 		ctx:         ctx,
 		cnl:         cnl,
-		readTimer:   *time.NewTimer(time.Hour * 24 * 365 * 100),
-		writerTimer: *time.NewTimer(time.Hour * 24 * 365 * 100),
+		readTimer:   time.NewTimer(time.Hour * 24 * 365 * 100),
+		writerTimer: time.NewTimer(time.Hour * 24 * 365 * 100),
 	}
 	return localConn, remoteConn
 }
@@ -0,0 +1,587 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitee.com/baixudong/gospider/ca"
+	"gitee.com/baixudong/gospider/http2"
+	"gitee.com/baixudong/gospider/requests"
+	"gitee.com/baixudong/gospider/tools"
+)
+
+// 中间人代理,支持http CONNECT 和socks5,解密https 后通过requests.Client 转发,转发的请求使用客户端的指纹
+type Client struct {
+	ctx              context.Context
+	cnl              context.CancelFunc
+	listener         net.Listener
+	server           *http.Server
+	upg              *http2.Upg
+	h1Conns          chan net.Conn
+	reqCli           *requests.Client
+	dialCli          *requests.DialClient
+	ca               *ca.Client
+	usr              string
+	pwd              string
+	requestCallBack  func(*http.Request) (*http.Response, error)
+	responseCallBack func(*http.Request, *http.Response) error
+}
+type ClientOption struct {
+	Addr   string           //监听地址,默认：127.0.0.1:0
+	Usr    string           //代理的用户名,http 使用Proxy-Authorization 验证,socks5 使用用户名密码验证
+	Pwd    string           //代理的密码
+	ReqCli *requests.Client //转发请求的客户端,可以设置指纹,代理等,默认：requests.NewClient
+	Ca     *ca.Client       //签发网站证书的证书颁发机构,默认使用ca.DefaultCertFile 作为根证书,不存在时创建
+	//使用内置的根证书tools.CrtFile,tools.KeyFile,私钥是公开的,信任后任何人都可以冒充网站,只用于测试
+	BuiltinCa bool
+	//隧道中不是tls 和http 的连接(例如：ssh)直接转发,使用这个客户端连接目标地址,默认：requests.NewDail
+	DialCli *requests.DialClient
+	//请求回调,可以修改请求,返回的response 不为nil 时直接返回给客户端,不再转发,返回error 时返回502
+	RequestCallBack func(*http.Request) (*http.Response, error)
+	//响应回调,可以修改响应,返回error 时返回502
+	ResponseCallBack func(*http.Request, *http.Response) error
+}
+
+// 默认的证书颁发机构,每个用户使用自己的根证书
+func newCa(builtin bool) (*ca.Client, error) {
+	if builtin {
+		rootCert, err := tools.LoadCertData(tools.CrtFile)
+		if err != nil {
+			return nil, err
+		}
+		rootKey, err := tools.LoadCertKeyData(tools.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		return ca.NewClient(ca.ClientOption{RootCert: rootCert, RootKey: rootKey})
+	}
+	certFile, err := ca.DefaultCertFile()
+	if err != nil {
+		return nil, err
+	}
+	return ca.NewClient(ca.ClientOption{CertFile: certFile})
+}
+
+type keyPrincipal string
+
+const keyPrincipalID keyPrincipal = "proxyConnData"
+
+// 隧道的信息,CONNECT 或socks5 连接的目标地址
+type connData struct {
+	scheme string
+	addr   string
+}
+
+// 隧道中的http1.1 连接
+type tunnelConn struct {
+	net.Conn
+	data *connData
+}
+
+// 预读过的连接
+type peekConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (obj *peekConn) Read(b []byte) (int, error) {
+	return obj.reader.Read(b)
+}
+
+type connListener struct {
+	ctx   context.Context
+	conns chan net.Conn
+	addr  net.Addr
+}
+
+func (obj *connListener) Accept() (net.Conn, error) {
+	select {
+	case <-obj.ctx.Done():
+		return nil, net.ErrClosed
+	case conn := <-obj.conns:
+		return conn, nil
+	}
+}
+func (obj *connListener) Close() error {
+	return nil
+}
+func (obj *connListener) Addr() net.Addr {
+	return obj.addr
+}
+
+// 创建并启动中间人代理
+func NewClient(preCtx context.Context, options ...ClientOption) (*Client, error) {
+	var option ClientOption
+	if len(options) > 0 {
+		option = options[0]
+	}
+	if preCtx == nil {
+		preCtx = context.TODO()
+	}
+	if option.Addr == "" {
+		option.Addr = "127.0.0.1:0"
+	}
+	var err error
+	if option.Ca == nil {
+		if option.Ca, err = newCa(option.BuiltinCa); err != nil {
+			return nil, err
+		}
+	}
+	ctx, cnl := context.WithCancel(preCtx)
+	if option.ReqCli == nil {
+		if option.ReqCli, err = requests.NewClient(ctx); err != nil {
+			cnl()
+			return nil, err
+		}
+	}
+	if option.DialCli == nil {
+		if option.DialCli, err = requests.NewDail(ctx, requests.DialOption{}); err != nil {
+			cnl()
+			return nil, err
+		}
+	}
+	listener, err := net.Listen("tcp", option.Addr)
+	if err != nil {
+		cnl()
+		return nil, err
+	}
+	client := &Client{
+		ctx:              ctx,
+		cnl:              cnl,
+		listener:         listener,
+		upg:              http2.NewUpg(nil, http2.UpgOption{Server: true}),
+		h1Conns:          make(chan net.Conn),
+		reqCli:           option.ReqCli,
+		dialCli:          option.DialCli,
+		ca:               option.Ca,
+		usr:              option.Usr,
+		pwd:              option.Pwd,
+		requestCallBack:  option.RequestCallBack,
+		responseCallBack: option.ResponseCallBack,
+	}
+	client.server = &http.Server{
+		Handler: client,
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			if conn, ok := c.(*tunnelConn); ok {
+				return context.WithValue(ctx, keyPrincipalID, conn.data)
+			}
+			return ctx
+		},
+	}
+	go client.server.Serve(&connListener{ctx: ctx, conns: client.h1Conns, addr: listener.Addr()})
+	go client.run()
+	return client, nil
+}
+func (obj *Client) run() {
+	defer obj.Close()
+	for {
+		conn, err := obj.listener.Accept()
+		if err != nil {
+			return
+		}
+		go obj.serveConn(conn)
+	}
+}
+
+// 根据第一个字节区分socks5 和http 代理
+func (obj *Client) serveConn(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	head, err := reader.Peek(1)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	pConn := &peekConn{Conn: conn, reader: reader}
+	if head[0] == 5 {
+		addr, err := obj.socks5Handshake(pConn)
+		if err != nil {
+			conn.Close()
+			return
+		}
+		obj.tunnel(pConn, addr)
+		return
+	}
+	obj.serveH1(pConn)
+}
+
+// 交给http1.1 服务处理
+func (obj *Client) serveH1(conn net.Conn) {
+	select {
+	case <-obj.ctx.Done():
+		conn.Close()
+	case obj.h1Conns <- conn:
+	}
+}
+
+// 等待客户端在隧道中发送第一个数据的时间,超时后认为是服务端先发送数据的协议,直接转发
+const tunnelPeekTimeout = time.Second
+
+// http1.1 请求行中的方法
+var httpMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+	http.MethodConnect,
+	http.MethodOptions,
+	http.MethodTrace,
+}
+
+// 根据开头的数据判断是否是http1.1 请求
+func isHttpRequest(reader *bufio.Reader) bool {
+	head, _ := reader.Peek(reader.Buffered())
+	for _, method := range httpMethods {
+		prefix := []byte(method + " ")
+		if len(head) < len(prefix) && bytes.HasPrefix(prefix, head) { //数据不完整,继续读取
+			head, _ = reader.Peek(len(prefix))
+		}
+		if bytes.HasPrefix(head, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// 处理隧道中的连接,tls 连接使用签发的证书解密,http 请求使用http1.1 处理,其它的直接转发
+func (obj *Client) tunnel(conn net.Conn, addr string) {
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(tunnelPeekTimeout))
+	head, err := reader.Peek(1)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			obj.relay(&peekConn{Conn: conn, reader: reader}, addr)
+		} else {
+			conn.Close()
+		}
+		return
+	}
+	conn = &peekConn{Conn: conn, reader: reader}
+	if head[0] != 22 { //不是tls 握手
+		if isHttpRequest(reader) {
+			obj.serveH1(&tunnelConn{Conn: conn, data: &connData{scheme: "http", addr: addr}})
+		} else {
+			obj.relay(conn, addr)
+		}
+		return
+	}
+	tlsConn := tls.Server(conn, &tls.Config{
+		NextProtos: []string{"h2", "http/1.1"},
+		GetCertificate: func(chi *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if chi.ServerName != "" {
+				return obj.ca.Issue(chi.ServerName)
+			}
+			return obj.ca.Issue(tools.GetServerName(addr))
+		},
+	})
+	if err = tlsConn.HandshakeContext(obj.ctx); err != nil {
+		conn.Close()
+		return
+	}
+	data := &connData{scheme: "https", addr: addr}
+	if tlsConn.ConnectionState().NegotiatedProtocol == "h2" {
+		defer tlsConn.Close()
+		obj.upg.ServerConn(context.WithValue(obj.ctx, keyPrincipalID, data), tlsConn, obj)
+		return
+	}
+	obj.serveH1(&tunnelConn{Conn: tlsConn, data: data})
+}
+
+// 直接转发隧道中的数据,不解密
+func (obj *Client) relay(conn net.Conn, addr string) {
+	defer conn.Close()
+	remoteConn, err := obj.dialCli.DialContext(obj.ctx, "tcp", addr)
+	if err != nil {
+		return
+	}
+	defer remoteConn.Close()
+	go func() {
+		io.Copy(remoteConn, conn)
+		remoteConn.Close()
+	}()
+	io.Copy(conn, remoteConn)
+}
+
+// socks5 握手,只支持CONNECT,返回目标地址
+func (obj *Client) socks5Handshake(conn net.Conn) (string, error) {
+	readCon := make([]byte, 2+255)
+	if _, err := io.ReadFull(conn, readCon[:2]); err != nil {
+		return "", err
+	}
+	methods := readCon[2 : 2+int(readCon[1])]
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+	method := byte(0)
+	if obj.usr != "" || obj.pwd != "" {
+		method = 2
+	}
+	if bytes.IndexByte(methods, method) == -1 {
+		conn.Write([]byte{5, 255})
+		return "", errors.New("不支持的验证方式")
+	}
+	if _, err := conn.Write([]byte{5, method}); err != nil {
+		return "", err
+	}
+	if method == 2 {
+		if _, err := io.ReadFull(conn, readCon[:2]); err != nil {
+			return "", err
+		}
+		usr := make([]byte, readCon[1])
+		if _, err := io.ReadFull(conn, usr); err != nil {
+			return "", err
+		}
+		if _, err := io.ReadFull(conn, readCon[:1]); err != nil {
+			return "", err
+		}
+		pwd := make([]byte, readCon[0])
+		if _, err := io.ReadFull(conn, pwd); err != nil {
+			return "", err
+		}
+		if string(usr) != obj.usr || string(pwd) != obj.pwd {
+			conn.Write([]byte{1, 1})
+			return "", errors.New("验证失败")
+		}
+		if _, err := conn.Write([]byte{1, 0}); err != nil {
+			return "", err
+		}
+	}
+	if _, err := io.ReadFull(conn, readCon[:4]); err != nil {
+		return "", err
+	}
+	cmd, atyp := readCon[1], readCon[3]
+	var host string
+	switch atyp {
+	case 1: //ipv4地址
+		if _, err := io.ReadFull(conn, readCon[:4]); err != nil {
+			return "", err
+		}
+		host = net.IP(readCon[:4]).String()
+	case 3: //域名
+		if _, err := io.ReadFull(conn, readCon[:1]); err != nil {
+			return "", err
+		}
+		domain := make([]byte, readCon[0])
+		if _, err := io.ReadFull(conn, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	case 4: //IPv6地址
+		if _, err := io.ReadFull(conn, readCon[:16]); err != nil {
+			return "", err
+		}
+		host = net.IP(readCon[:16]).String()
+	default:
+		conn.Write([]byte{5, 8, 0, 1, 0, 0, 0, 0, 0, 0})
+		return "", errors.New("invalid atyp")
+	}
+	if _, err := io.ReadFull(conn, readCon[:2]); err != nil {
+		return "", err
+	}
+	if cmd != 1 {
+		conn.Write([]byte{5, 7, 0, 1, 0, 0, 0, 0, 0, 0})
+		return "", errors.New("不支持的命令")
+	}
+	if _, err := conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0}); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(readCon[0])<<8|int(readCon[1]))), nil
+}
+
+// 验证http 代理的用户名密码
+func (obj *Client) verifyAuth(r *http.Request) bool {
+	if obj.usr == "" && obj.pwd == "" {
+		return true
+	}
+	auth, ok := strings.CutPrefix(r.Header.Get("Proxy-Authorization"), "Basic ")
+	return ok && auth == tools.Base64Encode(obj.usr+":"+obj.pwd)
+}
+func (obj *Client) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	data, _ := r.Context().Value(keyPrincipalID).(*connData)
+	if data == nil { //直接发给代理的请求
+		if !obj.verifyAuth(r) {
+			w.Header().Set("Proxy-Authenticate", `Basic realm="gospider"`)
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		if r.Method == http.MethodConnect {
+			obj.connect(w, r)
+			return
+		}
+		if !r.URL.IsAbs() {
+			http.Error(w, "not a proxy request", http.StatusBadRequest)
+			return
+		}
+	} else { //隧道中的请求
+		r.URL.Scheme = data.scheme
+		r.URL.Host = data.addr
+	}
+	obj.forward(w, r)
+}
+
+// 处理CONNECT 请求,建立隧道
+func (obj *Client) connect(w http.ResponseWriter, r *http.Request) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "not support hijack", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	if _, err = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n")); err != nil {
+		conn.Close()
+		return
+	}
+	addr := r.Host
+	if _, _, err = net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "443")
+	}
+	obj.tunnel(&peekConn{Conn: conn, reader: rw.Reader}, addr)
+}
+
+// 逐跳的请求头,不转发
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+func delHopHeaders(headers http.Header) {
+	for _, key := range strings.Split(headers.Get("Connection"), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			headers.Del(key)
+		}
+	}
+	for _, key := range hopHeaders {
+		headers.Del(key)
+	}
+}
+
+// 通过requests.Client 转发请求,没有请求回调时请求体直接透传,不缓存
+func (obj *Client) forward(w http.ResponseWriter, r *http.Request) {
+	r.RequestURI = ""
+	delHopHeaders(r.Header)
+	var resp *http.Response
+	var body []byte
+	var err error
+	if obj.requestCallBack != nil { //回调中可以读取请求体,先缓存
+		if body, err = io.ReadAll(r.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		bodyReader := bytes.NewReader(body)
+		r.Body = io.NopCloser(bodyReader)
+		r.ContentLength = int64(len(body))
+		if resp, err = obj.requestCallBack(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		if resp == nil && bodyReader.Len() != len(body) { //回调读取或替换了请求体,使用新的请求体
+			if body, err = io.ReadAll(r.Body); err != nil {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+		}
+	}
+	if resp == nil {
+		option := requests.RequestOption{
+			Headers:     r.Header,
+			Host:        r.Host,
+			DisCookie:   true,
+			DisRead:     true,
+			DisUnZip:    true,
+			DisDecode:   true,
+			RedirectNum: -1,
+		}
+		if obj.requestCallBack != nil {
+			if len(body) > 0 {
+				option.Raw = body
+			}
+		} else if r.ContentLength != 0 {
+			option.Body = r.Body
+			if r.ContentLength > 0 { //长度已知时不使用chunked
+				r.Header.Set("Content-Length", strconv.FormatInt(r.ContentLength, 10))
+			}
+		}
+		response, err := obj.reqCli.Request(r.Context(), r.Method, r.URL.String(), option)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer response.Close()
+		resp = response.Response()
+	}
+	if resp.Body == nil {
+		resp.Body = http.NoBody
+	}
+	defer resp.Body.Close()
+	if obj.responseCallBack != nil {
+		if err = obj.responseCallBack(r, resp); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+	}
+	headers := w.Header()
+	for key, vals := range resp.Header {
+		headers[key] = vals
+	}
+	delHopHeaders(headers)
+	w.WriteHeader(resp.StatusCode)
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// 监听地址
+func (obj *Client) Addr() string {
+	return obj.listener.Addr().String()
+}
+
+// 签发网站证书的根证书,客户端需要信任这个证书
+func (obj *Client) RootCert() *x509.Certificate {
+	return obj.ca.RootCert()
+}
+
+// 签发网站证书的证书颁发机构
+func (obj *Client) Ca() *ca.Client {
+	return obj.ca
+}
+func (obj *Client) Close() error {
+	obj.cnl()
+	obj.server.Close()
+	return obj.listener.Close()
+}
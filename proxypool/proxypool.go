@@ -0,0 +1,372 @@
+package proxypool
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gitee.com/baixudong/gospider/redis"
+	"gitee.com/baixudong/gospider/requests"
+	"gitee.com/baixudong/gospider/tools"
+)
+
+// 代理的状态
+type Proxy struct {
+	Proxy           string        //代理,例如：http://127.0.0.1:7890
+	Success         int64         //成功次数
+	Fail            int64         //失败次数
+	ConsecutiveFail int           //连续失败次数
+	Latency         time.Duration //平均延迟
+	CoolUntil       time.Time     //冷却结束时间
+	Evicted         bool          //是否被淘汰
+}
+
+// 成功率,没有请求时为0.5
+func (obj Proxy) SuccessRate() float64 {
+	return float64(obj.Success+1) / float64(obj.Success+obj.Fail+2)
+}
+
+// 分数,成功率越高,延迟越低,分数越高
+func (obj Proxy) Score() float64 {
+	return obj.SuccessRate() / (1 + obj.Latency.Seconds())
+}
+func (obj Proxy) available(now time.Time) bool {
+	return !obj.Evicted && !now.Before(obj.CoolUntil)
+}
+
+type ClientOption struct {
+	Proxys      []string                                    //静态代理列表,例如：http://127.0.0.1:7890,没有协议时使用Scheme
+	Redis       *redis.Client                               //从redis 中获取代理,格式同redis.Proxy
+	RedisKey    string                                      //redis 中代理的key
+	Refresh     func(ctx context.Context) ([]string, error) //刷新代理的回调
+	RefreshTime time.Duration                               //刷新间隔,default:1min
+	Scheme      string                                      //代理没有协议时使用的协议,default:http
+
+	BanStatus      []int         //被封禁的状态码,default:403,407,429
+	MaxFails       int           //连续失败次数,超过后冷却,default:3
+	CoolDown       time.Duration //冷却时间,default:5min
+	MinSamples     int64         //请求次数超过这个值后才会淘汰,default:20
+	MinSuccessRate float64       //成功率低于这个值时淘汰,default:0.2
+	DisEvict       bool          //关闭淘汰
+
+	StickyHost bool                                            //同一个host 使用同一个代理
+	StickyKey  func(ctx context.Context, href *url.URL) string //返回会话的key,相同的key 使用同一个代理,优先级高于StickyHost
+	StickyTime time.Duration                                   //会话保持时间,0:不过期,空闲超过StickyIdle 时仍然删除
+	StickyIdle time.Duration                                   //会话空闲超过这个时间后删除,default:30min
+}
+
+type sticky struct {
+	proxy    string
+	expireAt time.Time
+	useAt    time.Time //最后使用的时间
+}
+
+func (obj sticky) expired(now time.Time, idle time.Duration) bool {
+	return (!obj.expireAt.IsZero() && !now.Before(obj.expireAt)) || now.Sub(obj.useAt) > idle
+}
+
+// 代理池,实现requests.ProxyPool
+type Client struct {
+	option ClientOption
+
+	lock        sync.Mutex
+	proxys      map[string]*Proxy
+	stickys     map[string]sticky
+	cleanAt     time.Time //下次清理过期会话的时间
+	refreshLock sync.Mutex
+	refreshAt   time.Time
+}
+
+func NewClient(preCtx context.Context, options ...ClientOption) (*Client, error) {
+	var option ClientOption
+	if len(options) > 0 {
+		option = options[0]
+	}
+	if preCtx == nil {
+		preCtx = context.TODO()
+	}
+	if option.Redis != nil && option.RedisKey == "" {
+		return nil, errors.New("redis key is empty")
+	}
+	if option.Proxys == nil && option.Redis == nil && option.Refresh == nil {
+		return nil, errors.New("没有代理来源")
+	}
+	if option.RefreshTime == 0 {
+		option.RefreshTime = time.Minute
+	}
+	if option.Scheme == "" {
+		option.Scheme = "http"
+	}
+	if option.BanStatus == nil {
+		option.BanStatus = []int{403, 407, 429}
+	}
+	if option.MaxFails == 0 {
+		option.MaxFails = 3
+	}
+	if option.CoolDown == 0 {
+		option.CoolDown = time.Minute * 5
+	}
+	if option.MinSamples == 0 {
+		option.MinSamples = 20
+	}
+	if option.MinSuccessRate == 0 {
+		option.MinSuccessRate = 0.2
+	}
+	if option.StickyIdle == 0 {
+		option.StickyIdle = time.Minute * 30
+	}
+	client := &Client{
+		option:  option,
+		proxys:  map[string]*Proxy{},
+		stickys: map[string]sticky{},
+	}
+	return client, client.refresh(preCtx)
+}
+
+// 规范代理格式,补全协议和端口
+func (obj *Client) normalize(proxy string) (string, error) {
+	proxy = strings.TrimSpace(proxy)
+	if !strings.Contains(proxy, "://") {
+		proxy = obj.option.Scheme + "://" + proxy
+	}
+	proxyUrl, err := url.Parse(proxy)
+	if err != nil {
+		return "", err
+	}
+	if proxyUrl.Hostname() == "" {
+		return "", errors.New("代理格式错误: " + proxy)
+	}
+	if proxyUrl.Port() == "" {
+		switch proxyUrl.Scheme {
+		case "https", "h2":
+			proxyUrl.Host = net.JoinHostPort(proxyUrl.Hostname(), "443")
+		case "socks5", "socks5h", "socks4", "socks4a":
+			proxyUrl.Host = net.JoinHostPort(proxyUrl.Hostname(), "1080")
+		default:
+			proxyUrl.Host = net.JoinHostPort(proxyUrl.Hostname(), "80")
+		}
+	}
+	return proxyUrl.String(), nil
+}
+
+// 从各个来源刷新代理,已有代理的状态会保留,被淘汰的代理重新统计
+func (obj *Client) refresh(ctx context.Context) error {
+	obj.refreshLock.Lock()
+	defer obj.refreshLock.Unlock()
+	if !obj.refreshAt.IsZero() && time.Since(obj.refreshAt) < obj.option.RefreshTime {
+		return nil
+	}
+	proxys := append([]string{}, obj.option.Proxys...)
+	if obj.option.Redis != nil {
+		redisProxys, err := obj.option.Redis.GetProxys(obj.option.RedisKey)
+		if err != nil {
+			return tools.WrapError(err, "redis 获取代理错误")
+		}
+		proxys = append(proxys, redisProxys...)
+	}
+	if obj.option.Refresh != nil {
+		refreshProxys, err := obj.option.Refresh(ctx)
+		if err != nil {
+			return tools.WrapError(err, "刷新代理错误")
+		}
+		proxys = append(proxys, refreshProxys...)
+	}
+	obj.refreshAt = time.Now()
+	results := map[string]*Proxy{}
+	for _, proxy := range proxys {
+		key, err := obj.normalize(proxy)
+		if err != nil {
+			return err
+		}
+		results[key] = &Proxy{Proxy: key}
+	}
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	for key := range results {
+		if proxy, ok := obj.proxys[key]; ok && !proxy.Evicted { //被淘汰的代理重新出现时,重新统计
+			results[key] = proxy
+		}
+	}
+	obj.proxys = results
+	return nil
+}
+
+// 返回所有代理的状态
+func (obj *Client) Proxys() []Proxy {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	results := make([]Proxy, 0, len(obj.proxys))
+	for _, proxy := range obj.proxys {
+		results = append(results, *proxy)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Score() > results[j].Score()
+	})
+	return results
+}
+
+// 返回会话保持的代理,key:会话的key,val:代理
+func (obj *Client) Stickys() map[string]string {
+	now := time.Now()
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	obj.cleanStickys(now)
+	results := make(map[string]string, len(obj.stickys))
+	for key, val := range obj.stickys {
+		results[key] = val.proxy
+	}
+	return results
+}
+
+// 删除过期和空闲的会话,每个StickyIdle 最多清理一次
+func (obj *Client) cleanStickys(now time.Time) {
+	if now.Before(obj.cleanAt) {
+		return
+	}
+	obj.cleanAt = now.Add(obj.option.StickyIdle)
+	for key, val := range obj.stickys {
+		if val.expired(now, obj.option.StickyIdle) {
+			delete(obj.stickys, key)
+		}
+	}
+}
+
+// 根据分数随机选择一个代理,优先选择没有连续失败的代理
+func (obj *Client) choose(now time.Time) *Proxy {
+	var candidates []*Proxy
+	var failCandidates []*Proxy
+	for _, proxy := range obj.proxys {
+		if !proxy.available(now) {
+			continue
+		}
+		if proxy.ConsecutiveFail > 0 {
+			failCandidates = append(failCandidates, proxy)
+		} else {
+			candidates = append(candidates, proxy)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = failCandidates
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	var total float64
+	for _, proxy := range candidates {
+		total += proxy.Score()
+	}
+	val := rand.Float64() * total
+	for _, proxy := range candidates {
+		if val -= proxy.Score(); val <= 0 {
+			return proxy
+		}
+	}
+	return candidates[len(candidates)-1]
+}
+func (obj *Client) stickyKey(ctx context.Context, href *url.URL) string {
+	if obj.option.StickyKey != nil {
+		return obj.option.StickyKey(ctx, href)
+	}
+	if obj.option.StickyHost && href != nil {
+		return href.Host
+	}
+	return ""
+}
+
+// 新建连接时获取代理,实现requests.ProxyPool
+func (obj *Client) GetProxy(ctx context.Context, href *url.URL) (string, error) {
+	if obj.option.Redis != nil || obj.option.Refresh != nil {
+		if err := obj.refresh(ctx); err != nil {
+			return "", err
+		}
+	}
+	key := obj.stickyKey(ctx, href)
+	now := time.Now()
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	obj.cleanStickys(now)
+	if key != "" {
+		if val, ok := obj.stickys[key]; ok && !val.expired(now, obj.option.StickyIdle) {
+			if proxy, ok := obj.proxys[val.proxy]; ok && proxy.available(now) && proxy.ConsecutiveFail == 0 {
+				val.useAt = now
+				obj.stickys[key] = val
+				return proxy.Proxy, nil
+			}
+		}
+	}
+	proxy := obj.choose(now)
+	if proxy == nil {
+		return "", errors.New("没有可用的代理")
+	}
+	if key != "" {
+		val := sticky{proxy: proxy.Proxy, useAt: now}
+		if obj.option.StickyTime > 0 {
+			val.expireAt = now.Add(obj.option.StickyTime)
+		}
+		obj.stickys[key] = val
+	}
+	return proxy.Proxy, nil
+}
+
+// 上报请求结果,返回true 时更换代理,实现requests.ProxyPool
+func (obj *Client) Report(ctx context.Context, result requests.ProxyResult) bool {
+	if result.Proxy == nil {
+		return false
+	}
+	errClass := requests.GetErrClass(result.Err)
+	if errClass == requests.ErrClassCancel {
+		return false
+	}
+	key, err := obj.normalize(result.Proxy.String())
+	if err != nil {
+		return false
+	}
+	now := time.Now()
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	proxy, ok := obj.proxys[key]
+	if !ok {
+		return false
+	}
+	var ban bool
+	for _, status := range obj.option.BanStatus {
+		if status == result.StatusCode {
+			ban = true
+			break
+		}
+	}
+	if errClass == requests.ErrClassConnect { //连接被重置
+		ban = true
+	}
+	if result.Err == nil && !ban { //成功
+		proxy.Success++
+		proxy.ConsecutiveFail = 0
+		if proxy.Latency == 0 {
+			proxy.Latency = result.Latency
+		} else {
+			proxy.Latency = (proxy.Latency*4 + result.Latency) / 5
+		}
+		return false
+	}
+	proxy.Fail++
+	proxy.ConsecutiveFail++
+	if ban || proxy.ConsecutiveFail >= obj.option.MaxFails {
+		proxy.CoolUntil = now.Add(obj.option.CoolDown)
+		proxy.ConsecutiveFail = 0
+	}
+	if !obj.option.DisEvict && proxy.Success+proxy.Fail >= obj.option.MinSamples && proxy.SuccessRate() < obj.option.MinSuccessRate {
+		proxy.Evicted = true
+	}
+	for stickyKey, val := range obj.stickys {
+		if val.proxy == key {
+			delete(obj.stickys, stickyKey)
+		}
+	}
+	return true
+}
@@ -0,0 +1,538 @@
+package requests
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type CacheMode int
+
+const (
+	CacheDefault CacheMode = 0 //遵循RFC 9111,根据Cache-Control,Expires 判断是否过期,过期后使用ETag,Last-Modified 验证
+	CacheForce   CacheMode = 1 //强制缓存,有缓存直接返回,不判断是否过期,没有缓存时请求并保存,用于离线开发
+)
+
+// 磁盘缓存,key 为method+url+Vary 中的请求头
+type Cache struct {
+	dir  string
+	mode CacheMode
+	lock sync.Mutex
+}
+
+// 创建磁盘缓存,dir 为缓存的目录
+func NewCache(dir string, modes ...CacheMode) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	cache := &Cache{dir: dir}
+	if len(modes) > 0 {
+		cache.mode = modes[0]
+	}
+	return cache, nil
+}
+
+// 清空缓存
+func (obj *Cache) Clear() error {
+	entries, err := os.ReadDir(obj.dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasPrefix(entry.Name(), "cache_") {
+			if err = os.Remove(filepath.Join(obj.dir, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type cacheEntry struct {
+	Method       string      `json:"method"`
+	Url          string      `json:"url"`
+	Proto        string      `json:"proto"`
+	Status       string      `json:"status"`
+	StatusCode   int         `json:"statusCode"`
+	Header       http.Header `json:"header"`
+	RequestTime  time.Time   `json:"requestTime"`
+	ResponseTime time.Time   `json:"responseTime"`
+}
+
+// 可以被缓存的状态码,RFC 9110 15.1
+var cacheableStatus = []int{200, 203, 204, 300, 301, 308, 404, 405, 410, 414, 501}
+
+func parseCacheControl(header http.Header) map[string]string {
+	results := map[string]string{}
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			key, val, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if key = strings.ToLower(strings.TrimSpace(key)); key != "" {
+				results[key] = strings.Trim(strings.TrimSpace(val), `"`)
+			}
+		}
+	}
+	return results
+}
+func parseCacheSeconds(cc map[string]string, key string) (time.Duration, bool) {
+	val, ok := cc[key]
+	if !ok {
+		return 0, false
+	}
+	seconds, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || seconds < 0 {
+		return 0, true
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+func parseHttpDate(header http.Header, key string) (time.Time, bool) {
+	val := header.Get(key)
+	if val == "" {
+		return time.Time{}, false
+	}
+	t, err := http.ParseTime(val)
+	return t, err == nil
+}
+
+func (obj *Cache) primaryKey(req *http.Request) string {
+	sum := sha256.Sum256([]byte(req.Method + " " + req.URL.String()))
+	return "cache_" + hex.EncodeToString(sum[:])
+}
+func (obj *Cache) entryKey(primaryKey string, varyNames []string, header http.Header) string {
+	hash := sha256.New()
+	hash.Write([]byte(primaryKey))
+	for _, name := range varyNames {
+		hash.Write([]byte("\n" + name + ":" + strings.Join(header.Values(name), ",")))
+	}
+	return "cache_" + hex.EncodeToString(hash.Sum(nil))
+}
+func (obj *Cache) path(key string, ext string) string {
+	return filepath.Join(obj.dir, key+ext)
+}
+
+// 读取Vary 中的请求头名称
+func (obj *Cache) loadVary(primaryKey string) ([]string, error) {
+	data, err := os.ReadFile(obj.path(primaryKey, ".vary"))
+	if err != nil {
+		return nil, err
+	}
+	var varyNames []string
+	return varyNames, json.Unmarshal(data, &varyNames)
+}
+func (obj *Cache) load(req *http.Request) (string, *cacheEntry, error) {
+	primaryKey := obj.primaryKey(req)
+	varyNames, err := obj.loadVary(primaryKey)
+	if err != nil {
+		return "", nil, err
+	}
+	key := obj.entryKey(primaryKey, varyNames, req.Header)
+	data, err := os.ReadFile(obj.path(key, ".json"))
+	if err != nil {
+		return "", nil, err
+	}
+	var entry cacheEntry
+	if err = json.Unmarshal(data, &entry); err != nil {
+		return "", nil, err
+	}
+	return key, &entry, nil
+}
+
+// 读取url 所有缓存的key,Vary 不同时有多个
+func (obj *Cache) loadKeys(primaryKey string) []string {
+	data, err := os.ReadFile(obj.path(primaryKey, ".keys"))
+	if err != nil {
+		return nil
+	}
+	var keys []string
+	json.Unmarshal(data, &keys)
+	return keys
+}
+
+// 记录url 缓存的key,删除缓存时使用
+func (obj *Cache) addKey(primaryKey string, key string) error {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	keys := obj.loadKeys(primaryKey)
+	if slices.Contains(keys, key) {
+		return nil
+	}
+	data, err := json.Marshal(append(keys, key))
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(obj.path(primaryKey, ".keys"), data)
+}
+
+// 删除url 的缓存
+func (obj *Cache) invalidate(req *http.Request) {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		primaryKey := obj.primaryKey(&http.Request{Method: method, URL: req.URL})
+		for _, key := range obj.loadKeys(primaryKey) {
+			os.Remove(obj.path(key, ".json"))
+			os.Remove(obj.path(key, ".body"))
+		}
+		os.Remove(obj.path(primaryKey, ".keys"))
+		os.Remove(obj.path(primaryKey, ".vary"))
+	}
+}
+func writeFileAtomic(filePath string, data []byte) error {
+	tempPath := filePath + ".tmp" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, filePath)
+}
+func (obj *Cache) saveEntry(key string, entry *cacheEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(obj.path(key, ".json"), data)
+}
+
+// 响应的新鲜度,RFC 9111 4.2.1
+func (obj *cacheEntry) freshnessLifetime() time.Duration {
+	cc := parseCacheControl(obj.Header)
+	if maxAge, ok := parseCacheSeconds(cc, "max-age"); ok {
+		return maxAge
+	}
+	date, ok := parseHttpDate(obj.Header, "Date")
+	if !ok {
+		date = obj.ResponseTime
+	}
+	if obj.Header.Get("Expires") != "" {
+		expires, ok := parseHttpDate(obj.Header, "Expires")
+		if !ok {
+			return 0
+		}
+		return expires.Sub(date)
+	}
+	if lastModified, ok := parseHttpDate(obj.Header, "Last-Modified"); ok && slices.Contains(cacheableStatus, obj.StatusCode) {
+		return date.Sub(lastModified) / 10 //启发式新鲜度,RFC 9111 4.2.2
+	}
+	return 0
+}
+
+// 响应的年龄,RFC 9111 4.2.3
+func (obj *cacheEntry) currentAge() time.Duration {
+	var ageValue time.Duration
+	if age, err := strconv.ParseInt(obj.Header.Get("Age"), 10, 64); err == nil && age > 0 {
+		ageValue = time.Duration(age) * time.Second
+	}
+	var apparentAge time.Duration
+	if date, ok := parseHttpDate(obj.Header, "Date"); ok {
+		apparentAge = max(0, obj.ResponseTime.Sub(date))
+	}
+	correctedAgeValue := ageValue + obj.ResponseTime.Sub(obj.RequestTime)
+	return max(apparentAge, correctedAgeValue) + time.Since(obj.ResponseTime)
+}
+
+// 根据请求的Cache-Control 判断缓存是否可以直接使用
+func (obj *cacheEntry) isFresh(req *http.Request) bool {
+	resCc := parseCacheControl(obj.Header)
+	if _, ok := resCc["no-cache"]; ok {
+		return false
+	}
+	reqCc := parseCacheControl(req.Header)
+	if _, ok := reqCc["no-cache"]; ok {
+		return false
+	}
+	if req.Header.Get("Pragma") == "no-cache" && req.Header.Get("Cache-Control") == "" {
+		return false
+	}
+	lifetime := obj.freshnessLifetime()
+	age := obj.currentAge()
+	if maxAge, ok := parseCacheSeconds(reqCc, "max-age"); ok {
+		lifetime = min(lifetime, maxAge)
+	}
+	if minFresh, ok := parseCacheSeconds(reqCc, "min-fresh"); ok {
+		age += minFresh
+	}
+	if age < lifetime {
+		return true
+	}
+	_, mustRevalidate := resCc["must-revalidate"]
+	if maxStale, ok := reqCc["max-stale"]; ok && !mustRevalidate {
+		if maxStale == "" {
+			return true
+		}
+		if staleTime, ok := parseCacheSeconds(reqCc, "max-stale"); ok {
+			return age < lifetime+staleTime
+		}
+	}
+	return false
+}
+
+// 从缓存中构造response
+func (obj *Cache) response(req *http.Request, key string, entry *cacheEntry) (*http.Response, error) {
+	body, err := os.Open(obj.path(key, ".body"))
+	if err != nil {
+		return nil, err
+	}
+	stat, err := body.Stat()
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	header := entry.Header.Clone()
+	header.Set("Age", strconv.FormatInt(int64(entry.currentAge()/time.Second), 10))
+	protoMajor, protoMinor, ok := http.ParseHTTPVersion(entry.Proto)
+	if !ok {
+		protoMajor, protoMinor = 1, 1
+	}
+	return &http.Response{
+		Status:        entry.Status,
+		StatusCode:    entry.StatusCode,
+		Proto:         entry.Proto,
+		ProtoMajor:    protoMajor,
+		ProtoMinor:    protoMinor,
+		Header:        header,
+		Body:          body,
+		ContentLength: stat.Size(),
+		Request:       req,
+	}, nil
+}
+
+// 判断响应是否可以保存,RFC 9111 3
+func (obj *Cache) storable(req *http.Request, resp *http.Response) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	if resp.StatusCode == http.StatusPartialContent || resp.StatusCode < 200 {
+		return false
+	}
+	for _, name := range resp.Header.Values("Vary") {
+		if strings.TrimSpace(name) == "*" {
+			return false
+		}
+	}
+	if obj.mode == CacheForce { //强制缓存只保存可以缓存的状态码,不保存5xx 等错误
+		return slices.Contains(cacheableStatus, resp.StatusCode)
+	}
+	if _, ok := parseCacheControl(req.Header)["no-store"]; ok {
+		return false
+	}
+	resCc := parseCacheControl(resp.Header)
+	if _, ok := resCc["no-store"]; ok {
+		return false
+	}
+	_, isPublic := resCc["public"]
+	if req.Header.Get("Authorization") != "" {
+		_, mustRevalidate := resCc["must-revalidate"]
+		if _, ok := resCc["s-maxage"]; !ok && !isPublic && !mustRevalidate {
+			return false
+		}
+	}
+	if _, ok := resCc["max-age"]; ok {
+		return true
+	}
+	if _, ok := resCc["no-cache"]; ok {
+		return true
+	}
+	if resp.Header.Get("Expires") != "" || isPublic {
+		return true
+	}
+	return slices.Contains(cacheableStatus, resp.StatusCode) && (resp.Header.Get("Last-Modified") != "" || resp.Header.Get("ETag") != "")
+}
+
+// 保存响应,读取body 时写入缓存文件,读取完成后生效
+func (obj *Cache) store(req *http.Request, resp *http.Response, requestTime time.Time) {
+	primaryKey := obj.primaryKey(req)
+	varyNames := []string{}
+	for _, value := range resp.Header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = http.CanonicalHeaderKey(strings.TrimSpace(name)); name != "" && !slices.Contains(varyNames, name) {
+				varyNames = append(varyNames, name)
+			}
+		}
+	}
+	slices.Sort(varyNames)
+	key := obj.entryKey(primaryKey, varyNames, req.Header)
+	entry := &cacheEntry{
+		Method:       req.Method,
+		Url:          req.URL.String(),
+		Proto:        resp.Proto,
+		Status:       resp.Status,
+		StatusCode:   resp.StatusCode,
+		Header:       resp.Header.Clone(),
+		RequestTime:  requestTime,
+		ResponseTime: time.Now(),
+	}
+	bodyFile, err := os.CreateTemp(obj.dir, "cache_body_*.tmp")
+	if err != nil {
+		return
+	}
+	resp.Body = &cacheBody{
+		body:     resp.Body,
+		file:     bodyFile,
+		writer:   bufio.NewWriter(bodyFile),
+		cache:    obj,
+		key:      key,
+		entry:    entry,
+		vary:     varyNames,
+		primary:  primaryKey,
+		complete: req.Method == http.MethodHead,
+	}
+}
+
+type cacheBody struct {
+	body     io.ReadCloser
+	file     *os.File
+	writer   *bufio.Writer
+	cache    *Cache
+	key      string
+	primary  string
+	vary     []string
+	entry    *cacheEntry
+	complete bool
+	err      error
+}
+
+func (obj *cacheBody) Read(p []byte) (n int, err error) {
+	n, err = obj.body.Read(p)
+	if n > 0 && obj.err == nil {
+		_, obj.err = obj.writer.Write(p[:n])
+	}
+	if err == io.EOF {
+		obj.complete = true
+	}
+	return
+}
+func (obj *cacheBody) Close() error {
+	err := obj.body.Close()
+	tempPath := obj.file.Name()
+	if obj.err == nil {
+		obj.err = obj.writer.Flush()
+	}
+	obj.file.Close()
+	if !obj.complete || obj.err != nil { //没有读取完整的body 不保存
+		os.Remove(tempPath)
+		return err
+	}
+	if os.Rename(tempPath, obj.cache.path(obj.key, ".body")) != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	if obj.cache.addKey(obj.primary, obj.key) != nil {
+		os.Remove(obj.cache.path(obj.key, ".body"))
+		return err
+	}
+	if obj.cache.saveEntry(obj.key, obj.entry) != nil {
+		return err
+	}
+	if varyData, varyErr := json.Marshal(obj.vary); varyErr == nil {
+		writeFileAtomic(obj.cache.path(obj.primary, ".vary"), varyData)
+	}
+	return err
+}
+
+// 304 时使用新的响应头更新缓存,RFC 9111 4.3.4
+func (obj *Cache) freshen(key string, entry *cacheEntry, resp *http.Response, requestTime time.Time) {
+	for name, values := range resp.Header {
+		switch name {
+		case "Content-Length", "Content-Encoding", "Transfer-Encoding":
+		default:
+			entry.Header[name] = values
+		}
+	}
+	entry.RequestTime = requestTime
+	entry.ResponseTime = time.Now()
+	obj.saveEntry(key, entry)
+}
+
+type cacheTransport struct {
+	cache     *Cache
+	transport http.RoundTripper
+}
+
+func (obj *Cache) roundTripper(transport http.RoundTripper) http.RoundTripper {
+	return &cacheTransport{cache: obj, transport: transport}
+}
+func (obj *cacheTransport) CloseIdleConnections() {
+	if transport, ok := obj.transport.(interface{ CloseIdleConnections() }); ok {
+		transport.CloseIdleConnections()
+	}
+}
+
+// 用户自己设置了条件请求头时,不使用缓存
+func isConditionalRequest(req *http.Request) bool {
+	return req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != "" || req.Header.Get("Range") != ""
+}
+func (obj *cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctxData, _ := req.Context().Value(keyPrincipalID).(*reqCtxData)
+	if ctxData != nil {
+		ctxData.fromCache = false
+		if ctxData.disCache || ctxData.ws {
+			return obj.transport.RoundTrip(req)
+		}
+	}
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		resp, err := obj.transport.RoundTrip(req)
+		if err == nil && req.Method != http.MethodOptions && req.Method != http.MethodTrace && resp.StatusCode < 400 { //不安全的方法,删除缓存,RFC 9111 4.4
+			obj.cache.invalidate(req)
+		}
+		return resp, err
+	}
+	if isConditionalRequest(req) {
+		return obj.transport.RoundTrip(req)
+	}
+	key, entry, loadErr := obj.cache.load(req)
+	if loadErr == nil && (obj.cache.mode == CacheForce || entry.isFresh(req)) {
+		if resp, err := obj.cache.response(req, key, entry); err == nil {
+			if ctxData != nil {
+				ctxData.fromCache = true
+			}
+			return resp, nil
+		}
+	}
+	if _, ok := parseCacheControl(req.Header)["only-if-cached"]; ok {
+		return nil, errors.New("cache 中没有找到: " + req.URL.String())
+	}
+	revalidate := loadErr == nil && (entry.Header.Get("ETag") != "" || entry.Header.Get("Last-Modified") != "")
+	if revalidate { //条件请求,验证缓存
+		req = req.Clone(req.Context())
+		if etag := entry.Header.Get("ETag"); etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if lastModified := entry.Header.Get("Last-Modified"); lastModified != "" {
+			req.Header.Set("If-Modified-Since", lastModified)
+		}
+	}
+	requestTime := time.Now()
+	resp, err := obj.transport.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	if revalidate {
+		req.Header.Del("If-None-Match")
+		req.Header.Del("If-Modified-Since")
+		if resp.StatusCode == http.StatusNotModified {
+			obj.cache.freshen(key, entry, resp, requestTime)
+			if cacheResp, err := obj.cache.response(req, key, entry); err == nil {
+				resp.Body.Close()
+				if ctxData != nil {
+					ctxData.fromCache = true
+				}
+				return cacheResp, nil
+			}
+			return resp, nil
+		}
+	}
+	if obj.cache.storable(req, resp) {
+		obj.cache.store(req, resp, requestTime)
+	}
+	return resp, nil
+}
@@ -3,8 +3,10 @@ package requests
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
 	"net/http"
-	"net/http/cookiejar"
 	"net/url"
 	"time"
 
@@ -14,28 +16,51 @@ import (
 
 type ClientOption struct {
 	GetProxy              func(ctx context.Context, url *url.URL) (string, error) //根据url 返回代理，支持https,http,socks5 代理协议
-	Proxy                 string                                                  //设置代理,支持https,http,socks5 代理协议
+	Proxy                 string                                                  //设置代理,支持https,http,socks5,socks5h,socks4,socks4a,h2(http2 CONNECT) 代理协议,socks5 和socks5h 的tcp 连接都由代理解析域名,udp 时socks5 在本地解析,本地的dns 查询需要DnsProxy 才经过代理
+	ProxyChain            []string                                                //代理链,依次连接后再连接代理,例如：[]string{"socks5://127.0.0.1:1080"},用于固定的入口代理
 	TLSHandshakeTimeout   time.Duration                                           //tls 超时时间,default:15
 	ResponseHeaderTimeout time.Duration                                           //第一个response headers 接收超时时间,default:30
 	DisCookie             bool                                                    //关闭cookies管理
+	Jar                   *Jar                                                    //自定义cookies 管理,可以保存到文件,使用NewJar 创建
 	DisCompression        bool                                                    //关闭请求头中的压缩功能
 	LocalAddr             string                                                  //本地网卡出口ip
+	LocalAddrs            []string                                                //出口ip 池,支持ip 和网段,例如：[]string{"192.168.1.2","2001:db8::/64"}
+	LocalAddrMode         LocalAddrMode                                           //出口ip 的选择方式,随机,轮换,同一个host 固定
 	IdleConnTimeout       time.Duration                                           //空闲连接在连接池中的超时时间,default:90
 	KeepAlive             time.Duration                                           //keepalive保活检测定时,default:30
 	DnsCacheTime          time.Duration                                           //dns解析缓存时间60*30
+	DnsRootCAs            *x509.CertPool                                          //DoH,DoT 校验证书使用的根证书,默认使用系统根证书
 	AddrType              AddrType                                                //优先使用的addr 类型
 	GetAddrType           func(string) AddrType
 	Dns                   string        //dns
+	DnsServers            []string      //DoH,DoT 服务,按顺序失败切换,结果使用DnsCacheTime 缓存,例如：https://dns.alidns.com/dns-query,tls://223.5.5.5:853
+	DnsPost               bool          //DoH 使用POST 请求,默认GET
+	DnsProxy              bool          //dns 请求使用客户端的代理,socks5 代理时udp 查询使用UDP ASSOCIATE 转发
 	Ja3                   bool          //开启ja3
 	Ja3Spec               ja3.Ja3Spec   //指定ja3Spec,使用ja3.CreateSpecWithStr 或者ja3.CreateSpecWithId 生成
 	H2Ja3                 bool          //开启h2指纹
 	H2Ja3Spec             ja3.H2Ja3Spec //h2指纹
+	H3                    bool          //开启http3,根据响应头Alt-Svc 发现http3 服务,http3 请求失败时回退到http1.1,http2,代理只支持一个socks5 代理
+	ForceH3               bool          //https 请求强制使用http3,不回退
+	H3Spec                ja3.H3Spec    //quic 传输参数和http3 SETTINGS,默认使用chrome 的值,quic 握手的ClientHello 使用标准库crypto/tls,Ja3,Ja3Spec 不生效
+	H2c                   bool          //http 请求使用h2c(http2 prior knowledge),使用h2指纹
+	H2cUpgrade            bool          //http 请求通过Upgrade: h2c 升级到http2,服务端不支持时使用http1.1
+	Profile               string        //浏览器指纹配置名称,例如：chrome_114,同时设置ja3,h2指纹和请求头,单独设置的字段优先
+	OrderHeaders          []string      //请求头顺序和大小写,http1.1 和http2 都生效,设置后会开启h2指纹
+	Cache                 *Cache        //磁盘缓存,使用NewCache 创建,缓存命中的请求不会记录到HarRecorder
+	HarRecorder           *HarRecorder  //记录所有请求到har,包含重定向,使用NewHarRecorder 创建
+	HarReplay             *HarReplay    //从har 中回放响应,不发送网络请求,使用NewHarReplay 创建
+	Limiter               *Limiter      //按照host 限速,使用NewLimiter 创建
+	Robots                RobotsChecker //robots.txt 检查,禁止访问的url 返回DisallowedError
+	ProxyPool             ProxyPool     //代理池,GetProxy 为空时使用代理池获取代理,根据请求结果更换代理
+	KeyLogWriter          io.Writer     //tls 密钥日志,NSS key log 格式,用于wireshark 解密,为空时使用环境变量SSLKEYLOGFILE
 
-	RedirectNum int   //重定向次数,小于0为禁用,0:不限制
-	DisDecode   bool  //关闭自动编码
-	DisRead     bool  //关闭默认读取请求体
-	DisUnZip    bool  //变比自动解压
-	TryNum      int64 //重试次数
+	RedirectNum int          //重定向次数,小于0为禁用,0:不限制
+	DisDecode   bool         //关闭自动编码
+	DisRead     bool         //关闭默认读取请求体
+	DisUnZip    bool         //变比自动解压
+	TryNum      int64        //重试次数
+	RetryPolicy *RetryPolicy //重试策略,指数退避,随机抖动,Retry-After
 
 	OptionCallBack func(context.Context, *RequestOption) error //请求参数回调,用于对请求参数进行修改。返回error,中断重试请求,返回nil继续
 	ResultCallBack func(context.Context, *Response) error      //结果回调,用于对结果进行校验。返回nil，直接返回,返回err的话，如果有errCallBack 走errCallBack，没有继续try
@@ -46,23 +71,28 @@ type ClientOption struct {
 	Bar     bool          //是否开启bar
 }
 type Client struct {
-	http2Upg    *http2.Upg
-	redirectNum int   //重定向次数
-	disDecode   bool  //关闭自动编码
-	disRead     bool  //关闭默认读取请求体
-	disUnZip    bool  //变比自动解压
-	tryNum      int64 //重试次数
+	http2Upg     *http2.Upg
+	h3Transport  *h3Transport
+	h2cTransport *h2cTransport
+	redirectNum  int   //重定向次数
+	disDecode    bool  //关闭自动编码
+	disRead      bool  //关闭默认读取请求体
+	disUnZip     bool  //变比自动解压
+	tryNum       int64 //重试次数
+	retryPolicy  *RetryPolicy
 
 	optionCallBack func(context.Context, *RequestOption) error //请求参数回调,用于对请求参数进行修改。返回error,中断重试请求,返回nil继续
 	resultCallBack func(context.Context, *Response) error      //结果回调,用于对结果进行校验。返回nil，直接返回,返回err的话，如果有errCallBack 走errCallBack，没有继续try
 	errCallBack    func(context.Context, error) error          //错误回调,返回error,中断重试请求,返回nil继续
 
-	timeout time.Duration //请求超时时间
-	headers any           //请求头
-	bar     bool          //是否开启bar
-	dialer  *DialClient   //dialer
+	timeout      time.Duration //请求超时时间
+	headers      any           //请求头
+	orderHeaders []string      //请求头顺序
+	bar          bool          //是否开启bar
+	dialer       *DialClient   //dialer
 
 	disCookie bool
+	jar       *Jar
 	client    *http.Client
 
 	ctx context.Context
@@ -95,6 +125,28 @@ func NewClient(preCtx context.Context, options ...ClientOption) (*Client, error)
 	if option.DnsCacheTime == 0 {
 		option.DnsCacheTime = time.Second * 60 * 30
 	}
+	if option.GetProxy == nil && option.ProxyPool != nil {
+		option.GetProxy = option.ProxyPool.GetProxy
+	}
+	if option.Profile != "" {
+		profile, ok := GetProfile(option.Profile)
+		if !ok {
+			cnl()
+			return nil, errors.New("not found profile: " + option.Profile)
+		}
+		if !option.Ja3Spec.IsSet() {
+			option.Ja3Spec = profile.GetJa3Spec()
+		}
+		if !option.H2Ja3Spec.IsSet() {
+			option.H2Ja3Spec = profile.GetH2Ja3Spec()
+		}
+		if option.Headers == nil {
+			option.Headers = profile.GetHeaders()
+		}
+		if option.OrderHeaders == nil {
+			option.OrderHeaders = profile.OrderHeaders
+		}
+	}
 	if option.Ja3Spec.IsSet() {
 		option.Ja3 = true
 	}
@@ -105,11 +157,19 @@ func NewClient(preCtx context.Context, options ...ClientOption) (*Client, error)
 		DnsCacheTime:        option.DnsCacheTime,
 		GetProxy:            option.GetProxy,
 		Proxy:               option.Proxy,
+		ProxyChain:          option.ProxyChain,
 		KeepAlive:           option.KeepAlive,
 		LocalAddr:           option.LocalAddr,
+		LocalAddrs:          option.LocalAddrs,
+		LocalAddrMode:       option.LocalAddrMode,
 		AddrType:            option.AddrType,
 		GetAddrType:         option.GetAddrType,
 		Dns:                 option.Dns,
+		DnsServers:          option.DnsServers,
+		DnsPost:             option.DnsPost,
+		DnsProxy:            option.DnsProxy,
+		DnsRootCAs:          option.DnsRootCAs,
+		KeyLogWriter:        option.KeyLogWriter,
 	})
 	if err != nil {
 		cnl()
@@ -117,9 +177,11 @@ func NewClient(preCtx context.Context, options ...ClientOption) (*Client, error)
 	}
 	var client http.Client
 	//创建cookiesjar
-	var jar *cookiejar.Jar
-	if !option.DisCookie {
-		jar = newJar()
+	var jar *Jar
+	if option.Jar != nil {
+		jar = option.Jar
+	} else if !option.DisCookie {
+		jar = NewJar()
 	}
 	transport := &http.Transport{
 		MaxIdleConns:        655350,
@@ -131,31 +193,17 @@ func NewClient(preCtx context.Context, options ...ClientOption) (*Client, error)
 		TLSHandshakeTimeout:   option.TLSHandshakeTimeout,
 		ResponseHeaderTimeout: option.ResponseHeaderTimeout,
 		DisableCompression:    option.DisCompression,
-		TLSClientConfig:       &tls.Config{InsecureSkipVerify: true},
+		TLSClientConfig:       &tls.Config{InsecureSkipVerify: true, KeyLogWriter: dialClient.keyLogWriter},
 		IdleConnTimeout:       option.IdleConnTimeout, //空闲连接在连接池中的超时时间
-		DialContext:           dialClient.requestHttpDialContext,
-		DialTLSContext:        dialClient.requestHttpDialTlsContext,
+		DialContext:           dialClient.requestHttp1DialContext,
+		DialTLSContext:        dialClient.requestHttp1DialTlsContext,
 		ForceAttemptHTTP2:     true,
 		Proxy: func(r *http.Request) (*url.URL, error) {
-			ctxData := r.Context().Value(keyPrincipalID).(*reqCtxData)
-			ctxData.url, ctxData.host = r.URL, r.Host
-			if ctxData.host == "" {
-				ctxData.host = ctxData.url.Host
-			}
-			if ctxData.requestCallBack != nil {
-				req, err := cloneRequest(r, ctxData.disBody)
-				if err != nil {
-					return nil, err
-				}
-				if err = ctxData.requestCallBack(r.Context(), req); err != nil {
-					return nil, err
-				}
-			}
-			return nil, nil
+			return nil, setRequestData(r)
 		},
 	}
 	var http2Upg *http2.Upg
-	if option.H2Ja3 || option.H2Ja3Spec.IsSet() {
+	if option.H2Ja3 || option.H2Ja3Spec.IsSet() || option.OrderHeaders != nil || option.ProxyPool != nil { //代理池需要标记http2 连接不再复用
 		http2Upg = http2.NewUpg(transport, http2.UpgOption{H2Ja3Spec: option.H2Ja3Spec, DialTLSContext: dialClient.requestHttp2DialTlsContext})
 		transport.TLSNextProto = map[string]func(authority string, c *tls.Conn) http.RoundTripper{
 			"h2": func(authority string, c *tls.Conn) http.RoundTripper {
@@ -163,8 +211,34 @@ func NewClient(preCtx context.Context, options ...ClientOption) (*Client, error)
 			},
 		}
 	}
-	client.Transport = transport
-	client.Jar = jar
+	h3Transport, err := newH3Transport(dialClient, transport, option)
+	if err != nil {
+		cnl()
+		return nil, err
+	}
+	h2cTransport := newH2cTransport(dialClient, h3Transport, option)
+	client.Transport = h2cTransport
+	if option.ProxyPool != nil {
+		client.Transport = newProxyPoolTransport(option.ProxyPool, dialClient, client.Transport, http2Upg, h2cTransport.upg)
+	}
+	if option.HarReplay != nil {
+		client.Transport = option.HarReplay
+	}
+	if option.HarRecorder != nil {
+		client.Transport = option.HarRecorder.roundTripper(client.Transport)
+	}
+	if option.Limiter != nil {
+		client.Transport = option.Limiter.roundTripper(client.Transport)
+	}
+	if option.Cache != nil {
+		client.Transport = option.Cache.roundTripper(client.Transport)
+	}
+	if option.Robots != nil {
+		client.Transport = newRobotsTransport(option.Robots, client.Transport)
+	}
+	if jar != nil {
+		client.Jar = jar.jar
+	}
 	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
 		ctxData := req.Context().Value(keyPrincipalID).(*reqCtxData)
 		if ctxData.responseCallBack != nil {
@@ -187,22 +261,47 @@ func NewClient(preCtx context.Context, options ...ClientOption) (*Client, error)
 		dialer:         dialClient,
 		client:         &client,
 		http2Upg:       http2Upg,
+		h3Transport:    h3Transport,
+		h2cTransport:   h2cTransport,
 		disCookie:      option.DisCookie,
+		jar:            jar,
 		redirectNum:    option.RedirectNum,
 		disDecode:      option.DisDecode,
 		disRead:        option.DisRead,
 		disUnZip:       option.DisUnZip,
 		tryNum:         option.TryNum,
+		retryPolicy:    option.RetryPolicy,
 		optionCallBack: option.OptionCallBack,
 		resultCallBack: option.ResultCallBack,
 		errCallBack:    option.ErrCallBack,
 		timeout:        option.Timeout,
 		headers:        option.Headers,
+		orderHeaders:   option.OrderHeaders,
 		bar:            option.Bar,
 	}
 	return result, nil
 }
 
+// 发送请求前设置请求的url,host,调用请求回调
+func setRequestData(r *http.Request) error {
+	ctxData := r.Context().Value(keyPrincipalID).(*reqCtxData)
+	ctxData.url, ctxData.host = r.URL, r.Host
+	if ctxData.host == "" {
+		ctxData.host = ctxData.url.Host
+	}
+	if ctxData.requestCallBack != nil {
+		req, err := cloneRequest(r, ctxData.disBody)
+		if err != nil {
+			return err
+		}
+		req.proxy = ctxData.proxy
+		if err = ctxData.requestCallBack(r.Context(), req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (obj *Client) SetProxy(proxy string) error {
 	return obj.dialer.SetProxy(proxy)
 }
@@ -213,6 +312,8 @@ func (obj *Client) SetGetProxy(getProxy func(ctx context.Context, url *url.URL)
 // 关闭客户端
 func (obj *Client) Close() {
 	obj.CloseIdleConnections()
+	obj.dialer.closeH2Proxys(true)
+	obj.h3Transport.Close()
 	obj.cnl()
 }
 
@@ -222,6 +323,8 @@ func (obj *Client) CloseIdleConnections() {
 	if obj.http2Upg != nil {
 		obj.http2Upg.CloseIdleConnections()
 	}
+	obj.h2cTransport.CloseIdleConnections()
+	obj.dialer.closeH2Proxys(false)
 }
 
 // 返回url 的cookies,也可以设置url 的cookies
@@ -229,27 +332,6 @@ func (obj *Client) Cookies(href string, cookies ...any) (Cookies, error) {
 	return cookie(obj.client.Jar, href, cookies...)
 }
 
-type Jar struct {
-	jar *cookiejar.Jar
-}
-
-func newJar() *cookiejar.Jar {
-	jar, _ := cookiejar.New(nil)
-	return jar
-}
-
-func NewJar() *Jar {
-	return &Jar{
-		jar: newJar(),
-	}
-}
-func (obj *Jar) Cookies(href string, cookies ...any) (Cookies, error) {
-	return cookie(obj.jar, href, cookies...)
-}
-func (obj *Jar) ClearCookies() {
-	*obj.jar = *newJar()
-}
-
 func cookie(jar http.CookieJar, href string, cookies ...any) (Cookies, error) {
 	if jar == nil {
 		return nil, nil
@@ -270,10 +352,15 @@ func cookie(jar http.CookieJar, href string, cookies ...any) (Cookies, error) {
 
 // 清除cookies
 func (obj *Client) ClearCookies() {
-	if obj.client.Jar != nil {
-		*obj.client.Jar.(*cookiejar.Jar) = *newJar()
+	if obj.jar != nil {
+		obj.jar.ClearCookies()
 	}
 }
+
+// 返回客户端的cookies 管理,关闭cookies 管理时为nil
+func (obj *Client) Jar() *Jar {
+	return obj.jar
+}
 func (obj *Client) getClient(option RequestOption) *http.Client {
 	if option.Jar != nil {
 		return &http.Client{
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"fmt"
 	"net"
+	"strings"
+	"sync"
 	"time"
 
 	"gitee.com/baixudong/gospider/tools"
@@ -46,3 +48,76 @@ func (obj *pwdConn) SetReadDeadline(t time.Time) error {
 func (obj *pwdConn) SetWriteDeadline(t time.Time) error {
 	return obj.rawConn.SetWriteDeadline(t)
 }
+
+// 按照指定顺序和大小写重写http1.1 请求头的连接
+type orderConn struct {
+	net.Conn
+	lock         sync.Mutex
+	orderHeaders []string
+	buf          []byte
+}
+
+func newOrderConn(conn net.Conn) net.Conn {
+	return &orderConn{Conn: conn}
+}
+
+// 设置下一个请求的请求头顺序,在httptrace 的GotConn 中调用
+func (obj *orderConn) setOrderHeaders(orderHeaders []string) {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	obj.orderHeaders = orderHeaders
+	obj.buf = nil
+}
+func (obj *orderConn) Write(b []byte) (n int, err error) {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	if len(obj.orderHeaders) == 0 { //请求头已经写完,请求体,websocket,connect 等直接透传
+		return obj.Conn.Write(b)
+	}
+	obj.buf = append(obj.buf, b...)
+	i := bytes.Index(obj.buf, []byte("\r\n\r\n"))
+	if i == -1 {
+		return len(b), nil
+	}
+	con := append(reorderHeaders(obj.buf[:i+2], obj.orderHeaders), obj.buf[i+2:]...)
+	obj.orderHeaders = nil
+	obj.buf = nil
+	if _, err = obj.Conn.Write(con); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// 重写请求头,指定的请求头按照顺序和大小写在前,其余的保持原来的顺序
+func reorderHeaders(head []byte, orderHeaders []string) []byte {
+	lines := bytes.Split(head[:len(head)-2], []byte("\r\n"))
+	if len(lines) < 2 {
+		return head
+	}
+	used := make([]bool, len(lines))
+	results := bytes.NewBuffer(nil)
+	results.Write(lines[0])
+	results.WriteString("\r\n")
+	for _, orderKey := range orderHeaders {
+		for i := 1; i < len(lines); i++ {
+			if used[i] {
+				continue
+			}
+			key, val, ok := bytes.Cut(lines[i], []byte(":"))
+			if ok && strings.EqualFold(tools.BytesToString(key), orderKey) {
+				used[i] = true
+				results.WriteString(orderKey)
+				results.WriteByte(':')
+				results.Write(val)
+				results.WriteString("\r\n")
+			}
+		}
+	}
+	for i := 1; i < len(lines); i++ {
+		if !used[i] {
+			results.Write(lines[i])
+			results.WriteString("\r\n")
+		}
+	}
+	return results.Bytes()
+}
@@ -0,0 +1,493 @@
+package requests
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitee.com/baixudong/gospider/tools"
+)
+
+// 把shell 命令拆分成参数,支持单引号,双引号,$'...' 和反斜杠换行
+func splitCurl(cmd string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	var inArg bool
+	for i := 0; i < len(cmd); i++ {
+		c := cmd[i]
+		switch {
+		case c == '\\' && i+1 < len(cmd):
+			i++
+			if cmd[i] == '\n' { //续行
+				continue
+			} else if cmd[i] == '\r' && i+1 < len(cmd) && cmd[i+1] == '\n' {
+				i++
+				continue
+			}
+			arg.WriteByte(cmd[i])
+			inArg = true
+		case c == '\'':
+			end := strings.IndexByte(cmd[i+1:], '\'')
+			if end == -1 {
+				return nil, errors.New("curl 命令中的单引号没有闭合")
+			}
+			arg.WriteString(cmd[i+1 : i+1+end])
+			i += end + 1
+			inArg = true
+		case c == '$' && i+1 < len(cmd) && cmd[i+1] == '\'':
+			val, n, err := unquoteAnsiC(cmd[i+2:])
+			if err != nil {
+				return nil, err
+			}
+			arg.WriteString(val)
+			i += n + 2
+			inArg = true
+		case c == '"':
+			i++
+			for ; i < len(cmd) && cmd[i] != '"'; i++ {
+				if cmd[i] == '\\' && i+1 < len(cmd) && strings.IndexByte("\"\\$`\n", cmd[i+1]) != -1 {
+					i++
+					if cmd[i] == '\n' {
+						continue
+					}
+				}
+				arg.WriteByte(cmd[i])
+			}
+			if i >= len(cmd) {
+				return nil, errors.New("curl 命令中的双引号没有闭合")
+			}
+			inArg = true
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteByte(c)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// 解析$'...' 中的内容,返回解析后的值和消耗的长度,包含结尾的单引号
+func unquoteAnsiC(val string) (string, int, error) {
+	var buf bytes.Buffer
+	for i := 0; i < len(val); i++ {
+		c := val[i]
+		if c == '\'' {
+			return buf.String(), i + 1, nil
+		}
+		if c != '\\' || i+1 >= len(val) {
+			buf.WriteByte(c)
+			continue
+		}
+		i++
+		switch val[i] {
+		case 'n':
+			buf.WriteByte('\n')
+		case 'r':
+			buf.WriteByte('\r')
+		case 't':
+			buf.WriteByte('\t')
+		case 'x':
+			end := i + 1
+			for end < len(val) && end < i+3 && strings.IndexByte("0123456789abcdefABCDEF", val[end]) != -1 {
+				end++
+			}
+			num, err := strconv.ParseUint(val[i+1:end], 16, 8)
+			if err != nil {
+				return "", 0, err
+			}
+			buf.WriteByte(byte(num))
+			i = end - 1
+		case 'u', 'U':
+			size := 4
+			if val[i] == 'U' {
+				size = 8
+			}
+			end := i + 1
+			for end < len(val) && end < i+1+size && strings.IndexByte("0123456789abcdefABCDEF", val[end]) != -1 {
+				end++
+			}
+			num, err := strconv.ParseUint(val[i+1:end], 16, 32)
+			if err != nil {
+				return "", 0, err
+			}
+			buf.WriteRune(rune(num))
+			i = end - 1
+		default:
+			buf.WriteByte(val[i])
+		}
+	}
+	return "", 0, errors.New("curl 命令中的$'' 没有闭合")
+}
+
+// 读取curl 参数中的值,@ 开头时读取文件
+func curlData(val string, raw bool) (string, error) {
+	if raw || !strings.HasPrefix(val, "@") {
+		return val, nil
+	}
+	con, err := os.ReadFile(val[1:])
+	if err != nil {
+		return "", err
+	}
+	return string(con), nil
+}
+
+// curl 中不需要处理,但是有参数的选项
+var curlIgnoreArgs = map[string]bool{
+	"-o": true, "--output": true, "-w": true, "--write-out": true, "-c": true, "--cookie-jar": true,
+	"-D": true, "--dump-header": true, "--connect-timeout": true, "--retry": true, "--cacert": true,
+	"-E": true, "--cert": true, "--key": true, "--resolve": true, "--limit-rate": true, "--interface": true,
+	"-r": true, "--range": true, "-z": true, "--time-cond": true, "--proxy-user": true, "-U": true,
+}
+
+// 解析curl 命令,返回method,url 和请求参数,支持浏览器开发者工具中复制的curl(bash) 命令
+func ParseCurl(cmd string) (method string, href string, option RequestOption, err error) {
+	args, err := splitCurl(strings.TrimSpace(cmd))
+	if err != nil {
+		return
+	}
+	if len(args) == 0 || args[0] != "curl" {
+		err = errors.New("不是curl 命令")
+		return
+	}
+	headers := http.Header{}
+	var orderHeaders []string
+	var datas []string
+	var forms url.Values
+	var get, head bool
+	var jsonData string
+	addHeader := func(val string) {
+		key, value, _ := strings.Cut(val, ":")
+		key = strings.TrimSpace(key)
+		if key == "" {
+			return
+		}
+		if strings.HasSuffix(key, ";") && strings.TrimSpace(value) == "" { //curl 中设置空值的写法
+			key = strings.TrimSuffix(key, ";")
+		}
+		headers.Add(key, strings.TrimSpace(value))
+		orderHeaders = append(orderHeaders, key)
+	}
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		name, val := arg, ""
+		var hasVal bool
+		if strings.HasPrefix(arg, "--") {
+			if key, value, ok := strings.Cut(arg, "="); ok {
+				name, val, hasVal = key, value, true
+			}
+		} else if strings.HasPrefix(arg, "-") && len(arg) > 2 {
+			name = arg[:2]
+			if strings.IndexByte("XHdbFAeuxmowcDErzU", arg[1]) != -1 {
+				val, hasVal = arg[2:], true
+			} else { //多个短选项写在一起,例如：-sSL
+				for _, flag := range arg[2:] {
+					switch flag {
+					case 'G':
+						get = true
+					case 'I':
+						head = true
+					}
+				}
+			}
+		}
+		nextVal := func() (string, error) {
+			if hasVal {
+				return val, nil
+			}
+			if i+1 >= len(args) {
+				return "", errors.New("curl 选项缺少参数: " + name)
+			}
+			i++
+			return args[i], nil
+		}
+		switch name {
+		case "-X", "--request":
+			if method, err = nextVal(); err != nil {
+				return
+			}
+		case "-H", "--header":
+			if val, err = nextVal(); err != nil {
+				return
+			}
+			addHeader(val)
+		case "-A", "--user-agent":
+			if val, err = nextVal(); err != nil {
+				return
+			}
+			addHeader("User-Agent: " + val)
+		case "-e", "--referer":
+			if val, err = nextVal(); err != nil {
+				return
+			}
+			addHeader("Referer: " + val)
+		case "-b", "--cookie":
+			if val, err = nextVal(); err != nil {
+				return
+			}
+			if strings.Contains(val, "=") {
+				addHeader("Cookie: " + val)
+			}
+		case "-u", "--user":
+			if val, err = nextVal(); err != nil {
+				return
+			}
+			addHeader("Authorization: Basic " + tools.Base64Encode(val))
+		case "-d", "--data", "--data-ascii", "--data-binary", "--data-raw":
+			if val, err = nextVal(); err != nil {
+				return
+			}
+			if val, err = curlData(val, name == "--data-raw"); err != nil {
+				return
+			}
+			if name != "--data-binary" && name != "--data-raw" {
+				val = strings.NewReplacer("\r", "", "\n", "").Replace(val)
+			}
+			datas = append(datas, val)
+		case "--data-urlencode":
+			if val, err = nextVal(); err != nil {
+				return
+			}
+			key, value, ok := strings.Cut(val, "=")
+			if !ok {
+				datas = append(datas, url.QueryEscape(val))
+			} else if key == "" {
+				datas = append(datas, url.QueryEscape(value))
+			} else {
+				datas = append(datas, key+"="+url.QueryEscape(value))
+			}
+		case "--json":
+			if val, err = nextVal(); err != nil {
+				return
+			}
+			if jsonData, err = curlData(val, false); err != nil {
+				return
+			}
+		case "-F", "--form", "--form-string":
+			if val, err = nextVal(); err != nil {
+				return
+			}
+			key, value, _ := strings.Cut(val, "=")
+			if name != "--form-string" && strings.HasPrefix(value, "@") {
+				var file File
+				if file, err = curlFile(key, value[1:]); err != nil {
+					return
+				}
+				option.Files = append(option.Files, file)
+			} else {
+				if forms == nil {
+					forms = url.Values{}
+				}
+				forms.Add(key, value)
+			}
+		case "-x", "--proxy":
+			if option.Proxy, err = nextVal(); err != nil {
+				return
+			}
+			if !strings.Contains(option.Proxy, "://") {
+				option.Proxy = "http://" + option.Proxy
+			}
+		case "--socks5":
+			if val, err = nextVal(); err != nil {
+				return
+			}
+			option.Proxy = "socks5://" + val
+		case "--socks5-hostname":
+			if val, err = nextVal(); err != nil {
+				return
+			}
+			option.Proxy = "socks5h://" + val
+		case "-m", "--max-time":
+			if val, err = nextVal(); err != nil {
+				return
+			}
+			var seconds float64
+			if seconds, err = strconv.ParseFloat(val, 64); err != nil {
+				return
+			}
+			option.Timeout = time.Duration(seconds * float64(time.Second))
+		case "--max-redirs":
+			if val, err = nextVal(); err != nil {
+				return
+			}
+			if option.RedirectNum, err = strconv.Atoi(val); err != nil {
+				return
+			}
+		case "-G", "--get":
+			get = true
+		case "-I", "--head":
+			head = true
+		case "--url":
+			if href, err = nextVal(); err != nil {
+				return
+			}
+		default:
+			if curlIgnoreArgs[name] {
+				if _, err = nextVal(); err != nil {
+					return
+				}
+			} else if !strings.HasPrefix(arg, "-") && href == "" {
+				href = arg
+			}
+		}
+	}
+	if href == "" {
+		err = errors.New("curl 命令中没有url")
+		return
+	}
+	if !strings.Contains(href, "://") {
+		href = "http://" + href
+	}
+	//cookies
+	if cookies := headers.Values("Cookie"); len(cookies) > 0 {
+		option.Cookies = strings.Join(cookies, "; ")
+		headers.Del("Cookie")
+	}
+	//body
+	contentType := headers.Get("Content-Type")
+	headers.Del("Content-Type")
+	data := strings.Join(datas, "&")
+	if get {
+		if data != "" {
+			if strings.Contains(href, "?") {
+				href += "&" + data
+			} else {
+				href += "?" + data
+			}
+		}
+	} else if jsonData != "" {
+		option.Json = jsonData
+		if contentType != "" {
+			option.ContentType = contentType
+		}
+	} else if option.Files != nil || forms != nil {
+		if forms != nil {
+			option.Form = map[string][]string(forms)
+		}
+	} else if len(datas) > 0 {
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+		switch {
+		case mediaType == "" || mediaType == "application/x-www-form-urlencoded":
+			option.Data = data
+		case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+			option.Json = data
+		default:
+			option.Raw = data
+		}
+		if contentType != "" {
+			option.ContentType = contentType
+		}
+	} else if contentType != "" {
+		headers.Set("Content-Type", contentType)
+	}
+	//method
+	if method == "" {
+		switch {
+		case head:
+			method = http.MethodHead
+		case get:
+			method = http.MethodGet
+		case len(datas) > 0 || jsonData != "" || option.Files != nil || forms != nil:
+			method = http.MethodPost
+		default:
+			method = http.MethodGet
+		}
+	}
+	method = strings.ToUpper(method)
+	if len(headers) > 0 {
+		option.Headers = headers
+		option.OrderHeaders = orderHeaders
+	}
+	return
+}
+
+// 读取-F 中的文件,支持;type= 和;filename=
+func curlFile(key string, val string) (File, error) {
+	params := strings.Split(val, ";")
+	file := File{Key: key, Name: filepath.Base(params[0])}
+	for _, param := range params[1:] {
+		pKey, pVal, _ := strings.Cut(param, "=")
+		switch strings.TrimSpace(pKey) {
+		case "type":
+			file.Type = strings.Trim(pVal, `"`)
+		case "filename":
+			file.Name = strings.Trim(pVal, `"`)
+		}
+	}
+	var err error
+	file.Content, err = os.ReadFile(params[0])
+	return file, err
+}
+
+// shell 单引号转义
+func curlQuote(val string) string {
+	return "'" + strings.ReplaceAll(val, "'", `'\''`) + "'"
+}
+
+// 转换成可以运行的curl 命令,body 为流时不包含body
+func (obj *RequestDebug) Curl() string {
+	var builder strings.Builder
+	builder.WriteString("curl")
+	if obj.Method != http.MethodGet {
+		builder.WriteString(" -X " + curlQuote(obj.Method))
+	}
+	builder.WriteString(" " + curlQuote(obj.Url.String()))
+	var host string
+	var body []byte
+	if req, err := obj.request(); err == nil {
+		host = req.Host
+		if req.Body != nil {
+			body, _ = io.ReadAll(req.Body)
+			req.Body.Close()
+		}
+	}
+	if host != "" && host != obj.Url.Host {
+		builder.WriteString(" -H " + curlQuote("Host: "+host))
+	}
+	keys := make([]string, 0, len(obj.Header))
+	for key := range obj.Header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var compressed bool
+	for _, key := range keys {
+		switch http.CanonicalHeaderKey(key) {
+		case "Content-Length", "Transfer-Encoding", "Connection", "Host":
+			continue
+		case "Accept-Encoding":
+			compressed = true
+		}
+		for _, val := range obj.Header[key] {
+			builder.WriteString(" -H " + curlQuote(key+": "+val))
+		}
+	}
+	if obj.proxy != nil {
+		builder.WriteString(" -x " + curlQuote(obj.proxy.String()))
+	}
+	if len(body) > 0 {
+		builder.WriteString(" --data-raw " + curlQuote(string(body)))
+	}
+	if compressed {
+		builder.WriteString(" --compressed")
+	}
+	if obj.Proto == "HTTP/2.0" {
+		builder.WriteString(" --http2")
+	}
+	return builder.String()
+}
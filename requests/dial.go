@@ -4,15 +4,18 @@ import (
 	"bufio"
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"io"
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"gitee.com/baixudong/gospider/http2"
 	"gitee.com/baixudong/gospider/ja3"
 	"gitee.com/baixudong/gospider/tools"
 	utls "github.com/refraction-networking/utls"
@@ -32,10 +35,21 @@ type DialClient struct {
 	ja3          bool //是否启用ja3
 	ja3Spec      ja3.Ja3Spec
 	dns          string //dns
+	dnsServers   []*url.URL
+	dnsPost      bool
+	dnsProxy     bool
+	dnsRootCAs   *x509.CertPool
+	dohClient    *http.Client
 	resolver     *net.Resolver
 	ctx          context.Context
 	utlsConfig   *utls.Config
 	tlsConfig    *tls.Config
+	proxyConns   sync.Map //连接使用的代理,key:net.Conn,val:*proxyConn
+	proxyChain   []*url.URL
+	h2Proxys     sync.Map //共用的http2 代理连接,key:代理,val:*h2Proxy
+	h2ProxyUpg   *http2.Upg
+	localAddrs   *localAddrPool
+	keyLogWriter io.Writer //tls 密钥日志
 }
 type msgClient struct {
 	time time.Time
@@ -52,17 +66,25 @@ const (
 type DialOption struct {
 	TLSHandshakeTimeout time.Duration
 	DnsCacheTime        time.Duration
+	DnsRootCAs          *x509.CertPool //DoH,DoT 校验证书使用的根证书,默认使用系统根证书
 	KeepAlive           time.Duration
 	GetProxy            func(ctx context.Context, url *url.URL) (string, error)
-	Proxy               string   //代理
-	LocalAddr           string   //使用本地网卡
-	AddrType            AddrType //优先使用的地址类型,ipv4,ipv6 ,或自动选项
+	Proxy               string        //代理,socks5 和socks5h 的tcp 连接都由代理解析域名,udp 时socks5 在本地解析,本地的dns 查询需要DnsProxy 才经过代理
+	ProxyChain          []string      //代理链,依次连接后再连接Proxy,例如：[]string{"socks5://127.0.0.1:1080","http://127.0.0.1:7890"}
+	LocalAddr           string        //使用本地网卡
+	LocalAddrs          []string      //出口ip 池,支持ip 和网段,例如：[]string{"192.168.1.2","2001:db8::/64"},按连接目标的ip 类型选择
+	LocalAddrMode       LocalAddrMode //出口ip 的选择方式,随机,轮换,同一个host 固定
+	AddrType            AddrType      //优先使用的地址类型,ipv4,ipv6 ,或自动选项
 	GetAddrType         func(string) AddrType
 	Ja3                 bool        //是否启用ja3
 	Ja3Spec             ja3.Ja3Spec //指定ja3Spec,使用ja3.CreateSpecWithStr 或者ja3.CreateSpecWithId 生成
 	ProxyJa3            bool        //代理是否启用ja3
 	ProxyJa3Spec        ja3.Ja3Spec //指定代理ja3Spec,使用ja3.CreateSpecWithStr 或者ja3.CreateSpecWithId 生成
 	Dns                 string      //dns
+	DnsServers          []string    //DoH,DoT 服务,按顺序失败切换,例如：https://dns.alidns.com/dns-query,tls://223.5.5.5:853
+	DnsPost             bool        //DoH 使用POST 请求,默认GET
+	DnsProxy            bool        //dns 请求使用代理,socks5 代理时udp 查询使用UDP ASSOCIATE 转发
+	KeyLogWriter        io.Writer   //tls 密钥日志,NSS key log 格式,为空时使用环境变量SSLKEYLOGFILE
 }
 
 func NewDail(ctx context.Context, option DialOption) (*DialClient, error) {
@@ -84,7 +106,18 @@ func NewDail(ctx context.Context, option DialOption) (*DialClient, error) {
 	if option.ProxyJa3Spec.IsSet() {
 		option.ProxyJa3 = true
 	}
-	var err error
+	if option.ProxyJa3 { //多个代理连接并发使用,创建时初始化
+		if !option.ProxyJa3Spec.IsSet() {
+			option.ProxyJa3Spec = ja3.DefaultJa3Spec()
+		}
+		if !option.ProxyJa3Spec.HasPsk() {
+			ja3.AddPsk(&option.ProxyJa3Spec)
+		}
+	}
+	keyLogWriter, err := newKeyLogWriter(option.KeyLogWriter)
+	if err != nil {
+		return nil, err
+	}
 	dialCli := &DialClient{
 		utlsConfig: &utls.Config{
 			InsecureSkipVerify:     true,
@@ -92,11 +125,13 @@ func NewDail(ctx context.Context, option DialOption) (*DialClient, error) {
 			SessionTicketKey:       [32]byte{},
 			ClientSessionCache:     utls.NewLRUClientSessionCache(0),
 			OmitEmptyPsk:           true,
+			KeyLogWriter:           keyLogWriter,
 		},
 		tlsConfig: &tls.Config{
 			InsecureSkipVerify: true,
 			SessionTicketKey:   [32]byte{},
 			ClientSessionCache: tls.NewLRUClientSessionCache(0),
+			KeyLogWriter:       keyLogWriter,
 		},
 		ctx: ctx,
 		dialer: &net.Dialer{
@@ -112,9 +147,25 @@ func NewDail(ctx context.Context, option DialOption) (*DialClient, error) {
 		ja3:          option.Ja3,
 		ja3Spec:      option.Ja3Spec,
 		dns:          option.Dns,
+		keyLogWriter: keyLogWriter,
+		h2ProxyUpg:   http2.NewUpg(nil),
 	}
 	dialCli.resolver = &net.Resolver{
-		Dial: dialCli.DnsDialContext,
+		PreferGo: option.DnsProxy, //使用代理时需要go 实现的dns 解析
+		Dial:     dialCli.DnsDialContext,
+	}
+	for _, server := range option.DnsServers {
+		serverUrl, err := parseDnsServer(server)
+		if err != nil {
+			return dialCli, err
+		}
+		dialCli.dnsServers = append(dialCli.dnsServers, serverUrl)
+	}
+	dialCli.dnsProxy = option.DnsProxy
+	if len(dialCli.dnsServers) > 0 {
+		dialCli.dnsPost = option.DnsPost
+		dialCli.dnsRootCAs = option.DnsRootCAs
+		dialCli.dohClient = dialCli.newDohClient()
 	}
 
 	if option.Proxy != "" {
@@ -122,6 +173,18 @@ func NewDail(ctx context.Context, option DialOption) (*DialClient, error) {
 			return dialCli, err
 		}
 	}
+	for _, proxy := range option.ProxyChain {
+		proxyUrl, err := verifyProxy(proxy)
+		if err != nil {
+			return dialCli, err
+		}
+		dialCli.proxyChain = append(dialCli.proxyChain, proxyUrl)
+	}
+	if len(option.LocalAddrs) > 0 {
+		if dialCli.localAddrs, err = newLocalAddrPool(option.LocalAddrs, option.LocalAddrMode); err != nil {
+			return dialCli, err
+		}
+	}
 	if option.LocalAddr != "" {
 		if !strings.Contains(option.LocalAddr, ":") {
 			option.LocalAddr += ":0"
@@ -202,24 +265,39 @@ func (obj *DialClient) AddrToIp(ctx context.Context, addr string) (string, error
 	if ipInt == 4 || ipInt == 6 {
 		return addr, nil
 	}
-	host, ok := obj.loadHost(host)
+	revHost, ok := obj.loadHost(host)
 	if !ok {
+		startTime := time.Now()
 		ip, err := obj.lookupIPAddr(ctx, host)
 		if err != nil {
 			return addr, tools.WrapError(err, "addrToIp 错误,lookupIPAddr")
 		}
-		host = ip.String()
-		obj.dnsIpData.Store(addr, msgClient{time: time.Now(), host: host})
+		ctxTiming(ctx).addDns(startTime)
+		revHost = ip.String()
+		obj.dnsIpData.Store(host, msgClient{time: time.Now(), host: revHost})
 	}
-	return net.JoinHostPort(host, port), nil
+	return net.JoinHostPort(revHost, port), nil
 }
 
 func (obj *DialClient) clientVerifySocks5(ctx context.Context, proxyUrl *url.URL, addr string, conn net.Conn) (err error) {
+	if err = obj.socks5Auth(proxyUrl, conn); err != nil {
+		return
+	}
+	addrCon, err := socks5AddrBytes(addr)
+	if err != nil {
+		return
+	}
+	_, err = socks5Command(conn, 1, addrCon)
+	return
+}
+
+// socks5 协商验证方式
+func (obj *DialClient) socks5Auth(proxyUrl *url.URL, conn net.Conn) (err error) {
 	if _, err = conn.Write([]byte{5, 2, 0, 2}); err != nil {
 		return
 	}
-	readCon := make([]byte, 4)
-	if _, err = io.ReadFull(conn, readCon[:2]); err != nil {
+	readCon := make([]byte, 2)
+	if _, err = io.ReadFull(conn, readCon); err != nil {
 		return
 	}
 	switch readCon[1] {
@@ -251,7 +329,7 @@ func (obj *DialClient) clientVerifySocks5(ctx context.Context, proxyUrl *url.URL
 		)); err != nil {
 			return
 		}
-		if _, err = io.ReadFull(conn, readCon[:2]); err != nil {
+		if _, err = io.ReadFull(conn, readCon); err != nil {
 			return
 		}
 		switch readCon[1] {
@@ -265,71 +343,89 @@ func (obj *DialClient) clientVerifySocks5(ctx context.Context, proxyUrl *url.URL
 		err = errors.New("不支持的验证方式")
 		return
 	}
-	var host string
-	var port int
-	if host, port, err = tools.SplitHostPort(addr); err != nil {
-		return
+	return
+}
+
+// socks5 地址编码,ATYP+地址+端口
+func socks5AddrBytes(addr string) ([]byte, error) {
+	host, port, err := tools.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
 	}
-	writeCon := []byte{5, 1, 0}
+	var con []byte
 	ip, ipInt := tools.ParseHost(host)
 	switch ipInt {
 	case 4:
-		writeCon = append(writeCon, 1)
-		writeCon = append(writeCon, ip...)
+		con = append(con, 1)
+		con = append(con, ip.To4()...)
 	case 6:
-		writeCon = append(writeCon, 4)
-		writeCon = append(writeCon, ip...)
-	case 0:
+		con = append(con, 4)
+		con = append(con, ip.To16()...)
+	default:
 		if len(host) > 255 {
-			err = errors.New("FQDN too long")
-			return
+			return nil, errors.New("FQDN too long")
 		}
-		writeCon = append(writeCon, 3)
-		writeCon = append(writeCon, byte(len(host)))
-		writeCon = append(writeCon, host...)
-	}
-	writeCon = append(writeCon, byte(port>>8), byte(port))
-	if _, err = conn.Write(writeCon); err != nil {
-		return
-	}
-	if _, err = io.ReadFull(conn, readCon); err != nil {
-		return
-	}
-	if readCon[0] != 5 {
-		err = errors.New("版本不对")
-		return
-	}
-	if readCon[1] != 0 {
-		err = errors.New("连接失败")
-		return
-	}
-	if readCon[3] != 1 {
-		err = errors.New("连接类型不一致")
-		return
+		con = append(con, 3, byte(len(host)))
+		con = append(con, host...)
 	}
+	return append(con, byte(port>>8), byte(port)), nil
+}
 
-	switch readCon[3] {
+// 读取socks5 地址,ATYP+地址+端口
+func readSocks5Addr(reader io.Reader) (string, error) {
+	readCon := make([]byte, 1)
+	if _, err := io.ReadFull(reader, readCon); err != nil {
+		return "", err
+	}
+	var host string
+	switch readCon[0] {
 	case 1: //ipv4地址
-		if _, err = io.ReadFull(conn, readCon); err != nil {
-			return
+		readCon = make([]byte, 4)
+		if _, err := io.ReadFull(reader, readCon); err != nil {
+			return "", err
 		}
+		host = net.IP(readCon).String()
 	case 3: //域名
-		if _, err = io.ReadFull(conn, readCon[:1]); err != nil { //域名的长度
-			return
+		if _, err := io.ReadFull(reader, readCon); err != nil { //域名的长度
+			return "", err
 		}
-		if _, err = io.ReadFull(conn, make([]byte, readCon[0])); err != nil {
-			return
+		readCon = make([]byte, readCon[0])
+		if _, err := io.ReadFull(reader, readCon); err != nil {
+			return "", err
 		}
+		host = string(readCon)
 	case 4: //IPv6地址
-		if _, err = io.ReadFull(conn, make([]byte, 16)); err != nil {
-			return
+		readCon = make([]byte, 16)
+		if _, err := io.ReadFull(reader, readCon); err != nil {
+			return "", err
 		}
+		host = net.IP(readCon).String()
 	default:
-		err = errors.New("invalid atyp")
-		return
+		return "", errors.New("invalid atyp")
 	}
-	_, err = io.ReadFull(conn, readCon[:2])
-	return
+	readCon = make([]byte, 2)
+	if _, err := io.ReadFull(reader, readCon); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(readCon[0])<<8|int(readCon[1]))), nil
+}
+
+// 发送socks5 命令,1:CONNECT,3:UDP ASSOCIATE,返回代理绑定的地址
+func socks5Command(conn net.Conn, cmd byte, addrCon []byte) (string, error) {
+	if _, err := conn.Write(append([]byte{5, cmd, 0}, addrCon...)); err != nil {
+		return "", err
+	}
+	readCon := make([]byte, 3)
+	if _, err := io.ReadFull(conn, readCon); err != nil {
+		return "", err
+	}
+	if readCon[0] != 5 {
+		return "", errors.New("版本不对")
+	}
+	if readCon[1] != 0 {
+		return "", errors.New("连接失败")
+	}
+	return readSocks5Addr(conn)
 }
 func cloneUrl(u *url.URL) *url.URL {
 	r := *u
@@ -343,7 +439,10 @@ func (obj *DialClient) DnsDialContext(ctx context.Context, netword string, addr
 			addr = net.JoinHostPort(obj.dns, "53")
 		}
 	}
-	return obj.dialer.DialContext(ctx, netword, addr)
+	if obj.dnsProxy && !isDnsBootstrap(ctx) { //dns 查询使用代理
+		return obj.dnsDialContext(ctx, netword, addr, &url.URL{Scheme: "dns", Host: addr})
+	}
+	return obj.netDialer(ctx, netword, addr).DialContext(ctx, netword, addr)
 }
 func (obj *DialClient) lookupIPAddr(ctx context.Context, host string) (net.IP, error) {
 	var addrType int
@@ -352,20 +451,32 @@ func (obj *DialClient) lookupIPAddr(ctx context.Context, host string) (net.IP, e
 	} else if obj.getAddrType != nil {
 		addrType = int(obj.getAddrType(host))
 	}
-	ips, err := obj.resolver.LookupIPAddr(ctx, host)
-	if err != nil {
-		return nil, err
+	if addrType == 0 && obj.localAddrs != nil { //没有指定时优先使用出口ip 池支持的类型
+		addrType = obj.localAddrs.addrType()
 	}
-	for _, ipAddr := range ips {
-		ip := ipAddr.IP
+	var ips []net.IP
+	if len(obj.dnsServers) > 0 && !isDnsBootstrap(ctx) {
+		var err error
+		if ips, err = obj.lookupIPAddrWithServers(ctx, host, addrType); err != nil {
+			return nil, err
+		}
+	} else {
+		ipAddrs, err := obj.resolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, ipAddr := range ipAddrs {
+			ips = append(ips, ipAddr.IP)
+		}
+	}
+	for _, ip := range ips {
 		if ipType := tools.ParseIp(ip); ipType == 4 || ipType == 6 {
 			if addrType == 0 || addrType == ipType {
 				return ip, nil
 			}
 		}
 	}
-	for _, ipAddr := range ips {
-		ip := ipAddr.IP
+	for _, ip := range ips {
 		if ipType := tools.ParseIp(ip); ipType == 4 || ipType == 6 {
 			return ip, nil
 		}
@@ -373,25 +484,42 @@ func (obj *DialClient) lookupIPAddr(ctx context.Context, host string) (net.IP, e
 	return nil, errors.New("dns 解析host 失败")
 }
 func (obj *DialClient) DialContext(ctx context.Context, netword string, addr string) (net.Conn, error) {
+	if strings.HasPrefix(netword, "udp") && !isDnsBootstrap(ctx) { //udp 使用socks5 代理
+		proxys, err := obj.dialProxys(ctx, &url.URL{Scheme: "udp", Host: addr})
+		if err != nil {
+			return nil, err
+		}
+		if len(proxys) > 0 {
+			return obj.DialContextWithProxys(ctx, netword, "", addr, addr, proxys)
+		}
+	}
 	revHost, err := obj.AddrToIp(ctx, addr)
 	if err != nil {
 		return nil, err
 	}
-	return obj.dialer.DialContext(ctx, netword, revHost)
+	startTime := time.Now()
+	conn, err := obj.netDialer(ctx, netword, revHost).DialContext(ctx, netword, revHost)
+	if err == nil {
+		ctxTiming(ctx).addConnect(startTime, conn)
+	}
+	return conn, err
 }
 func (obj *DialClient) AddProxyTls(ctx context.Context, conn net.Conn, host string) (net.Conn, error) {
+	return obj.addProxyTls(ctx, conn, host, false)
+}
+
+// h2 为true 时使用alpn 协商http2,用于http2 代理
+func (obj *DialClient) addProxyTls(ctx context.Context, conn net.Conn, host string, h2 bool) (net.Conn, error) {
 	if obj.proxyJa3 {
 		config := obj.utlsConfig.Clone()
 		config.ServerName = tools.GetServerName(host)
-		if !obj.proxyJa3Spec.IsSet() {
-			obj.proxyJa3Spec = ja3.DefaultJa3Spec()
-		}
-		if !obj.proxyJa3Spec.HasPsk() {
-			ja3.AddPsk(&obj.proxyJa3Spec)
-		}
-		return ja3.NewClient(ctx, conn, obj.proxyJa3Spec, true, config)
+		return ja3.NewClient(ctx, conn, obj.proxyJa3Spec, !h2, config)
+	}
+	nextProtos := []string{"http/1.1"}
+	if h2 {
+		nextProtos = []string{"h2"}
 	}
-	tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true, ServerName: tools.GetServerName(host), NextProtos: []string{"http/1.1"}})
+	tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true, ServerName: tools.GetServerName(host), NextProtos: nextProtos, KeyLogWriter: obj.keyLogWriter})
 	return tlsConn, tlsConn.HandshakeContext(ctx)
 }
 func (obj *DialClient) AddTls(ctx context.Context, conn net.Conn, host string, disHttp bool) (tlsConn *tls.Conn, err error) {
@@ -416,9 +544,9 @@ func (obj *DialClient) AddTls(ctx context.Context, conn net.Conn, host string, d
 		return
 	}
 	if disHttp {
-		tlsConn = tls.Client(conn, &tls.Config{InsecureSkipVerify: true, ServerName: tools.GetServerName(host), NextProtos: []string{"http/1.1"}})
+		tlsConn = tls.Client(conn, &tls.Config{InsecureSkipVerify: true, ServerName: tools.GetServerName(host), NextProtos: []string{"http/1.1"}, KeyLogWriter: obj.keyLogWriter})
 	} else {
-		tlsConn = tls.Client(conn, &tls.Config{InsecureSkipVerify: true, ServerName: tools.GetServerName(host), NextProtos: []string{"h2", "http/1.1"}})
+		tlsConn = tls.Client(conn, &tls.Config{InsecureSkipVerify: true, ServerName: tools.GetServerName(host), NextProtos: []string{"h2", "http/1.1"}, KeyLogWriter: obj.keyLogWriter})
 	}
 	if err = tlsConn.HandshakeContext(ctx); err != nil {
 		err = tools.WrapError(err, "dialClient AddTls tls HandshakeContext 错误")
@@ -436,26 +564,72 @@ func (obj *DialClient) newPwdConn(conn net.Conn, proxyUrl *url.URL) net.Conn {
 	return conn
 }
 func (obj *DialClient) Socks5Proxy(ctx context.Context, network string, addr string, proxyUrl *url.URL) (conn net.Conn, err error) {
-	defer func() {
-		if err != nil && conn != nil {
-			conn.Close()
-		}
-	}()
-	if conn, err = obj.DialContext(ctx, network, net.JoinHostPort(proxyUrl.Hostname(), proxyUrl.Port())); err != nil {
-		return
-	}
+	return obj.DialContextWithProxys(ctx, network, "", addr, addr, []*url.URL{proxyUrl})
+}
+
+// 在ctx 结束前完成代理的握手
+func verifyWithContext(ctx context.Context, verify func() error) (err error) {
 	didVerify := make(chan struct{})
 	go func() {
 		defer close(didVerify)
-		err = obj.clientVerifySocks5(ctx, proxyUrl, addr, conn)
+		err = verify()
 	}()
 	select {
 	case <-ctx.Done():
-		return conn, ctx.Err()
+		return ctx.Err()
 	case <-didVerify:
 		return
 	}
 }
+
+// socks4 代理握手,socks4a 时由代理解析域名
+func (obj *DialClient) clientVerifySocks4(ctx context.Context, proxyUrl *url.URL, addr string, conn net.Conn) (err error) {
+	host, port, err := tools.SplitHostPort(addr)
+	if err != nil {
+		return
+	}
+	ip, ipInt := tools.ParseHost(host)
+	if ipInt == 6 {
+		return errors.New("socks4 不支持ipv6")
+	}
+	var domain string
+	if ipInt != 4 {
+		if proxyUrl.Scheme == "socks4a" {
+			ip, domain = net.IPv4(0, 0, 0, 1).To4(), host
+		} else {
+			if addr, err = obj.AddrToIp(ctx, addr); err != nil {
+				return
+			}
+			if host, _, err = tools.SplitHostPort(addr); err != nil {
+				return
+			}
+			if ip, ipInt = tools.ParseHost(host); ipInt != 4 {
+				return errors.New("socks4 不支持ipv6")
+			}
+		}
+	}
+	writeCon := []byte{4, 1, byte(port >> 8), byte(port)}
+	writeCon = append(writeCon, ip.To4()...)
+	if proxyUrl.User != nil {
+		writeCon = append(writeCon, proxyUrl.User.Username()...)
+	}
+	writeCon = append(writeCon, 0)
+	if domain != "" {
+		writeCon = append(writeCon, domain...)
+		writeCon = append(writeCon, 0)
+	}
+	if _, err = conn.Write(writeCon); err != nil {
+		return
+	}
+	readCon := make([]byte, 8)
+	if _, err = io.ReadFull(conn, readCon); err != nil {
+		return
+	}
+	if readCon[1] != 90 {
+		return errors.New("socks4 连接失败")
+	}
+	return
+}
 func (obj *DialClient) clientVerifyHttps(ctx context.Context, proxyUrl *url.URL, addr string, host string, conn net.Conn) (err error) {
 	hdr := make(http.Header)
 	hdr.Set("User-Agent", UserAgent)
@@ -508,52 +682,140 @@ func (obj *DialClient) DialContextWithProxy(ctx context.Context, netword string,
 	if proxyUrl == nil {
 		return obj.DialContext(ctx, netword, addr)
 	}
+	return obj.DialContextWithProxys(ctx, netword, scheme, addr, host, []*url.URL{proxyUrl})
+}
+
+// 补全代理的端口
+func proxyWithPort(proxyUrl *url.URL) *url.URL {
+	proxyUrl = cloneUrl(proxyUrl)
 	if proxyUrl.Port() == "" {
-		if proxyUrl.Scheme == "http" {
+		switch proxyUrl.Scheme {
+		case "http":
 			proxyUrl.Host = net.JoinHostPort(proxyUrl.Hostname(), "80")
-		} else if proxyUrl.Scheme == "https" {
+		case "https", "h2":
 			proxyUrl.Host = net.JoinHostPort(proxyUrl.Hostname(), "443")
+		case "socks5", "socks5h", "socks4", "socks4a":
+			proxyUrl.Host = net.JoinHostPort(proxyUrl.Hostname(), "1080")
 		}
 	}
+	return proxyUrl
+}
+
+// 通过一个代理连接addr,conn 为已经连接到代理的连接
+func (obj *DialClient) proxyHandshake(ctx context.Context, conn net.Conn, proxyUrl *url.URL, addr string, host string) (net.Conn, error) {
 	switch proxyUrl.Scheme {
 	case "http", "https":
-		conn, err := obj.DialContext(ctx, netword, net.JoinHostPort(proxyUrl.Hostname(), proxyUrl.Port()))
-		if err != nil {
-			return conn, err
-		} else if proxyUrl.Scheme == "https" {
-			if conn, err = obj.AddTls(ctx, conn, proxyUrl.Host, true); err != nil {
+		if proxyUrl.Scheme == "https" {
+			tlsConn, err := obj.AddTls(ctx, conn, proxyUrl.Host, true)
+			if err != nil {
 				return conn, err
 			}
+			conn = tlsConn
 		}
 		return conn, obj.clientVerifyHttps(ctx, proxyUrl, addr, host, conn)
-	case "socks5":
-		return obj.Socks5Proxy(ctx, netword, addr, proxyUrl)
+	case "socks5", "socks5h":
+		return conn, verifyWithContext(ctx, func() error {
+			return obj.clientVerifySocks5(ctx, proxyUrl, addr, conn)
+		})
+	case "socks4", "socks4a":
+		return conn, verifyWithContext(ctx, func() error {
+			return obj.clientVerifySocks4(ctx, proxyUrl, addr, conn)
+		})
+	case "h2":
+		return obj.h2ProxyStream(ctx, conn, proxyUrl, addr)
 	default:
-		return nil, errors.New("proxyUrl Scheme error")
+		return conn, errors.New("proxyUrl Scheme error")
+	}
+}
+
+// 按顺序通过多个代理连接addr,例如：socks5 -> http -> addr
+func (obj *DialClient) DialContextWithProxys(ctx context.Context, network string, scheme string, addr string, host string, proxyUrls []*url.URL) (conn net.Conn, err error) {
+	if len(proxyUrls) == 0 {
+		return obj.DialContext(ctx, network, addr)
+	}
+	defer func() {
+		if err != nil && conn != nil {
+			conn.Close()
+		}
+	}()
+	proxys := make([]*url.URL, len(proxyUrls))
+	for i, proxyUrl := range proxyUrls {
+		proxys[i] = proxyWithPort(proxyUrl)
 	}
+	if strings.HasPrefix(network, "udp") { //udp 通过socks5 代理的UDP ASSOCIATE 转发
+		if len(proxys) > 1 {
+			return nil, errors.New("udp 不支持代理链")
+		}
+		return obj.socks5UdpDial(ctx, addr, proxys[0])
+	}
+	startTime := time.Now()
+	if proxys[0].Scheme == "h2" { //多个请求共用到http2 代理的连接
+		conn, err = obj.h2ProxyShared(ctx, network, proxys[0], proxyNextAddr(proxys, 0, addr))
+	} else if conn, err = obj.DialContext(ctx, network, proxys[0].Host); err == nil {
+		startTime = time.Now()
+		conn, err = obj.proxyHandshake(ctx, conn, proxys[0], proxyNextAddr(proxys, 0, addr), proxyNextHost(proxys, 0, host))
+	}
+	for i := 1; i < len(proxys) && err == nil; i++ {
+		conn, err = obj.proxyHandshake(ctx, conn, proxys[i], proxyNextAddr(proxys, i, addr), proxyNextHost(proxys, i, host))
+	}
+	if err == nil {
+		ctxTiming(ctx).addProxy(startTime)
+	}
+	return
+}
+
+// 第i 个代理需要连接的地址
+func proxyNextAddr(proxys []*url.URL, i int, addr string) string {
+	if i < len(proxys)-1 {
+		return proxys[i+1].Host
+	}
+	return addr
+}
+func proxyNextHost(proxys []*url.URL, i int, host string) string {
+	if i < len(proxys)-1 {
+		return proxys[i+1].Host
+	}
+	return host
+}
+
+// 连接需要使用的代理,最后一个为出口代理,请求单独设置的代理和代理链优先级最高
+func (obj *DialClient) dialProxys(ctx context.Context, href *url.URL) ([]*url.URL, error) {
+	reqData, _ := ctx.Value(keyPrincipalID).(*reqCtxData)
+	if reqData != nil && (reqData.disProxy || reqData.isCallback) { //走正常连接
+		return nil, nil
+	}
+	var proxy *url.URL
+	var err error
+	if reqData != nil && reqData.proxy != nil {
+		proxy = reqData.proxy
+	} else if proxy, err = obj.GetProxy(ctx, href); err != nil {
+		return nil, err
+	}
+	proxys := obj.proxyChain
+	if reqData != nil && reqData.proxyChain != nil {
+		proxys = reqData.proxyChain
+	}
+	if proxy != nil {
+		proxys = append(append([]*url.URL{}, proxys...), proxy)
+	}
+	return proxys, nil
 }
 func (obj *DialClient) requestHttpDialContext(ctx context.Context, network string, addr string) (conn net.Conn, err error) {
 	reqData := ctx.Value(keyPrincipalID).(*reqCtxData)
 	if reqData.url == nil {
 		return nil, tools.WrapError(ErrFatal, "not found reqData.url")
 	}
-	var nowProxy *url.URL
-	if reqData.disProxy || reqData.isCallback { //走正常连接
-		if conn, err = obj.DialContext(ctx, network, addr); err != nil {
-			err = tools.WrapError(err, "requestHttpDialContext DialContext 错误")
-		}
-		return
-	} else if reqData.proxy != nil { //单独代理设置优先级最高
-		nowProxy = reqData.proxy
-	} else if nowProxy, err = obj.GetProxy(ctx, reqData.url); err != nil {
-		err = tools.WrapError(err, "requestHttpDialContext GetProxy 错误")
-		return nil, err
+	proxys, err := obj.dialProxys(ctx, reqData.url)
+	if err != nil {
+		return nil, tools.WrapError(err, "requestHttpDialContext GetProxy 错误")
 	}
-	if nowProxy != nil { //走自实现代理
-		if conn, err = obj.DialContextWithProxy(ctx, network, reqData.url.Scheme, addr, reqData.host, nowProxy); err != nil {
-			err = tools.WrapError(err, "requestHttpDialContext DialContextWithProxy 错误")
+	if len(proxys) > 0 { //走自实现代理,最后一个代理为出口代理
+		nowProxy := proxys[len(proxys)-1]
+		if conn, err = obj.DialContextWithProxys(ctx, network, reqData.url.Scheme, addr, reqData.host, proxys); err != nil {
+			err = &proxyDialError{proxy: nowProxy, err: tools.WrapError(err, "requestHttpDialContext DialContextWithProxys 错误")}
+			return
 		}
-		return
+		return &proxyConn{Conn: conn, proxy: nowProxy, dialCli: obj}, nil
 	}
 	if conn, err = obj.DialContext(ctx, network, addr); err != nil {
 		err = tools.WrapError(err, "requestHttpDialContext DialContext2 错误")
@@ -567,12 +829,115 @@ func (obj *DialClient) requestHttpDialTlsContext(preCtx context.Context, network
 	ctx, cnl := context.WithTimeout(preCtx, obj.dialer.Timeout)
 	defer cnl()
 	reqData := ctx.Value(keyPrincipalID).(*reqCtxData)
-	conn, err = obj.AddTls(ctx, conn, reqData.host, reqData.ws)
+	rawConn := conn
+	startTime := time.Now()
+	if conn, err = obj.AddTls(ctx, rawConn, reqData.host, reqData.ws); err == nil {
+		reqData.timing.addTls(startTime)
+		obj.bindProxyConn(conn, rawConn)
+	}
 	return
 }
+
+// http1.1 连接,包装成可以重写请求头顺序的连接
+func (obj *DialClient) requestHttp1DialContext(ctx context.Context, network string, addr string) (net.Conn, error) {
+	conn, err := obj.requestHttpDialContext(ctx, network, addr)
+	if err != nil {
+		return conn, err
+	}
+	orderConn := newOrderConn(conn)
+	obj.bindProxyConn(orderConn, conn)
+	return orderConn, nil
+}
+
+// tls 连接,协商的协议不是h2 时包装成可以重写请求头顺序的连接
+func (obj *DialClient) requestHttp1DialTlsContext(ctx context.Context, network string, addr string) (net.Conn, error) {
+	conn, err := obj.requestHttpDialTlsContext(ctx, network, addr)
+	if err != nil {
+		return conn, err
+	}
+	if tlsConn, ok := conn.(*tls.Conn); ok && tlsConn.ConnectionState().NegotiatedProtocol == "h2" {
+		return conn, nil
+	}
+	orderConn := newOrderConn(conn)
+	obj.bindProxyConn(orderConn, conn)
+	return orderConn, nil
+}
+
+// h2c 连接,不需要重写请求头顺序
+func (obj *DialClient) requestH2cDialContext(ctx context.Context, network string, addr string) (net.Conn, error) {
+	conn, err := obj.requestHttpDialContext(ctx, network, addr)
+	if err != nil {
+		return conn, err
+	}
+	obj.bindProxyConn(conn, conn)
+	return conn, nil
+}
 func (obj *DialClient) requestHttp2DialTlsContext(ctx context.Context, network string, addr string, cfg *tls.Config) (net.Conn, error) { //验证tls 是否可以直接用
 	if cfg.ServerName != "" {
 		ctx.Value(keyPrincipalID).(*reqCtxData).host = cfg.ServerName
 	}
 	return obj.requestHttpDialTlsContext(ctx, network, addr)
 }
+
+// 通过代理建立的连接,关闭时删除连接和代理的对应关系
+type proxyConn struct {
+	net.Conn
+	proxy   *url.URL
+	dialCli *DialClient
+	lock    sync.Mutex
+	keys    []net.Conn
+}
+
+func (obj *proxyConn) Close() error {
+	obj.lock.Lock()
+	keys := obj.keys
+	obj.keys = nil
+	obj.lock.Unlock()
+	for _, key := range keys {
+		obj.dialCli.proxyConns.Delete(key)
+	}
+	return obj.Conn.Close()
+}
+
+// 通过代理建立连接失败的错误,记录使用的代理
+type proxyDialError struct {
+	proxy *url.URL
+	err   error
+}
+
+func (obj *proxyDialError) Error() string {
+	return obj.err.Error()
+}
+func (obj *proxyDialError) Unwrap() error {
+	return obj.err
+}
+
+// 记录外层连接使用的代理,inner 为内层连接
+func (obj *DialClient) bindProxyConn(conn net.Conn, inner net.Conn) {
+	pConn, ok := inner.(*proxyConn)
+	if !ok {
+		val, ok := obj.proxyConns.Load(inner)
+		if !ok {
+			return
+		}
+		pConn = val.(*proxyConn)
+	}
+	pConn.lock.Lock()
+	pConn.keys = append(pConn.keys, conn)
+	pConn.lock.Unlock()
+	obj.proxyConns.Store(conn, pConn)
+}
+
+// 返回连接使用的代理,没有使用代理返回nil
+func (obj *DialClient) ConnProxy(conn net.Conn) *url.URL {
+	if pConn, ok := conn.(*proxyConn); ok {
+		return pConn.proxy
+	}
+	if val, ok := obj.proxyConns.Load(conn); ok {
+		return val.(*proxyConn).proxy
+	}
+	if netConn, ok := conn.(interface{ NetConn() net.Conn }); ok { //h2c 升级后的连接
+		return obj.ConnProxy(netConn.NetConn())
+	}
+	return nil
+}
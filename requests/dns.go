@@ -0,0 +1,246 @@
+package requests
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"gitee.com/baixudong/gospider/tools"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const keyDnsBootstrap = "gospiderDnsBootstrap"
+
+// 解析dns 服务,支持https://(DoH),tls://(DoT),没有协议时为DoT,例如：tls://223.5.5.5:853,https://dns.alidns.com/dns-query
+func parseDnsServer(server string) (*url.URL, error) {
+	if !strings.Contains(server, "://") {
+		server = "tls://" + server
+	}
+	serverUrl, err := url.Parse(server)
+	if err != nil {
+		return nil, err
+	}
+	switch serverUrl.Scheme {
+	case "https":
+		if serverUrl.Path == "" {
+			serverUrl.Path = "/dns-query"
+		}
+	case "tls":
+		if serverUrl.Port() == "" {
+			serverUrl.Host = net.JoinHostPort(serverUrl.Hostname(), "853")
+		}
+	default:
+		return nil, errors.New("不支持的dns 服务协议: " + serverUrl.Scheme)
+	}
+	return serverUrl, nil
+}
+
+// 是否是解析dns 服务地址的请求,这时使用系统dns,防止循环解析
+func isDnsBootstrap(ctx context.Context) bool {
+	val, _ := ctx.Value(keyDnsBootstrap).(bool)
+	return val
+}
+
+// 连接dns 服务,dnsProxy 为true 时使用客户端的代理,udp 查询只有socks5 代理可以转发,其它代理改用tcp 查询
+func (obj *DialClient) dnsDialContext(ctx context.Context, network string, addr string, serverUrl *url.URL) (net.Conn, error) {
+	ctx = context.WithValue(context.WithValue(ctx, keyPrincipalID, nil), keyDnsBootstrap, true) //不统计到请求的耗时中
+	if !obj.dnsProxy {
+		return obj.DialContext(ctx, network, addr)
+	}
+	proxys, err := obj.dialProxys(ctx, serverUrl)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(network, "udp") && len(proxys) > 0 {
+		if scheme := proxys[len(proxys)-1].Scheme; len(proxys) > 1 || (scheme != "socks5" && scheme != "socks5h") {
+			network = "tcp"
+		}
+	}
+	return obj.DialContextWithProxys(ctx, network, serverUrl.Scheme, addr, serverUrl.Host, proxys)
+}
+
+// 构造dns 查询
+func newDnsQuery(host string, qType dnsmessage.Type) ([]byte, uint16, error) {
+	if !strings.HasSuffix(host, ".") {
+		host += "."
+	}
+	name, err := dnsmessage.NewName(host)
+	if err != nil {
+		return nil, 0, err
+	}
+	id := uint16(rand.Intn(1 << 16))
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{
+			Name:  name,
+			Type:  qType,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	query, err := msg.Pack()
+	return query, id, err
+}
+
+// 解析dns 响应中的ip
+func parseDnsAnswer(con []byte, id uint16) ([]net.IP, error) {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(con); err != nil {
+		return nil, err
+	}
+	if id != 0 && msg.Header.ID != id {
+		return nil, errors.New("dns 响应id 不一致")
+	}
+	if msg.Header.RCode != dnsmessage.RCodeSuccess {
+		return nil, errors.New("dns 响应错误: " + msg.Header.RCode.String())
+	}
+	var ips []net.IP
+	for _, answer := range msg.Answers {
+		switch body := answer.Body.(type) {
+		case *dnsmessage.AResource:
+			ips = append(ips, net.IP(body.A[:]))
+		case *dnsmessage.AAAAResource:
+			ips = append(ips, net.IP(body.AAAA[:]))
+		}
+	}
+	return ips, nil
+}
+
+// DNS-over-HTTPS,RFC 8484
+func (obj *DialClient) dohExchange(ctx context.Context, serverUrl *url.URL, query []byte) ([]byte, error) {
+	var req *http.Request
+	var err error
+	if obj.dnsPost {
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, serverUrl.String(), bytes.NewReader(query))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/dns-message")
+		}
+	} else {
+		getUrl := cloneUrl(serverUrl)
+		params := getUrl.Query()
+		params.Set("dns", base64.RawURLEncoding.EncodeToString(query))
+		getUrl.RawQuery = params.Encode()
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, getUrl.String(), nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := obj.dohClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, errors.New("doh 响应错误: " + resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 65535))
+}
+
+// DNS-over-TLS,RFC 7858
+func (obj *DialClient) dotExchange(ctx context.Context, serverUrl *url.URL, query []byte) ([]byte, error) {
+	conn, err := obj.dnsDialContext(ctx, "tcp", serverUrl.Host, serverUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	tlsConn := tls.Client(conn, &tls.Config{RootCAs: obj.dnsRootCAs, ServerName: serverUrl.Hostname(), KeyLogWriter: obj.keyLogWriter}) //ip 地址校验证书的ip SAN
+	if err = tlsConn.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(msg, uint16(len(query)))
+	copy(msg[2:], query)
+	if _, err = tlsConn.Write(msg); err != nil {
+		return nil, err
+	}
+	if _, err = io.ReadFull(tlsConn, msg[:2]); err != nil {
+		return nil, err
+	}
+	con := make([]byte, binary.BigEndian.Uint16(msg[:2]))
+	_, err = io.ReadFull(tlsConn, con)
+	return con, err
+}
+
+// 向一个dns 服务查询host
+func (obj *DialClient) dnsExchange(ctx context.Context, serverUrl *url.URL, host string, qType dnsmessage.Type) ([]net.IP, error) {
+	query, id, err := newDnsQuery(host, qType)
+	if err != nil {
+		return nil, err
+	}
+	var con []byte
+	if serverUrl.Scheme == "https" {
+		binary.BigEndian.PutUint16(query, 0) //doh 推荐id 为0,利于缓存
+		id = 0
+		con, err = obj.dohExchange(ctx, serverUrl, query)
+	} else {
+		con, err = obj.dotExchange(ctx, serverUrl, query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseDnsAnswer(con, id)
+}
+
+// 按顺序使用dns 服务解析host,失败时切换下一个,addrType 对应的地址类型优先查询
+func (obj *DialClient) lookupIPAddrWithServers(ctx context.Context, host string, addrType int) ([]net.IP, error) {
+	qTypes := []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA}
+	if addrType == 6 {
+		qTypes[0], qTypes[1] = qTypes[1], qTypes[0]
+	}
+	var err error
+	for _, serverUrl := range obj.dnsServers {
+		var ips []net.IP
+		var serverErr error
+		for _, qType := range qTypes {
+			tempCtx, cnl := context.WithTimeout(ctx, obj.dialer.Timeout)
+			tempIps, tempErr := obj.dnsExchange(tempCtx, serverUrl, host, qType)
+			cnl()
+			if tempErr != nil {
+				serverErr = tempErr
+				break
+			}
+			if ips = append(ips, tempIps...); len(ips) > 0 {
+				break
+			}
+		}
+		if len(ips) > 0 {
+			return ips, nil
+		}
+		if serverErr == nil {
+			serverErr = errors.New("dns 没有查询到ip")
+		}
+		err = tools.WrapError(serverErr, serverUrl.String())
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, err
+}
+
+// 请求doh 服务的客户端
+func (obj *DialClient) newDohClient() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network string, addr string) (net.Conn, error) {
+				return obj.dnsDialContext(ctx, network, addr, &url.URL{Scheme: "https", Host: addr})
+			},
+			TLSClientConfig:     &tls.Config{RootCAs: obj.dnsRootCAs, KeyLogWriter: obj.keyLogWriter}, //ServerName 使用doh 服务的host
+			ForceAttemptHTTP2:   true,
+			TLSHandshakeTimeout: obj.dialer.Timeout,
+			IdleConnTimeout:     time.Second * 90,
+		},
+	}
+}
@@ -0,0 +1,70 @@
+package requests
+
+import (
+	"net"
+	"net/http"
+	"sync"
+
+	"gitee.com/baixudong/gospider/http2"
+	"gitee.com/baixudong/gospider/tools"
+)
+
+// h2c 传输,http 请求不经过tls 直接使用http2,使用h2指纹
+type h2cTransport struct {
+	enable    bool //http 请求使用prior knowledge
+	upgrade   bool //http 请求通过Upgrade: h2c 升级
+	upg       *http2.Upg
+	transport http.RoundTripper
+	refused   sync.Map //key:拒绝Upgrade: h2c 的host:port,之后的请求使用http1.1
+}
+
+func newH2cTransport(dialCli *DialClient, transport http.RoundTripper, option ClientOption) *h2cTransport {
+	return &h2cTransport{
+		enable:  option.H2c,
+		upgrade: option.H2cUpgrade,
+		upg: http2.NewUpg(nil, http2.UpgOption{
+			H2Ja3Spec:          option.H2Ja3Spec,
+			DisableCompression: option.DisCompression,
+			H2c:                true,
+			DialContext:        dialCli.requestH2cDialContext,
+		}),
+		transport: transport,
+	}
+}
+func (obj *h2cTransport) CloseIdleConnections() {
+	obj.upg.CloseIdleConnections()
+	if transport, ok := obj.transport.(interface{ CloseIdleConnections() }); ok {
+		transport.CloseIdleConnections()
+	}
+}
+func (obj *h2cTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctxData := req.Context().Value(keyPrincipalID).(*reqCtxData)
+	upgrade := obj.upgrade || ctxData.h2cUpgrade
+	if req.URL.Scheme != "http" || ctxData.ws || !(upgrade || obj.enable || ctxData.h2c) {
+		return obj.transport.RoundTrip(req)
+	}
+	if err := setRequestData(req); err != nil {
+		return nil, err
+	}
+	authority := req.URL.Host
+	if req.URL.Port() == "" {
+		authority = net.JoinHostPort(req.URL.Hostname(), "80")
+	}
+	if _, ok := obj.refused.Load(authority); ok && upgrade {
+		return obj.transport.RoundTrip(req)
+	}
+	var resp *http.Response
+	var err error
+	if upgrade {
+		resp, err = obj.upg.UpgradeRoundTrip(req)
+	} else {
+		resp, err = obj.upg.RoundTrip(req)
+	}
+	if err != nil {
+		return resp, tools.WrapError(err, "h2c 请求错误")
+	}
+	if upgrade && resp.ProtoMajor == 1 { //服务端不支持h2c,返回的是http1.1 的响应
+		obj.refused.Store(authority, struct{}{})
+	}
+	return resp, nil
+}
@@ -0,0 +1,219 @@
+package requests
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gitee.com/baixudong/gospider/http2"
+	"gitee.com/baixudong/gospider/tools"
+	utls "github.com/refraction-networking/utls"
+)
+
+// 到http2 代理的连接
+type h2Proxy struct {
+	conn net.Conn
+	cc   *http2.ClientConn
+}
+
+// 通过共用的http2 连接向代理发送CONNECT 请求,连接不可用时新建连接
+func (obj *DialClient) h2ProxyShared(ctx context.Context, network string, proxyUrl *url.URL, addr string) (net.Conn, error) {
+	key := proxyUrl.String()
+	if val, ok := obj.h2Proxys.Load(key); ok {
+		if proxy := val.(*h2Proxy); proxy.cc.CanTakeNewRequest() {
+			stream, err := obj.h2Connect(ctx, proxy, proxyUrl, addr)
+			if err != nil {
+				return nil, err
+			}
+			return stream, nil
+		}
+		obj.h2Proxys.CompareAndDelete(key, val)
+	}
+	conn, err := obj.DialContext(ctx, network, proxyUrl.Host)
+	if err != nil {
+		return nil, err
+	}
+	proxy, err := obj.newH2Proxy(ctx, conn, proxyUrl)
+	if err != nil {
+		return conn, err
+	}
+	if val, loaded := obj.h2Proxys.LoadOrStore(key, proxy); loaded { //并发建立了多个连接,只保留一个
+		if other := val.(*h2Proxy); other.cc.CanTakeNewRequest() {
+			proxy.cc.Close()
+			proxy = other
+		} else {
+			obj.h2Proxys.Store(key, proxy)
+		}
+	}
+	stream, err := obj.h2Connect(ctx, proxy, proxyUrl, addr)
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+// 在已经建立的连接上使用http2 CONNECT 代理,用于代理链中间的代理,不共用连接
+func (obj *DialClient) h2ProxyStream(ctx context.Context, conn net.Conn, proxyUrl *url.URL, addr string) (net.Conn, error) {
+	proxy, err := obj.newH2Proxy(ctx, conn, proxyUrl)
+	if err != nil {
+		return conn, err
+	}
+	stream, err := obj.h2Connect(ctx, proxy, proxyUrl, addr)
+	if err != nil {
+		return conn, err
+	}
+	stream.closeConn = true
+	return stream, nil
+}
+func (obj *DialClient) newH2Proxy(ctx context.Context, conn net.Conn, proxyUrl *url.URL) (*h2Proxy, error) {
+	tlsConn, err := obj.addProxyTls(ctx, conn, proxyUrl.Host, true)
+	if err != nil {
+		return nil, err
+	}
+	var proto string
+	switch tlsConn := tlsConn.(type) {
+	case *tls.Conn:
+		proto = tlsConn.ConnectionState().NegotiatedProtocol
+	case *utls.UConn:
+		proto = tlsConn.ConnectionState().NegotiatedProtocol
+	}
+	if proto != "h2" {
+		return nil, errors.New("代理不支持http2")
+	}
+	cc, err := obj.h2ProxyUpg.NewClientConn(tlsConn)
+	if err != nil {
+		return nil, err
+	}
+	return &h2Proxy{conn: tlsConn, cc: cc}, nil
+}
+
+// 关闭到http2 代理的连接,all 为false 时只关闭没有stream 的连接
+func (obj *DialClient) closeH2Proxys(all bool) {
+	obj.h2Proxys.Range(func(key, val any) bool {
+		if proxy := val.(*h2Proxy); all || proxy.cc.StreamsActive() == 0 {
+			obj.h2Proxys.CompareAndDelete(key, val)
+			proxy.cc.Close()
+		}
+		return true
+	})
+}
+
+// 发送CONNECT 请求,返回的连接读写CONNECT 请求的body
+func (obj *DialClient) h2Connect(ctx context.Context, proxy *h2Proxy, proxyUrl *url.URL, addr string) (*h2StreamConn, error) {
+	reader, writer := io.Pipe()
+	streamCtx, streamCnl := context.WithCancel(obj.ctx) //连接的生命周期不受ctx 控制
+	req := &http.Request{
+		Method:        http.MethodConnect,
+		URL:           &url.URL{Host: addr},
+		Host:          addr,
+		Header:        http.Header{"User-Agent": []string{UserAgent}},
+		Body:          reader,
+		ContentLength: -1,
+	}
+	if proxyUrl.User != nil {
+		if password, ok := proxyUrl.User.Password(); ok {
+			req.Header.Set("Proxy-Authorization", "Basic "+tools.Base64Encode(proxyUrl.User.Username()+":"+password))
+		}
+	}
+	var resp *http.Response
+	var err error
+	if err = verifyWithContext(ctx, func() error {
+		resp, err = proxy.cc.RoundTrip(req.WithContext(streamCtx))
+		return err
+	}); err != nil {
+		streamCnl()
+		writer.Close()
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		streamCnl()
+		writer.Close()
+		resp.Body.Close()
+		return nil, errors.New(resp.Status)
+	}
+	return &h2StreamConn{Conn: proxy.conn, body: resp.Body, writer: writer, cnl: streamCnl}, nil
+}
+
+// http2 CONNECT 的一个stream,地址和底层连接一致,超时后取消stream
+type h2StreamConn struct {
+	net.Conn
+	body      io.ReadCloser
+	writer    *io.PipeWriter
+	cnl       context.CancelFunc
+	closeConn bool //关闭时是否关闭底层连接
+
+	lock        sync.Mutex
+	readTimer   *time.Timer
+	writerTimer *time.Timer
+	timeouted   atomic.Bool
+}
+
+func (obj *h2StreamConn) Read(b []byte) (int, error) {
+	n, err := obj.body.Read(b)
+	if err != nil && obj.timeouted.Load() {
+		err = os.ErrDeadlineExceeded
+	}
+	return n, err
+}
+func (obj *h2StreamConn) Write(b []byte) (int, error) {
+	n, err := obj.writer.Write(b)
+	if err != nil && obj.timeouted.Load() {
+		err = os.ErrDeadlineExceeded
+	}
+	return n, err
+}
+func (obj *h2StreamConn) Close() error {
+	obj.lock.Lock()
+	for _, timer := range []*time.Timer{obj.readTimer, obj.writerTimer} {
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+	obj.lock.Unlock()
+	obj.writer.Close()
+	obj.body.Close()
+	obj.cnl()
+	if obj.closeConn {
+		return obj.Conn.Close()
+	}
+	return nil
+}
+
+// 超时后取消stream,底层连接上的其它stream 不受影响
+func (obj *h2StreamConn) timeout() {
+	obj.timeouted.Store(true)
+	obj.writer.CloseWithError(os.ErrDeadlineExceeded)
+	obj.cnl()
+}
+func (obj *h2StreamConn) setTimer(timer **time.Timer, t time.Time) {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	if *timer != nil {
+		(*timer).Stop()
+		*timer = nil
+	}
+	if !t.IsZero() { //零值表示没有超时
+		*timer = time.AfterFunc(time.Until(t), obj.timeout)
+	}
+}
+func (obj *h2StreamConn) SetDeadline(t time.Time) error {
+	obj.SetReadDeadline(t)
+	obj.SetWriteDeadline(t)
+	return nil
+}
+func (obj *h2StreamConn) SetReadDeadline(t time.Time) error {
+	obj.setTimer(&obj.readTimer, t)
+	return nil
+}
+func (obj *h2StreamConn) SetWriteDeadline(t time.Time) error {
+	obj.setTimer(&obj.writerTimer, t)
+	return nil
+}
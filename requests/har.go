@@ -0,0 +1,515 @@
+package requests
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"gitee.com/baixudong/gospider/tools"
+)
+
+// HAR 1.2 格式,http://www.softwareishard.com/blog/har-12-spec/
+type Har struct {
+	Log HarLog `json:"log"`
+}
+type HarLog struct {
+	Version string     `json:"version"`
+	Creator HarCreator `json:"creator"`
+	Entries []HarEntry `json:"entries"`
+}
+type HarCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+type HarEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"` //总耗时,毫秒
+	Request         HarRequest  `json:"request"`
+	Response        HarResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HarTimings  `json:"timings"`
+	ServerIPAddress string      `json:"serverIPAddress,omitempty"`
+	Connection      string      `json:"connection,omitempty"`
+}
+type HarRequest struct {
+	Method      string       `json:"method"`
+	Url         string       `json:"url"`
+	HttpVersion string       `json:"httpVersion"`
+	Cookies     []HarCookie  `json:"cookies"`
+	Headers     []HarNameVal `json:"headers"`
+	QueryString []HarNameVal `json:"queryString"`
+	PostData    *HarPostData `json:"postData,omitempty"`
+	HeadersSize int64        `json:"headersSize"`
+	BodySize    int64        `json:"bodySize"`
+}
+type HarResponse struct {
+	Status      int          `json:"status"`
+	StatusText  string       `json:"statusText"`
+	HttpVersion string       `json:"httpVersion"`
+	Cookies     []HarCookie  `json:"cookies"`
+	Headers     []HarNameVal `json:"headers"`
+	Content     HarContent   `json:"content"`
+	RedirectURL string       `json:"redirectURL"`
+	HeadersSize int64        `json:"headersSize"`
+	BodySize    int64        `json:"bodySize"`
+}
+type HarNameVal struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+type HarCookie struct {
+	Name     string     `json:"name"`
+	Value    string     `json:"value"`
+	Path     string     `json:"path,omitempty"`
+	Domain   string     `json:"domain,omitempty"`
+	Expires  *time.Time `json:"expires,omitempty"`
+	HttpOnly bool       `json:"httpOnly,omitempty"`
+	Secure   bool       `json:"secure,omitempty"`
+}
+type HarPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"_encoding,omitempty"` //二进制内容为base64,har 1.2 的postData 没有encoding,使用自定义字段
+}
+type HarContent struct {
+	Size        int64  `json:"size"`
+	Compression int64  `json:"compression,omitempty"`
+	MimeType    string `json:"mimeType"`
+	Text        string `json:"text"`
+	Encoding    string `json:"encoding,omitempty"` //二进制内容为base64
+}
+
+// 各阶段耗时,毫秒,-1 表示没有这个阶段
+type HarTimings struct {
+	Blocked float64 `json:"blocked"`
+	Dns     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	Ssl     float64 `json:"ssl"`
+}
+
+// 从文件中加载har
+func LoadHar(filePath string) (*Har, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	var har Har
+	return &har, json.Unmarshal(data, &har)
+}
+
+// har 记录器,记录每一次请求,包含重定向
+type HarRecorder struct {
+	lock    sync.Mutex
+	entries []HarEntry
+}
+
+func NewHarRecorder() *HarRecorder {
+	return &HarRecorder{}
+}
+
+// 返回记录的har
+func (obj *HarRecorder) Har() *Har {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	return &Har{
+		Log: HarLog{
+			Version: "1.2",
+			Creator: HarCreator{Name: "gospider", Version: "1.0"},
+			Entries: tools.CopySlices(obj.entries),
+		},
+	}
+}
+
+// 保存到文件
+func (obj *HarRecorder) Save(filePath string) error {
+	data, err := json.MarshalIndent(obj.Har(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, data, 0644)
+}
+
+// 清空记录
+func (obj *HarRecorder) Clear() {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	obj.entries = nil
+}
+func (obj *HarRecorder) add(entry HarEntry) {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	obj.entries = append(obj.entries, entry)
+}
+
+func harHeaders(header http.Header) []HarNameVal {
+	results := []HarNameVal{}
+	for name, values := range header {
+		for _, value := range values {
+			results = append(results, HarNameVal{Name: name, Value: value})
+		}
+	}
+	return results
+}
+func harCookies(cookies []*http.Cookie) []HarCookie {
+	results := []HarCookie{}
+	for _, cookie := range cookies {
+		harCookie := HarCookie{
+			Name:     cookie.Name,
+			Value:    cookie.Value,
+			Path:     cookie.Path,
+			Domain:   cookie.Domain,
+			HttpOnly: cookie.HttpOnly,
+			Secure:   cookie.Secure,
+		}
+		if !cookie.Expires.IsZero() {
+			expires := cookie.Expires
+			harCookie.Expires = &expires
+		}
+		results = append(results, harCookie)
+	}
+	return results
+}
+
+// 毫秒
+func harDuration(start, end time.Time) float64 {
+	if start.IsZero() || end.IsZero() {
+		return -1
+	}
+	return float64(end.Sub(start).Microseconds()) / 1000
+}
+
+type harTrace struct {
+	lock         sync.Mutex
+	start        time.Time
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	gotConn      time.Time
+	wroteRequest time.Time
+	firstByte    time.Time
+	remoteAddr   string
+	reused       bool
+	reqTiming    *timing       //请求的耗时,tls 握手在DialTLSContext 中,不会触发httptrace
+	tlsStart     time.Duration //开始时已经累计的tls 耗时,重定向时共用一个timing
+}
+
+func (obj *harTrace) clientTrace() *httptrace.ClientTrace {
+	set := func(t *time.Time) {
+		obj.lock.Lock()
+		defer obj.lock.Unlock()
+		if t.IsZero() {
+			*t = time.Now()
+		}
+	}
+	return &httptrace.ClientTrace{
+		DNSStart:     func(httptrace.DNSStartInfo) { set(&obj.dnsStart) },
+		DNSDone:      func(httptrace.DNSDoneInfo) { set(&obj.dnsDone) },
+		ConnectStart: func(string, string) { set(&obj.connectStart) },
+		ConnectDone:  func(string, string, error) { set(&obj.connectDone) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			set(&obj.gotConn)
+			obj.lock.Lock()
+			defer obj.lock.Unlock()
+			obj.reused = info.Reused
+			if info.Conn != nil && info.Conn.RemoteAddr() != nil {
+				obj.remoteAddr = info.Conn.RemoteAddr().String()
+			}
+		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { set(&obj.wroteRequest) },
+		GotFirstResponseByte: func() { set(&obj.firstByte) },
+	}
+}
+func (obj *harTrace) timings(end time.Time) HarTimings {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	timings := HarTimings{
+		Blocked: -1,
+		Dns:     harDuration(obj.dnsStart, obj.dnsDone),
+		Connect: harDuration(obj.connectStart, obj.connectDone),
+		Send:    harDuration(obj.gotConn, obj.wroteRequest),
+		Wait:    harDuration(obj.wroteRequest, obj.firstByte),
+		Receive: harDuration(obj.firstByte, end),
+		Ssl:     -1,
+	}
+	if obj.reused {
+		timings.Dns, timings.Connect = -1, -1
+	} else if tls := obj.reqTiming.tlsDuration() - obj.tlsStart; tls > 0 {
+		timings.Ssl = float64(tls.Microseconds()) / 1000
+		if timings.Connect >= 0 { //connect 包含ssl
+			timings.Connect += timings.Ssl
+		}
+	}
+	if !obj.dnsStart.IsZero() {
+		timings.Blocked = harDuration(obj.start, obj.dnsStart)
+	} else if !obj.connectStart.IsZero() {
+		timings.Blocked = harDuration(obj.start, obj.connectStart)
+	} else {
+		timings.Blocked = harDuration(obj.start, obj.gotConn)
+	}
+	for _, val := range []*float64{&timings.Send, &timings.Wait, &timings.Receive} {
+		if *val < 0 {
+			*val = 0
+		}
+	}
+	return timings
+}
+
+// 读取请求体,并替换成可以重复读取的body
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	return body, nil
+}
+
+type harTransport struct {
+	recorder  *HarRecorder
+	transport http.RoundTripper
+}
+
+func (obj *HarRecorder) roundTripper(transport http.RoundTripper) http.RoundTripper {
+	return &harTransport{recorder: obj, transport: transport}
+}
+func (obj *harTransport) CloseIdleConnections() {
+	if transport, ok := obj.transport.(interface{ CloseIdleConnections() }); ok {
+		transport.CloseIdleConnections()
+	}
+}
+func (obj *harTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	trace := &harTrace{start: time.Now(), reqTiming: ctxTiming(req.Context())}
+	trace.tlsStart = trace.reqTiming.tlsDuration()
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace.clientTrace()))
+	entry := HarEntry{
+		StartedDateTime: trace.start,
+		Request: HarRequest{
+			Method:      req.Method,
+			Url:         req.URL.String(),
+			HttpVersion: "HTTP/1.1",
+			Cookies:     harCookies(req.Cookies()),
+			Headers:     harHeaders(req.Header),
+			QueryString: []HarNameVal{},
+			HeadersSize: -1,
+			BodySize:    int64(len(reqBody)),
+		},
+	}
+	for name, values := range req.URL.Query() {
+		for _, value := range values {
+			entry.Request.QueryString = append(entry.Request.QueryString, HarNameVal{Name: name, Value: value})
+		}
+	}
+	if reqBody != nil {
+		entry.Request.PostData = &HarPostData{MimeType: req.Header.Get("Content-Type")}
+		if utf8.Valid(reqBody) {
+			entry.Request.PostData.Text = string(reqBody)
+		} else {
+			entry.Request.PostData.Text = base64.StdEncoding.EncodeToString(reqBody)
+			entry.Request.PostData.Encoding = "base64"
+		}
+	}
+	resp, err := obj.transport.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	entry.Request.HttpVersion = resp.Proto
+	entry.Response = HarResponse{
+		Status:      resp.StatusCode,
+		StatusText:  http.StatusText(resp.StatusCode),
+		HttpVersion: resp.Proto,
+		Cookies:     harCookies(resp.Cookies()),
+		Headers:     harHeaders(resp.Header),
+		RedirectURL: resp.Header.Get("Location"),
+		HeadersSize: -1,
+	}
+	if _, statusText, ok := strings.Cut(resp.Status, " "); ok {
+		entry.Response.StatusText = statusText
+	}
+	resp.Body = &harBody{
+		body:     resp.Body,
+		buf:      bytes.NewBuffer(nil),
+		ctx:      req.Context(),
+		entry:    entry,
+		trace:    trace,
+		recorder: obj.recorder,
+		resp:     resp,
+	}
+	return resp, nil
+}
+
+type harBody struct {
+	body     io.ReadCloser
+	buf      *bytes.Buffer
+	ctx      context.Context
+	entry    HarEntry
+	trace    *harTrace
+	recorder *HarRecorder
+	resp     *http.Response
+	once     sync.Once
+}
+
+func (obj *harBody) Read(p []byte) (n int, err error) {
+	n, err = obj.body.Read(p)
+	obj.buf.Write(p[:n])
+	if err == io.EOF {
+		obj.record()
+	}
+	return
+}
+func (obj *harBody) Close() error {
+	err := obj.body.Close()
+	obj.record()
+	return err
+}
+func (obj *harBody) record() {
+	obj.once.Do(func() {
+		end := time.Now()
+		entry := obj.entry
+		entry.Timings = obj.trace.timings(end)
+		entry.Time = harDuration(obj.trace.start, end)
+		if host, _, err := net.SplitHostPort(obj.trace.remoteAddr); err == nil {
+			entry.ServerIPAddress = host
+		}
+		entry.Response.BodySize = int64(obj.buf.Len())
+		content := obj.buf
+		if encoding := obj.resp.Header.Get("Content-Encoding"); encoding != "" && !obj.resp.Uncompressed {
+			if decoded, err := tools.CompressionDecode(obj.ctx, bytes.NewBuffer(obj.buf.Bytes()), encoding); err == nil {
+				content = decoded
+				entry.Response.Content.Compression = int64(content.Len() - obj.buf.Len())
+			}
+		}
+		entry.Response.Content.Size = int64(content.Len())
+		entry.Response.Content.MimeType = obj.resp.Header.Get("Content-Type")
+		if utf8.Valid(content.Bytes()) {
+			entry.Response.Content.Text = content.String()
+		} else {
+			entry.Response.Content.Text = base64.StdEncoding.EncodeToString(content.Bytes())
+			entry.Response.Content.Encoding = "base64"
+		}
+		obj.recorder.add(entry)
+	})
+}
+
+// har 回放,根据method,url,body 从har 中返回响应,不会发送网络请求
+type HarReplay struct {
+	lock    sync.Mutex
+	entries []HarEntry
+	used    map[int]bool
+}
+
+func NewHarReplay(har *Har) *HarReplay {
+	return &HarReplay{entries: har.Log.Entries, used: map[int]bool{}}
+}
+
+// 从har 文件中创建回放
+func NewHarReplayWithFile(filePath string) (*HarReplay, error) {
+	har, err := LoadHar(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return NewHarReplay(har), nil
+}
+
+// 请求体的原始内容,base64 编码的内容解码后返回
+func (obj *HarPostData) body() string {
+	if obj == nil {
+		return ""
+	}
+	if obj.Encoding == "base64" {
+		if body, err := base64.StdEncoding.DecodeString(obj.Text); err == nil {
+			return string(body)
+		}
+	}
+	return obj.Text
+}
+
+// 相同的请求按照记录的顺序返回,全部使用过后返回最后一个
+func (obj *HarReplay) find(method string, href string, body string) (HarEntry, bool) {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	last := -1
+	for i, entry := range obj.entries {
+		if !strings.EqualFold(entry.Request.Method, method) || entry.Request.Url != href || entry.Request.PostData.body() != body {
+			continue
+		}
+		if !obj.used[i] {
+			obj.used[i] = true
+			return entry, true
+		}
+		last = i
+	}
+	if last == -1 {
+		return HarEntry{}, false
+	}
+	return obj.entries[last], true
+}
+func (obj *HarReplay) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	entry, ok := obj.find(req.Method, req.URL.String(), string(reqBody))
+	if !ok {
+		return nil, tools.WrapError(ErrFatal, "har 中没有找到请求: ", req.Method, " ", req.URL.String())
+	}
+	var content []byte
+	if entry.Response.Content.Encoding == "base64" {
+		if content, err = base64.StdEncoding.DecodeString(entry.Response.Content.Text); err != nil {
+			return nil, err
+		}
+	} else {
+		content = []byte(entry.Response.Content.Text)
+	}
+	header := http.Header{}
+	for _, nameVal := range entry.Response.Headers {
+		switch http.CanonicalHeaderKey(nameVal.Name) {
+		case "Content-Encoding", "Content-Length", "Transfer-Encoding": //har 中保存的是解压后的内容
+		default:
+			header.Add(nameVal.Name, nameVal.Value)
+		}
+	}
+	header.Set("Content-Length", strconv.Itoa(len(content)))
+	proto := entry.Response.HttpVersion
+	protoMajor, protoMinor, ok := http.ParseHTTPVersion(proto)
+	if !ok {
+		proto, protoMajor, protoMinor = "HTTP/1.1", 1, 1
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", entry.Response.Status, entry.Response.StatusText),
+		StatusCode:    entry.Response.Status,
+		Proto:         proto,
+		ProtoMajor:    protoMajor,
+		ProtoMinor:    protoMinor,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(content)),
+		ContentLength: int64(len(content)),
+		Request:       req,
+	}, nil
+}
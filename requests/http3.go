@@ -0,0 +1,285 @@
+package requests
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gitee.com/baixudong/gospider/ja3"
+	"gitee.com/baixudong/gospider/tools"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// Alt-Svc 中的http3 服务
+type altSvc struct {
+	addr   string
+	expire time.Time
+}
+
+// http3 传输,根据Alt-Svc 发现http3 服务,http3 请求失败时回退到http1.1,http2
+type h3Transport struct {
+	enable    bool //根据Alt-Svc 使用http3
+	force     bool //https 请求强制使用http3
+	dialCli   *DialClient
+	h3        *http3.RoundTripper
+	transport http.RoundTripper
+	altSvcs   sync.Map //key:请求的host:port,val:*altSvc
+}
+
+func newH3Transport(dialCli *DialClient, transport http.RoundTripper, option ClientOption) (*h3Transport, error) {
+	h3Spec := option.H3Spec
+	if !h3Spec.IsSet() {
+		h3Spec = ja3.DefaultH3Spec()
+	}
+	if val, ok := h3Spec.Settings[1]; ok && val != 0 {
+		return nil, errors.New("http3 不支持qpack 动态表")
+	}
+	obj := &h3Transport{
+		enable:    option.H3,
+		force:     option.ForceH3,
+		dialCli:   dialCli,
+		transport: transport,
+	}
+	if obj.enable || obj.force {
+		if err := obj.proxyErr(&reqCtxData{}); err != nil {
+			return nil, err
+		}
+	}
+	obj.h3 = &http3.RoundTripper{
+		DisableCompression: option.DisCompression,
+		TLSClientConfig:    dialCli.tlsConfig.Clone(),
+		QuicConfig: &quic.Config{
+			InitialConnectionReceiveWindow: h3Spec.InitialMaxData,
+			InitialStreamReceiveWindow:     h3Spec.InitialMaxStreamData,
+			MaxIncomingStreams:             h3Spec.InitialMaxStreamsBidi,
+			MaxIncomingUniStreams:          h3Spec.InitialMaxStreamsUni,
+			MaxIdleTimeout:                 h3Spec.MaxIdleTimeout,
+			HandshakeIdleTimeout:           dialCli.dialer.Timeout,
+		},
+		EnableDatagrams:    h3Spec.Datagram,
+		AdditionalSettings: h3Spec.Settings,
+		Dial:               obj.dial,
+	}
+	return obj, nil
+}
+func (obj *h3Transport) CloseIdleConnections() {
+	obj.h3.CloseIdleConnections()
+	if transport, ok := obj.transport.(interface{ CloseIdleConnections() }); ok {
+		transport.CloseIdleConnections()
+	}
+}
+func (obj *h3Transport) Close() error {
+	return obj.h3.Close()
+}
+
+// 请求的host:port,和http3.RoundTripper 的连接key 一致
+func h3Authority(u *url.URL) string {
+	if u.Port() == "" {
+		return net.JoinHostPort(u.Hostname(), "443")
+	}
+	return u.Host
+}
+func (obj *h3Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctxData := req.Context().Value(keyPrincipalID).(*reqCtxData)
+	force := obj.force || ctxData.forceH3
+	if req.URL.Scheme != "https" || ctxData.ws || !(force || obj.enable || ctxData.h3) {
+		return obj.transport.RoundTrip(req)
+	}
+	if err := obj.proxyErr(ctxData); err != nil {
+		if force {
+			return nil, tools.WrapError(ErrFatal, err.Error())
+		}
+		return obj.transport.RoundTrip(req) //代理不能转发http3,不使用Alt-Svc
+	}
+	authority := h3Authority(req.URL)
+	if force {
+		return obj.h3RoundTrip(req)
+	}
+	if _, ok := obj.altSvcAddr(authority); ok {
+		resp, err := obj.h3RoundTrip(req)
+		if err == nil || req.Context().Err() != nil {
+			return resp, err
+		}
+		obj.altSvcs.Delete(authority) //http3 不可用,回退到http1.1,http2
+		if req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				return nil, err
+			}
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+	resp, err := obj.transport.RoundTrip(req)
+	if err == nil {
+		obj.setAltSvc(authority, req.URL.Hostname(), resp.Header.Get("Alt-Svc"))
+	}
+	return resp, err
+}
+
+// 固定的代理能否转发http3,只支持一个socks5 代理,GetProxy 返回的代理在连接时检查
+func (obj *h3Transport) proxyErr(ctxData *reqCtxData) error {
+	if ctxData.disProxy || ctxData.isCallback {
+		return nil
+	}
+	proxys := obj.dialCli.proxyChain
+	if ctxData.proxyChain != nil {
+		proxys = ctxData.proxyChain
+	}
+	proxy := ctxData.proxy
+	if proxy == nil {
+		proxy = obj.dialCli.Proxy()
+	}
+	if proxy != nil {
+		proxys = append(append([]*url.URL{}, proxys...), proxy)
+	}
+	if len(proxys) > 1 {
+		return errors.New("http3 不支持代理链")
+	}
+	if len(proxys) == 1 && proxys[0].Scheme != "socks5" && proxys[0].Scheme != "socks5h" {
+		return errors.New("http3 只支持socks5 代理,不支持: " + proxys[0].Scheme)
+	}
+	return nil
+}
+func (obj *h3Transport) h3RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := setRequestData(req); err != nil {
+		return nil, err
+	}
+	resp, err := obj.h3.RoundTrip(req)
+	if err != nil {
+		return resp, tools.WrapError(err, "http3 请求错误")
+	}
+	return resp, nil
+}
+
+// 记录响应中的Alt-Svc
+func (obj *h3Transport) setAltSvc(authority string, host string, val string) {
+	if val == "" {
+		return
+	}
+	addr, maxAge, ok := parseAltSvc(val, host)
+	if !ok {
+		return
+	}
+	if addr == "" || maxAge <= 0 {
+		obj.altSvcs.Delete(authority)
+		return
+	}
+	obj.altSvcs.Store(authority, &altSvc{addr: addr, expire: time.Now().Add(maxAge)})
+}
+
+// 请求的host:port 对应的http3 服务地址
+func (obj *h3Transport) altSvcAddr(authority string) (string, bool) {
+	val, ok := obj.altSvcs.Load(authority)
+	if !ok {
+		return "", false
+	}
+	alt := val.(*altSvc)
+	if time.Now().After(alt.expire) {
+		obj.altSvcs.CompareAndDelete(authority, val)
+		return "", false
+	}
+	return alt.addr, true
+}
+
+// 解析Alt-Svc 中的http3 服务,例如：h3=":443"; ma=86400, h3-29=":443"; ma=86400,clear 时addr 为空
+func parseAltSvc(val string, host string) (addr string, maxAge time.Duration, ok bool) {
+	for _, alt := range strings.Split(val, ",") {
+		alt = strings.TrimSpace(alt)
+		if alt == "clear" {
+			return "", 0, true
+		}
+		params := strings.Split(alt, ";")
+		proto, authority, found := strings.Cut(strings.TrimSpace(params[0]), "=")
+		if !found || proto != "h3" {
+			continue
+		}
+		authority, err := strconv.Unquote(authority)
+		if err != nil {
+			continue
+		}
+		altHost, port, err := net.SplitHostPort(authority)
+		if err != nil {
+			continue
+		}
+		if altHost == "" {
+			altHost = host
+		}
+		maxAge = time.Hour * 24
+		for _, param := range params[1:] {
+			if key, val, _ := strings.Cut(strings.TrimSpace(param), "="); key == "ma" {
+				if seconds, err := strconv.ParseInt(val, 10, 64); err == nil {
+					maxAge = time.Duration(seconds) * time.Second
+				}
+			}
+		}
+		return net.JoinHostPort(altHost, port), maxAge, true
+	}
+	return "", 0, false
+}
+
+// 连接Alt-Svc 中的http3 服务,没有时连接请求的地址
+func (obj *h3Transport) dial(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlyConnection, error) {
+	if altAddr, ok := obj.altSvcAddr(addr); ok {
+		addr = altAddr
+	}
+	return obj.dialCli.requestHttp3DialContext(ctx, addr, tlsCfg, cfg)
+}
+
+// 建立quic 连接,支持socks5 代理的UDP ASSOCIATE
+func (obj *DialClient) requestHttp3DialContext(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlyConnection, error) {
+	conn, err := obj.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return nil, tools.WrapError(err, "requestHttp3DialContext DialContext 错误")
+	}
+	startTime := time.Now()
+	quicConn, err := quic.DialEarly(ctx, &h3PacketConn{Conn: conn}, conn.RemoteAddr(), tlsCfg, cfg)
+	if err != nil {
+		conn.Close()
+		return nil, tools.WrapError(err, "requestHttp3DialContext quic 握手错误")
+	}
+	ctxTiming(ctx).addTls(startTime)
+	go func() {
+		<-quicConn.Context().Done()
+		conn.Close()
+	}()
+	return quicConn, nil
+}
+
+// udp 连接转为quic 使用的net.PacketConn,只和连接的地址通信
+type h3PacketConn struct {
+	net.Conn
+}
+
+func (obj *h3PacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, err := obj.Conn.Read(b)
+	return n, obj.Conn.RemoteAddr(), err
+}
+func (obj *h3PacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return obj.Conn.Write(b)
+}
+
+// 设置udp 的缓冲区大小,代理的udp 连接忽略
+func (obj *h3PacketConn) SetReadBuffer(bytes int) error {
+	if conn, ok := obj.Conn.(*net.UDPConn); ok {
+		return conn.SetReadBuffer(bytes)
+	}
+	return nil
+}
+func (obj *h3PacketConn) SetWriteBuffer(bytes int) error {
+	if conn, ok := obj.Conn.(*net.UDPConn); ok {
+		return conn.SetWriteBuffer(bytes)
+	}
+	return nil
+}
@@ -0,0 +1,489 @@
+package requests
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// jar 中保存的cookie,包含导出需要的所有属性
+type JarCookie struct {
+	Name     string    `json:"name"`
+	Value    string    `json:"value"`
+	Domain   string    `json:"domain"` //不带前缀的点
+	Path     string    `json:"path"`
+	Expires  time.Time `json:"expires"` //为零时是会话cookie
+	Secure   bool      `json:"secure"`
+	HttpOnly bool      `json:"httpOnly"`
+	SameSite string    `json:"sameSite"` //Lax,Strict,None 或空
+	HostOnly bool      `json:"hostOnly"` //只发送给Domain,不发送给子域名
+	Creation time.Time `json:"creation"`
+}
+
+// 是否是会话cookie
+func (obj JarCookie) Session() bool {
+	return obj.Expires.IsZero()
+}
+func (obj JarCookie) expired(now time.Time) bool {
+	return !obj.Expires.IsZero() && !now.Before(obj.Expires)
+}
+func (obj JarCookie) id() string {
+	return obj.Domain + ";" + obj.Path + ";" + obj.Name
+}
+
+// 转换成http.Cookie
+func (obj JarCookie) Cookie() *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     obj.Name,
+		Value:    obj.Value,
+		Path:     obj.Path,
+		Expires:  obj.Expires,
+		Secure:   obj.Secure,
+		HttpOnly: obj.HttpOnly,
+		SameSite: sameSiteFromString(obj.SameSite),
+	}
+	if !obj.HostOnly {
+		cookie.Domain = obj.Domain
+	}
+	return cookie
+}
+func (obj JarCookie) domainMatch(host string) bool {
+	if obj.Domain == host {
+		return true
+	}
+	return !obj.HostOnly && strings.HasSuffix(host, "."+obj.Domain)
+}
+func (obj JarCookie) pathMatch(path string) bool {
+	if path == obj.Path {
+		return true
+	}
+	if strings.HasPrefix(path, obj.Path) {
+		return obj.Path[len(obj.Path)-1] == '/' || path[len(obj.Path)] == '/'
+	}
+	return false
+}
+
+func sameSiteToString(sameSite http.SameSite) string {
+	switch sameSite {
+	case http.SameSiteLaxMode:
+		return "Lax"
+	case http.SameSiteStrictMode:
+		return "Strict"
+	case http.SameSiteNoneMode:
+		return "None"
+	default:
+		return ""
+	}
+}
+func sameSiteFromString(sameSite string) http.SameSite {
+	switch strings.ToLower(sameSite) {
+	case "lax":
+		return http.SameSiteLaxMode
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteDefaultMode
+	}
+}
+
+// 可以持久化的cookies 管理,实现了http.CookieJar,支持json 和Netscape cookies.txt 格式
+type Jar struct {
+	jar *cookieJar
+}
+
+// 实现http.CookieJar,规则参考rfc 6265
+type cookieJar struct {
+	lock    sync.Mutex
+	entries map[string]JarCookie
+}
+
+func newJar() *cookieJar {
+	return &cookieJar{entries: map[string]JarCookie{}}
+}
+func NewJar() *Jar {
+	return &Jar{
+		jar: newJar(),
+	}
+}
+
+// 从json 文件中加载cookies
+func NewJarWithFile(filePath string) (*Jar, error) {
+	jar := NewJar()
+	return jar, jar.Load(filePath)
+}
+
+// 返回url 的cookies,也可以设置url 的cookies
+func (obj *Jar) Cookies(href string, cookies ...any) (Cookies, error) {
+	return cookie(obj.jar, href, cookies...)
+}
+
+// 清除cookies
+func (obj *Jar) ClearCookies() {
+	obj.jar.clear()
+}
+
+// 返回所有没有过期的cookies,按照域名,路径,名称排序
+func (obj *Jar) All() []JarCookie {
+	return obj.jar.all()
+}
+
+// 添加cookies,Domain 相同,Path 相同,Name 相同的会覆盖,已过期的会删除
+func (obj *Jar) Add(cookies ...JarCookie) {
+	obj.jar.add(cookies...)
+}
+
+// 导出json
+func (obj *Jar) MarshalJSON() ([]byte, error) {
+	return json.Marshal(obj.All())
+}
+
+// 导入json,不会清除已有的cookies
+func (obj *Jar) UnmarshalJSON(data []byte) error {
+	var cookies []JarCookie
+	if err := json.Unmarshal(data, &cookies); err != nil {
+		return err
+	}
+	if obj.jar == nil {
+		obj.jar = newJar()
+	}
+	obj.Add(cookies...)
+	return nil
+}
+
+// 保存为json 文件
+func (obj *Jar) Save(filePath string) error {
+	data, err := json.MarshalIndent(obj.All(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, data, 0600)
+}
+
+// 从json 文件中加载
+func (obj *Jar) Load(filePath string) error {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	return obj.UnmarshalJSON(data)
+}
+
+// 导出Netscape cookies.txt 格式,curl 和浏览器插件使用的格式
+func (obj *Jar) Netscape() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("# Netscape HTTP Cookie File\n\n")
+	for _, cookie := range obj.All() {
+		domain := cookie.Domain
+		if !cookie.HostOnly {
+			domain = "." + domain
+		}
+		if cookie.HttpOnly {
+			domain = "#HttpOnly_" + domain
+		}
+		var expires int64
+		if !cookie.Session() {
+			expires = cookie.Expires.Unix()
+		}
+		fmt.Fprintf(&buf, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			domain,
+			netscapeBool(!cookie.HostOnly),
+			cookie.Path,
+			netscapeBool(cookie.Secure),
+			expires,
+			cookie.Name,
+			cookie.Value,
+		)
+	}
+	return buf.Bytes()
+}
+
+// 导入Netscape cookies.txt 格式,不会清除已有的cookies
+func (obj *Jar) SetNetscape(data []byte) error {
+	cookies := []JarCookie{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for num := 1; scanner.Scan(); num++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		var httpOnly bool
+		if strings.HasPrefix(line, "#HttpOnly_") {
+			line = line[len("#HttpOnly_"):]
+			httpOnly = true
+		} else if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) == 6 { //没有值
+			fields = append(fields, "")
+		}
+		if len(fields) != 7 {
+			return fmt.Errorf("cookies.txt 第%d行格式错误", num)
+		}
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return fmt.Errorf("cookies.txt 第%d行过期时间错误: %w", num, err)
+		}
+		cookie := JarCookie{
+			Domain:   strings.ToLower(strings.TrimPrefix(fields[0], ".")),
+			HostOnly: !strings.EqualFold(fields[1], "TRUE"),
+			Path:     fields[2],
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			Name:     fields[5],
+			Value:    fields[6],
+			HttpOnly: httpOnly,
+		}
+		if expires > 0 {
+			cookie.Expires = time.Unix(expires, 0)
+		}
+		cookies = append(cookies, cookie)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	obj.Add(cookies...)
+	return nil
+}
+
+// 保存为Netscape cookies.txt 文件
+func (obj *Jar) SaveNetscape(filePath string) error {
+	return os.WriteFile(filePath, obj.Netscape(), 0600)
+}
+
+// 从Netscape cookies.txt 文件中加载
+func (obj *Jar) LoadNetscape(filePath string) error {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	return obj.SetNetscape(data)
+}
+func netscapeBool(val bool) string {
+	if val {
+		return "TRUE"
+	}
+	return "FALSE"
+}
+
+func (obj *cookieJar) clear() {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	obj.entries = map[string]JarCookie{}
+}
+func (obj *cookieJar) all() []JarCookie {
+	now := time.Now()
+	obj.lock.Lock()
+	results := make([]JarCookie, 0, len(obj.entries))
+	for id, entry := range obj.entries {
+		if entry.expired(now) {
+			delete(obj.entries, id)
+			continue
+		}
+		results = append(results, entry)
+	}
+	obj.lock.Unlock()
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Domain != results[j].Domain {
+			return results[i].Domain < results[j].Domain
+		}
+		if results[i].Path != results[j].Path {
+			return results[i].Path < results[j].Path
+		}
+		return results[i].Name < results[j].Name
+	})
+	return results
+}
+func (obj *cookieJar) add(cookies ...JarCookie) {
+	now := time.Now()
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	for _, cookie := range cookies {
+		cookie.Domain = strings.ToLower(strings.TrimPrefix(cookie.Domain, "."))
+		if cookie.Path == "" || cookie.Path[0] != '/' {
+			cookie.Path = "/"
+		}
+		if cookie.Creation.IsZero() {
+			cookie.Creation = now
+		}
+		id := cookie.id()
+		if cookie.expired(now) {
+			delete(obj.entries, id)
+			continue
+		}
+		obj.entries[id] = cookie
+	}
+}
+
+// 实现http.CookieJar
+func (obj *cookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	if u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "ws" && u.Scheme != "wss" {
+		return
+	}
+	host, err := canonicalHost(u.Host)
+	if err != nil {
+		return
+	}
+	defPath := defaultPath(u.Path)
+	now := time.Now()
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	for _, cookie := range cookies {
+		entry, remove, err := newJarCookie(cookie, now, host, defPath)
+		if err != nil {
+			continue
+		}
+		id := entry.id()
+		if remove {
+			delete(obj.entries, id)
+			continue
+		}
+		if old, ok := obj.entries[id]; ok {
+			entry.Creation = old.Creation
+		}
+		obj.entries[id] = entry
+	}
+}
+
+// 实现http.CookieJar
+func (obj *cookieJar) Cookies(u *url.URL) []*http.Cookie {
+	if u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "ws" && u.Scheme != "wss" {
+		return nil
+	}
+	host, err := canonicalHost(u.Host)
+	if err != nil {
+		return nil
+	}
+	secure := u.Scheme == "https" || u.Scheme == "wss"
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+	now := time.Now()
+	obj.lock.Lock()
+	var selected []JarCookie
+	for id, entry := range obj.entries {
+		if entry.expired(now) {
+			delete(obj.entries, id)
+			continue
+		}
+		if (!entry.Secure || secure) && entry.domainMatch(host) && entry.pathMatch(path) {
+			selected = append(selected, entry)
+		}
+	}
+	obj.lock.Unlock()
+	//路径长的在前面,路径相同时创建时间早的在前面
+	sort.Slice(selected, func(i, j int) bool {
+		if len(selected[i].Path) != len(selected[j].Path) {
+			return len(selected[i].Path) > len(selected[j].Path)
+		}
+		return selected[i].Creation.Before(selected[j].Creation)
+	})
+	results := make([]*http.Cookie, len(selected))
+	for i, entry := range selected {
+		results[i] = &http.Cookie{Name: entry.Name, Value: entry.Value}
+	}
+	return results
+}
+
+var errIllegalDomain = errors.New("cookie domain 错误")
+
+// 返回小写的host,不包含端口
+func canonicalHost(host string) (string, error) {
+	if hasPort(host) {
+		var err error
+		if host, _, err = net.SplitHostPort(host); err != nil {
+			return "", err
+		}
+	}
+	return strings.ToLower(strings.TrimSuffix(host, ".")), nil
+}
+func hasPort(host string) bool {
+	colons := strings.Count(host, ":")
+	if colons == 0 {
+		return false
+	}
+	if colons == 1 {
+		return true
+	}
+	return host[0] == '[' && strings.Contains(host, "]:")
+}
+func defaultPath(path string) string {
+	if len(path) == 0 || path[0] != '/' {
+		return "/"
+	}
+	i := strings.LastIndex(path, "/")
+	if i == 0 {
+		return "/"
+	}
+	return path[:i]
+}
+
+// 根据Set-Cookie 创建jar 中的cookie,remove 为true 时删除这个cookie
+func newJarCookie(cookie *http.Cookie, now time.Time, host string, defPath string) (entry JarCookie, remove bool, err error) {
+	entry.Name = cookie.Name
+	entry.Value = cookie.Value
+	entry.Secure = cookie.Secure
+	entry.HttpOnly = cookie.HttpOnly
+	entry.SameSite = sameSiteToString(cookie.SameSite)
+	entry.Creation = now
+	if cookie.Path == "" || cookie.Path[0] != '/' {
+		entry.Path = defPath
+	} else {
+		entry.Path = cookie.Path
+	}
+	if entry.Domain, entry.HostOnly, err = domainAndType(host, cookie.Domain); err != nil {
+		return
+	}
+	if cookie.MaxAge < 0 {
+		return entry, true, nil
+	} else if cookie.MaxAge > 0 {
+		entry.Expires = now.Add(time.Duration(cookie.MaxAge) * time.Second)
+	} else if !cookie.Expires.IsZero() {
+		if !cookie.Expires.After(now) {
+			return entry, true, nil
+		}
+		entry.Expires = cookie.Expires
+	}
+	return entry, false, nil
+}
+
+// 返回cookie 的域名,以及是否只发送给这个域名
+func domainAndType(host string, domain string) (string, bool, error) {
+	if domain == "" {
+		return host, true, nil
+	}
+	if net.ParseIP(host) != nil { //ip 只能设置host-only cookie
+		if host != domain {
+			return "", false, errIllegalDomain
+		}
+		return host, true, nil
+	}
+	domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+	if domain == "" || strings.HasSuffix(domain, ".") {
+		return "", false, errIllegalDomain
+	}
+	//公共后缀不能设置cookie,例如：com,co.uk
+	if suffix, _ := publicsuffix.PublicSuffix(domain); suffix == domain {
+		if host != domain {
+			return "", false, errIllegalDomain
+		}
+		return host, true, nil
+	}
+	if host != domain && !strings.HasSuffix(host, "."+domain) {
+		return "", false, errIllegalDomain
+	}
+	return domain, false, nil
+}
@@ -0,0 +1,53 @@
+package requests
+
+import (
+	"io"
+	"os"
+	"sync"
+
+	"gitee.com/baixudong/gospider/tools"
+)
+
+// tls 密钥日志,NSS key log 格式,用于wireshark 解密,多个连接同时握手时按行加锁写入
+type keyLogWriter struct {
+	writer io.Writer
+	lock   sync.Mutex
+}
+
+func (obj *keyLogWriter) Write(b []byte) (int, error) {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	return obj.writer.Write(b)
+}
+
+var keyLogFiles sync.Map //SSLKEYLOGFILE 打开的文件,所有客户端共用,key:文件路径,val:*keyLogWriter
+var keyLogLock sync.Mutex
+
+// 获取密钥日志,没有设置时使用环境变量SSLKEYLOGFILE 指定的文件
+func newKeyLogWriter(writer io.Writer) (io.Writer, error) {
+	if writer != nil {
+		if _, ok := writer.(*keyLogWriter); ok {
+			return writer, nil
+		}
+		return &keyLogWriter{writer: writer}, nil
+	}
+	filePath := os.Getenv("SSLKEYLOGFILE")
+	if filePath == "" {
+		return nil, nil
+	}
+	if val, ok := keyLogFiles.Load(filePath); ok {
+		return val.(*keyLogWriter), nil
+	}
+	keyLogLock.Lock()
+	defer keyLogLock.Unlock()
+	if val, ok := keyLogFiles.Load(filePath); ok {
+		return val.(*keyLogWriter), nil
+	}
+	file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, tools.WrapError(err, "SSLKEYLOGFILE 打开错误")
+	}
+	keyLog := &keyLogWriter{writer: file}
+	keyLogFiles.Store(filePath, keyLog)
+	return keyLog, nil
+}
@@ -0,0 +1,317 @@
+package requests
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// 限速规则
+type LimitRule struct {
+	Rps         float64       //每秒请求数,0:不限制
+	Concurrency int           //最大并发数,0:不限制
+	MinDelay    time.Duration //两个请求之间的最小间隔
+	RandomDelay time.Duration //在间隔上增加的随机时间,范围：[0,RandomDelay)
+}
+
+// 限速器选项
+type LimiterOption struct {
+	LimitRule      //默认规则,每个host 单独计算
+	Domain    bool //按照注册域名限速,例如：a.baidu.com 和b.baidu.com 共用一个限速
+	//没有请求的host 空闲超过这个时间后删除,下次请求时重新创建,默认：1分钟
+	IdleTime time.Duration
+	//返回robots.txt 中的Crawl-delay,比MinDelay 大时使用Crawl-delay 作为最小间隔
+	CrawlDelay func(ctx context.Context, href *url.URL) (time.Duration, error)
+}
+
+// 按照host 或注册域名限速,礼貌的爬取网站
+type Limiter struct {
+	lock       sync.Mutex
+	rule       LimitRule
+	rules      map[string]LimitRule
+	hosts      map[string]*hostLimit
+	domain     bool
+	crawlDelay func(ctx context.Context, href *url.URL) (time.Duration, error)
+	idleTime   time.Duration
+	cleanTime  time.Time //下次清理空闲host 的时间
+}
+
+type hostLimit struct {
+	lock    sync.Mutex
+	rule    LimitRule
+	next    time.Time //下一个请求可以发送的时间
+	active  int
+	waiters []chan struct{}
+	refs    int //正在使用的请求数,由Limiter.lock 保护
+}
+
+func NewLimiter(options ...LimiterOption) *Limiter {
+	var option LimiterOption
+	if len(options) > 0 {
+		option = options[0]
+	}
+	if option.IdleTime <= 0 {
+		option.IdleTime = time.Minute
+	}
+	return &Limiter{
+		rule:       option.LimitRule,
+		rules:      map[string]LimitRule{},
+		hosts:      map[string]*hostLimit{},
+		domain:     option.Domain,
+		crawlDelay: option.CrawlDelay,
+		idleTime:   option.IdleTime,
+	}
+}
+
+// 返回限速使用的key,host 或注册域名
+func (obj *Limiter) Key(href *url.URL) string {
+	host := href.Hostname()
+	if obj.domain {
+		if domain, err := publicsuffix.EffectiveTLDPlusOne(host); err == nil {
+			return domain
+		}
+	}
+	return host
+}
+
+// 设置单个host 的规则,可以在运行时修改,开启Domain 时key 为注册域名
+func (obj *Limiter) SetRule(key string, rule LimitRule) {
+	obj.lock.Lock()
+	obj.rules[key] = rule
+	limit, ok := obj.hosts[key]
+	obj.lock.Unlock()
+	if ok {
+		limit.setRule(rule)
+	}
+}
+
+// 删除单个host 的规则,恢复使用默认规则
+func (obj *Limiter) DelRule(key string) {
+	obj.lock.Lock()
+	delete(obj.rules, key)
+	rule := obj.rule
+	limit, ok := obj.hosts[key]
+	obj.lock.Unlock()
+	if ok {
+		limit.setRule(rule)
+	}
+}
+
+// 返回单个host 的规则
+func (obj *Limiter) Rule(key string) LimitRule {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	if rule, ok := obj.rules[key]; ok {
+		return rule
+	}
+	return obj.rule
+}
+
+// 返回正在限速的host 或注册域名,空闲的已经删除
+func (obj *Limiter) Keys() []string {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	obj.cleanLocked()
+	keys := make([]string, 0, len(obj.hosts))
+	for key := range obj.hosts {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// 删除空闲的host,没有正在使用的请求并且最后预约的发送时间已经超过idleTime
+func (obj *Limiter) cleanLocked() {
+	now := time.Now()
+	if now.Before(obj.cleanTime) {
+		return
+	}
+	obj.cleanTime = now.Add(obj.idleTime)
+	for key, limit := range obj.hosts {
+		if limit.refs == 0 && now.Sub(limit.nextTime()) > obj.idleTime {
+			delete(obj.hosts, key)
+		}
+	}
+}
+func (obj *Limiter) hostLimit(key string) *hostLimit {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	obj.cleanLocked()
+	limit, ok := obj.hosts[key]
+	if !ok {
+		rule, ok := obj.rules[key]
+		if !ok {
+			rule = obj.rule
+		}
+		limit = &hostLimit{rule: rule}
+		obj.hosts[key] = limit
+	}
+	limit.refs++
+	return limit
+}
+
+// 请求结束,减少引用
+func (obj *Limiter) putHostLimit(limit *hostLimit) {
+	obj.lock.Lock()
+	limit.refs--
+	obj.lock.Unlock()
+}
+
+// 等待可以发送请求,返回释放并发的函数
+func (obj *Limiter) Wait(ctx context.Context, href *url.URL) (func(), error) {
+	limit := obj.hostLimit(obj.Key(href))
+	var crawlDelay time.Duration
+	if obj.crawlDelay != nil && href.Path != "/robots.txt" {
+		crawlDelay, _ = obj.crawlDelay(ctx, href)
+	}
+	if err := limit.acquire(ctx); err != nil {
+		obj.putHostLimit(limit)
+		return nil, err
+	}
+	if err := limit.wait(ctx, crawlDelay); err != nil {
+		limit.release()
+		obj.putHostLimit(limit)
+		return nil, err
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			limit.release()
+			obj.putHostLimit(limit)
+		})
+	}, nil
+}
+
+func (obj *hostLimit) nextTime() time.Time {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	return obj.next
+}
+
+func (obj *hostLimit) setRule(rule LimitRule) {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	obj.rule = rule
+	for len(obj.waiters) > 0 && (obj.rule.Concurrency <= 0 || obj.active < obj.rule.Concurrency) {
+		obj.active++
+		close(obj.waiters[0])
+		obj.waiters = obj.waiters[1:]
+	}
+}
+func (obj *hostLimit) acquire(ctx context.Context) error {
+	obj.lock.Lock()
+	if obj.rule.Concurrency <= 0 || obj.active < obj.rule.Concurrency {
+		obj.active++
+		obj.lock.Unlock()
+		return nil
+	}
+	waiter := make(chan struct{})
+	obj.waiters = append(obj.waiters, waiter)
+	obj.lock.Unlock()
+	select {
+	case <-waiter:
+		return nil
+	case <-ctx.Done():
+		obj.lock.Lock()
+		defer obj.lock.Unlock()
+		for i, val := range obj.waiters {
+			if val == waiter {
+				obj.waiters = append(obj.waiters[:i], obj.waiters[i+1:]...)
+				return ctx.Err()
+			}
+		}
+		//已经获取到了,释放掉
+		obj.releaseLocked()
+		return ctx.Err()
+	}
+}
+func (obj *hostLimit) release() {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	obj.releaseLocked()
+}
+func (obj *hostLimit) releaseLocked() {
+	if len(obj.waiters) > 0 && (obj.rule.Concurrency <= 0 || obj.active <= obj.rule.Concurrency) {
+		close(obj.waiters[0])
+		obj.waiters = obj.waiters[1:]
+		return
+	}
+	obj.active--
+}
+
+// 按照间隔预约发送时间,并等待到预约的时间
+func (obj *hostLimit) wait(ctx context.Context, crawlDelay time.Duration) error {
+	obj.lock.Lock()
+	interval := obj.rule.MinDelay
+	if obj.rule.Rps > 0 {
+		if rpsDelay := time.Duration(float64(time.Second) / obj.rule.Rps); rpsDelay > interval {
+			interval = rpsDelay
+		}
+	}
+	if crawlDelay > interval {
+		interval = crawlDelay
+	}
+	if obj.rule.RandomDelay > 0 {
+		interval += time.Duration(rand.Int63n(int64(obj.rule.RandomDelay)))
+	}
+	now := time.Now()
+	start := obj.next
+	if start.Before(now) {
+		start = now
+	}
+	obj.next = start.Add(interval)
+	obj.lock.Unlock()
+	if delay := time.Until(start); delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return nil
+}
+
+type limitTransport struct {
+	limiter   *Limiter
+	transport http.RoundTripper
+}
+
+func (obj *Limiter) roundTripper(transport http.RoundTripper) http.RoundTripper {
+	return &limitTransport{limiter: obj, transport: transport}
+}
+func (obj *limitTransport) CloseIdleConnections() {
+	if transport, ok := obj.transport.(interface{ CloseIdleConnections() }); ok {
+		transport.CloseIdleConnections()
+	}
+}
+func (obj *limitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	release, err := obj.limiter.Wait(req.Context(), req.URL)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := obj.transport.RoundTrip(req)
+	if err != nil || resp.StatusCode == http.StatusSwitchingProtocols || resp.Body == nil {
+		release()
+		return resp, err
+	}
+	resp.Body = &limitBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// body 关闭后释放并发
+type limitBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (obj *limitBody) Close() error {
+	defer obj.release()
+	return obj.ReadCloser.Close()
+}
@@ -0,0 +1,169 @@
+package requests
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"gitee.com/baixudong/gospider/tools"
+)
+
+// 出口ip 的选择方式
+type LocalAddrMode int
+
+const (
+	LocalAddrRandom     LocalAddrMode = 0 //每个连接随机选择
+	LocalAddrRoundRobin LocalAddrMode = 1 //每个连接按顺序轮换
+	LocalAddrSticky     LocalAddrMode = 2 //同一个host 使用同一个出口ip
+)
+
+// 出口ip 或网段
+type localNet struct {
+	ip   net.IP
+	mask net.IPMask
+}
+
+// 网段中的第n 个ip,跳过网络地址和ipv4 的广播地址,主机位超过64 位时只使用低64 位
+func (obj localNet) ipAt(n uint64) net.IP {
+	ip := make(net.IP, len(obj.ip))
+	copy(ip, obj.ip)
+	ones, bits := obj.mask.Size()
+	hostBits := bits - ones
+	if hostBits == 0 {
+		return ip
+	}
+	var offset uint64
+	if hostBits >= 64 {
+		if offset = n; offset == 0 {
+			offset = 1
+		}
+	} else if size := uint64(1) << hostBits; bits == 32 && size > 2 {
+		offset = 1 + n%(size-2)
+	} else {
+		offset = 1 + n%(size-1)
+	}
+	for i := len(ip) - 1; i >= 0 && offset > 0; i-- {
+		ip[i] |= byte(offset)
+		offset >>= 8
+	}
+	return ip
+}
+
+// 出口ip 池,ipv4 和ipv6 分开选择
+type localAddrPool struct {
+	mode   LocalAddrMode
+	nets   map[int][]localNet
+	num    map[int]*atomic.Uint64
+	sticky sync.Map //key:ip类型+host,val:net.IP
+}
+
+// 解析出口ip,支持单个ip 和网段,例如：192.168.1.2,2001:db8::/64
+func newLocalAddrPool(addrs []string, mode LocalAddrMode) (*localAddrPool, error) {
+	pool := &localAddrPool{
+		mode: mode,
+		nets: map[int][]localNet{},
+		num:  map[int]*atomic.Uint64{4: {}, 6: {}},
+	}
+	for _, addr := range addrs {
+		var ipNet localNet
+		if strings.Contains(addr, "/") {
+			_, cidr, err := net.ParseCIDR(addr)
+			if err != nil {
+				return nil, err
+			}
+			ipNet = localNet{ip: cidr.IP, mask: cidr.Mask}
+		} else if ip := net.ParseIP(addr); ip != nil {
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+			}
+			ipNet = localNet{ip: ip, mask: net.CIDRMask(len(ip)*8, len(ip)*8)}
+		} else {
+			return nil, errors.New("出口ip 格式错误: " + addr)
+		}
+		ipType := tools.ParseIp(ipNet.ip)
+		pool.nets[ipType] = append(pool.nets[ipType], ipNet)
+	}
+	return pool, nil
+}
+
+// 只有一种ip 类型时优先解析这个类型的地址
+func (obj *localAddrPool) addrType() int {
+	if len(obj.nets[4]) == 0 && len(obj.nets[6]) > 0 {
+		return 6
+	}
+	if len(obj.nets[6]) == 0 && len(obj.nets[4]) > 0 {
+		return 4
+	}
+	return 0
+}
+
+// 选择ipType 类型的出口ip,没有这个类型的出口ip 返回nil
+func (obj *localAddrPool) get(ipType int, host string) net.IP {
+	nets := obj.nets[ipType]
+	if len(nets) == 0 {
+		return nil
+	}
+	switch obj.mode {
+	case LocalAddrRoundRobin:
+		num := obj.num[ipType].Add(1) - 1
+		return nets[num%uint64(len(nets))].ipAt(num / uint64(len(nets)))
+	case LocalAddrSticky:
+		key := strconv.Itoa(ipType) + host
+		if val, ok := obj.sticky.Load(key); ok {
+			return val.(net.IP)
+		}
+		val, _ := obj.sticky.LoadOrStore(key, nets[rand.Intn(len(nets))].ipAt(rand.Uint64()))
+		return val.(net.IP)
+	default:
+		return nets[rand.Intn(len(nets))].ipAt(rand.Uint64())
+	}
+}
+
+// 连接addr 使用的dialer,根据addr 的ip 类型选择出口ip
+func (obj *DialClient) netDialer(ctx context.Context, network string, addr string) *net.Dialer {
+	if obj.localAddrs == nil && obj.dialer.LocalAddr == nil {
+		return obj.dialer
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return obj.dialer
+	}
+	ip, ipType := tools.ParseHost(host)
+	if ipType != 4 && ipType != 6 {
+		return obj.dialer
+	}
+	var localIp net.IP
+	if obj.localAddrs != nil {
+		stickyHost := host
+		if reqData, ok := ctx.Value(keyPrincipalID).(*reqCtxData); ok && reqData != nil && reqData.url != nil {
+			stickyHost = reqData.url.Hostname()
+		}
+		localIp = obj.localAddrs.get(ipType, stickyHost)
+	}
+	if localIp == nil {
+		switch localAddr := obj.dialer.LocalAddr.(type) {
+		case *net.TCPAddr:
+			localIp = localAddr.IP
+		case *net.UDPAddr:
+			localIp = localAddr.IP
+		}
+		if localIp == nil || tools.ParseIp(localIp) != tools.ParseIp(ip) { //出口ip 类型不一致时使用默认的出口
+			localIp = nil
+		}
+	}
+	dialer := *obj.dialer
+	dialer.LocalAddr = nil
+	if localIp != nil {
+		if strings.HasPrefix(network, "udp") {
+			dialer.LocalAddr = &net.UDPAddr{IP: localIp}
+		} else {
+			dialer.LocalAddr = &net.TCPAddr{IP: localIp}
+		}
+	}
+	return &dialer
+}
@@ -16,29 +16,37 @@ import (
 
 // 请求参数选项
 type RequestOption struct {
-	Method      string        //method
-	Url         *url.URL      //请求的url
-	Host        string        //网站的host
-	Proxy       string        //代理,支持http,https,socks5协议代理,例如：http://127.0.0.1:7005
-	Timeout     time.Duration //请求超时时间
-	Headers     any           //请求头,支持：json,map，header
-	Cookies     any           // cookies,支持json,map,str，http.Header
-	Files       []File        //发送multipart/form-data,文件上传
-	Params      any           //url 中的参数，用以拼接url,支持json,map
-	Form        any           //发送multipart/form-data,适用于文件上传,支持json,map
-	Data        any           //发送application/x-www-form-urlencoded,适用于key,val,支持string,[]bytes,json,map
-	body        io.Reader
-	Body        io.Reader
-	Json        any            //发送application/json,支持：string,[]bytes,json,map
-	Text        any            //发送text/xml,支持string,[]bytes,json,map
-	ContentType string         //headers 中Content-Type 的值
-	Raw         any            //不设置context-type,支持string,[]bytes,json,map
-	TempData    map[string]any //临时变量，用于回调存储或自由度更高的用法
-	DisCookie   bool           //关闭cookies管理,这个请求不用cookies池
-	DisDecode   bool           //关闭自动解码
-	Bar         bool           //是否开启bar
-	DisProxy    bool           //是否关闭代理,强制关闭代理
-	TryNum      int64          //重试次数
+	Method       string        //method
+	Url          *url.URL      //请求的url
+	Host         string        //网站的host
+	Proxy        string        //代理,支持http,https,socks5,socks5h,socks4,socks4a,h2(http2 CONNECT)协议代理,例如：http://127.0.0.1:7005,socks5 和socks5h 的tcp 连接都由代理解析域名,udp 时socks5 在本地解析,本地的dns 查询需要DnsProxy 才经过代理
+	ProxyChain   []string      //代理链,依次连接后再连接Proxy,覆盖ClientOption.ProxyChain
+	Timeout      time.Duration //请求超时时间
+	Headers      any           //请求头,支持：json,map，header
+	OrderHeaders []string      //请求头顺序和大小写,例如：[]string{"Host","User-Agent","accept"},http2 需要客户端开启h2指纹才生效
+	Cookies      any           // cookies,支持json,map,str，http.Header
+	Files        []File        //发送multipart/form-data,文件上传
+	Params       any           //url 中的参数，用以拼接url,支持json,map
+	Form         any           //发送multipart/form-data,适用于文件上传,支持json,map
+	Data         any           //发送application/x-www-form-urlencoded,适用于key,val,支持string,[]bytes,json,map
+	body         io.Reader
+	Body         io.Reader
+	Json         any            //发送application/json,支持：string,[]bytes,json,map
+	Text         any            //发送text/xml,支持string,[]bytes,json,map
+	ContentType  string         //headers 中Content-Type 的值
+	Raw          any            //不设置context-type,支持string,[]bytes,json,map
+	TempData     map[string]any //临时变量，用于回调存储或自由度更高的用法
+	DisCookie    bool           //关闭cookies管理,这个请求不用cookies池
+	DisDecode    bool           //关闭自动解码
+	Bar          bool           //是否开启bar
+	DisProxy     bool           //是否关闭代理,强制关闭代理
+	DisCache     bool           //这个请求不使用缓存
+	H3           bool           //这个请求开启http3,根据Alt-Svc 发现http3 服务
+	ForceH3      bool           //这个请求强制使用http3
+	H2c          bool           //这个请求使用h2c(http2 prior knowledge)
+	H2cUpgrade   bool           //这个请求通过Upgrade: h2c 升级到http2
+	TryNum       int64          //重试次数
+	RetryPolicy  *RetryPolicy   //重试策略,设置后重试前会等待
 
 	OptionCallBack func(context.Context, *RequestOption) error //请求参数回调,用于对请求参数进行修改。返回error,中断重试请求,返回nil继续
 	ResultCallBack func(context.Context, *Response) error      //结果回调,用于对结果进行校验。返回nil，直接返回,返回err的话，如果有errCallBack 走errCallBack，没有继续try
@@ -183,6 +191,9 @@ func (obj *Client) newRequestOption(option RequestOption) RequestOption {
 	if option.TryNum == 0 {
 		option.TryNum = obj.tryNum
 	}
+	if option.RetryPolicy == nil {
+		option.RetryPolicy = obj.retryPolicy
+	}
 	if option.OptionCallBack == nil {
 		option.OptionCallBack = obj.optionCallBack
 	}
@@ -199,6 +210,9 @@ func (obj *Client) newRequestOption(option RequestOption) RequestOption {
 			option.Headers = obj.headers
 		}
 	}
+	if option.OrderHeaders == nil {
+		option.OrderHeaders = obj.orderHeaders
+	}
 	if !option.Bar {
 		option.Bar = obj.bar
 	}
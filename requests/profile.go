@@ -0,0 +1,303 @@
+package requests
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"gitee.com/baixudong/gospider/ja3"
+	"gitee.com/baixudong/gospider/tools"
+)
+
+// 浏览器指纹配置,将tls,h2,请求头绑定在一起,防止出现不同浏览器的指纹混用
+type Profile struct {
+	Name         string            `json:"name"`         //名称,例如：chrome_114
+	Ja3          string            `json:"ja3"`          //ja3 字符串,Ja3Spec 没有设置时使用这个字符串生成
+	Ja3Spec      ja3.Ja3Spec       `json:"-"`            //ja3 指纹
+	H2Ja3        string            `json:"h2Ja3"`        //akamai 格式的h2 指纹字符串,H2Ja3Spec 没有设置时使用这个字符串生成
+	H2Ja3Spec    ja3.H2Ja3Spec     `json:"h2Ja3Spec"`    //h2 指纹
+	Headers      map[string]string `json:"headers"`      //默认请求头
+	OrderHeaders []string          `json:"orderHeaders"` //请求头顺序
+	UserAgent    string            `json:"userAgent"`    //User-Agent
+	ClientHints  map[string]string `json:"clientHints"`  //客户端提示,例如：sec-ch-ua
+}
+
+var (
+	profiles    = map[string]Profile{}
+	profileLock sync.RWMutex
+)
+
+// 返回ja3 指纹的副本,防止多个客户端修改同一个指纹
+func (obj Profile) GetJa3Spec() ja3.Ja3Spec {
+	spec := obj.Ja3Spec
+	spec.CipherSuites = tools.CopySlices(spec.CipherSuites)
+	spec.CompressionMethods = tools.CopySlices(spec.CompressionMethods)
+	spec.Extensions = tools.CopySlices(spec.Extensions)
+	return spec
+}
+
+// 返回h2 指纹,请求头顺序会追加到伪标头之后
+func (obj Profile) GetH2Ja3Spec() ja3.H2Ja3Spec {
+	spec := obj.H2Ja3Spec
+	spec.InitialSetting = tools.CopySlices(spec.InitialSetting)
+	spec.PriorityFrames = tools.CopySlices(spec.PriorityFrames)
+	orderHeaders := []string{}
+	for _, kk := range spec.OrderHeaders {
+		if strings.HasPrefix(kk, ":") {
+			orderHeaders = append(orderHeaders, kk)
+		}
+	}
+	for _, kk := range obj.OrderHeaders {
+		if !strings.HasPrefix(kk, ":") {
+			orderHeaders = append(orderHeaders, strings.ToLower(kk))
+		}
+	}
+	if len(orderHeaders) > 0 {
+		spec.OrderHeaders = orderHeaders
+	}
+	return spec
+}
+
+// 返回默认请求头,包含User-Agent 和客户端提示
+func (obj Profile) GetHeaders() http.Header {
+	headers := http.Header{}
+	for kk, vv := range obj.Headers {
+		headers.Set(kk, vv)
+	}
+	for kk, vv := range obj.ClientHints {
+		headers.Set(kk, vv)
+	}
+	if obj.UserAgent != "" {
+		headers.Set("User-Agent", obj.UserAgent)
+	}
+	return headers
+}
+
+// 注册浏览器指纹配置,名称相同会覆盖
+func RegisterProfile(profile Profile) error {
+	if profile.Name == "" {
+		return errors.New("profile name is empty")
+	}
+	if !profile.Ja3Spec.IsSet() && profile.Ja3 != "" {
+		spec, err := ja3.CreateSpecWithStr(profile.Ja3)
+		if err != nil {
+			return tools.WrapError(err, "profile ja3 解析错误: ", profile.Name)
+		}
+		profile.Ja3Spec = spec
+	}
+	if !profile.H2Ja3Spec.IsSet() && profile.H2Ja3 != "" {
+		spec, err := ja3.CreateH2SpecWithStr(profile.H2Ja3)
+		if err != nil {
+			return tools.WrapError(err, "profile h2 指纹解析错误: ", profile.Name)
+		}
+		profile.H2Ja3Spec = spec
+	}
+	profileLock.Lock()
+	defer profileLock.Unlock()
+	profiles[profile.Name] = profile
+	return nil
+}
+
+// 根据名称获取浏览器指纹配置
+func GetProfile(name string) (Profile, bool) {
+	profileLock.RLock()
+	defer profileLock.RUnlock()
+	profile, ok := profiles[name]
+	return profile, ok
+}
+
+// 返回所有浏览器指纹配置的名称
+func ProfileNames() []string {
+	profileLock.RLock()
+	defer profileLock.RUnlock()
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// 从json 中加载浏览器指纹配置,支持单个对象或数组
+func LoadProfiles(data []byte) error {
+	var results []Profile
+	if jsonData, err := tools.Any2json(data); err != nil {
+		return err
+	} else if jsonData.IsArray() {
+		if err = json.Unmarshal(data, &results); err != nil {
+			return err
+		}
+	} else {
+		var profile Profile
+		if err = json.Unmarshal(data, &profile); err != nil {
+			return err
+		}
+		results = append(results, profile)
+	}
+	for _, profile := range results {
+		if err := RegisterProfile(profile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 从json 文件中加载浏览器指纹配置
+func LoadProfilesWithFile(filePath string) error {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	return LoadProfiles(data)
+}
+
+// 注册内置的浏览器指纹配置,内置配置错误时panic
+func registerProfileWithId(profile Profile, ja3Id ja3.ClientHelloId) {
+	spec, err := ja3.CreateSpecWithId(ja3Id)
+	if err != nil {
+		panic(tools.WrapError(err, "profile ja3 生成错误: ", profile.Name))
+	}
+	profile.Ja3Spec = spec
+	if err = RegisterProfile(profile); err != nil {
+		panic(err)
+	}
+}
+
+func init() {
+	chromeHeaders := map[string]string{
+		"Accept":                    "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.7",
+		"Accept-Encoding":           "gzip, deflate, br",
+		"Accept-Language":           "zh-CN,zh;q=0.9",
+		"Upgrade-Insecure-Requests": "1",
+		"Sec-Fetch-Site":            "none",
+		"Sec-Fetch-Mode":            "navigate",
+		"Sec-Fetch-User":            "?1",
+		"Sec-Fetch-Dest":            "document",
+	}
+	chromeOrderHeaders := []string{
+		"Host",
+		"Connection",
+		"sec-ch-ua",
+		"sec-ch-ua-mobile",
+		"sec-ch-ua-platform",
+		"Upgrade-Insecure-Requests",
+		"User-Agent",
+		"Accept",
+		"Sec-Fetch-Site",
+		"Sec-Fetch-Mode",
+		"Sec-Fetch-User",
+		"Sec-Fetch-Dest",
+		"Accept-Encoding",
+		"Accept-Language",
+		"Cookie",
+	}
+	registerProfileWithId(Profile{
+		Name:         "chrome_114",
+		H2Ja3Spec:    ja3.DefaultH2Ja3Spec(),
+		Headers:      chromeHeaders,
+		OrderHeaders: chromeOrderHeaders,
+		UserAgent:    "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/114.0.0.0 Safari/537.36",
+		ClientHints: map[string]string{
+			"sec-ch-ua":          `"Not.A/Brand";v="8", "Chromium";v="114", "Google Chrome";v="114"`,
+			"sec-ch-ua-mobile":   "?0",
+			"sec-ch-ua-platform": `"Windows"`,
+		},
+	}, ja3.HelloChrome_114_Padding_PSK_Shuf)
+	registerProfileWithId(Profile{
+		Name:         "edge_106",
+		H2Ja3Spec:    ja3.DefaultH2Ja3Spec(),
+		Headers:      chromeHeaders,
+		OrderHeaders: chromeOrderHeaders,
+		UserAgent:    "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/106.0.0.0 Safari/537.36 Edg/106.0.1370.52",
+		ClientHints: map[string]string{
+			"sec-ch-ua":          `"Chromium";v="106", "Microsoft Edge";v="106", "Not;A=Brand";v="99"`,
+			"sec-ch-ua-mobile":   "?0",
+			"sec-ch-ua-platform": `"Windows"`,
+		},
+	}, ja3.HelloEdge_106)
+	registerProfileWithId(Profile{
+		Name: "firefox_105",
+		H2Ja3Spec: ja3.H2Ja3Spec{
+			InitialSetting: []ja3.Setting{
+				{Id: 1, Val: 65536},
+				{Id: 4, Val: 131072},
+				{Id: 5, Val: 16384},
+			},
+			ConnFlow:     12517377,
+			OrderHeaders: []string{":method", ":path", ":authority", ":scheme"},
+			Priority: ja3.Priority{
+				StreamDep: 13,
+				Exclusive: false,
+				Weight:    41,
+			},
+			PriorityFrames: []ja3.PriorityFrame{ //HEADERS 依赖的stream 13 由PRIORITY 帧创建
+				{StreamId: 3, Priority: ja3.Priority{StreamDep: 0, Weight: 200}},
+				{StreamId: 5, Priority: ja3.Priority{StreamDep: 0, Weight: 100}},
+				{StreamId: 7, Priority: ja3.Priority{StreamDep: 0, Weight: 0}},
+				{StreamId: 9, Priority: ja3.Priority{StreamDep: 7, Weight: 0}},
+				{StreamId: 11, Priority: ja3.Priority{StreamDep: 3, Weight: 0}},
+				{StreamId: 13, Priority: ja3.Priority{StreamDep: 0, Weight: 240}},
+			},
+		},
+		Headers: map[string]string{
+			"Accept":                    "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8",
+			"Accept-Encoding":           "gzip, deflate, br",
+			"Accept-Language":           "zh-CN,zh;q=0.8,zh-TW;q=0.7,zh-HK;q=0.5,en-US;q=0.3,en;q=0.2",
+			"Upgrade-Insecure-Requests": "1",
+			"Sec-Fetch-Site":            "none",
+			"Sec-Fetch-Mode":            "navigate",
+			"Sec-Fetch-User":            "?1",
+			"Sec-Fetch-Dest":            "document",
+		},
+		OrderHeaders: []string{
+			"Host",
+			"User-Agent",
+			"Accept",
+			"Accept-Language",
+			"Accept-Encoding",
+			"Connection",
+			"Cookie",
+			"Upgrade-Insecure-Requests",
+			"Sec-Fetch-Dest",
+			"Sec-Fetch-Mode",
+			"Sec-Fetch-Site",
+			"Sec-Fetch-User",
+		},
+		UserAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:105.0) Gecko/20100101 Firefox/105.0",
+	}, ja3.HelloFirefox_105)
+	registerProfileWithId(Profile{
+		Name: "safari_16",
+		H2Ja3Spec: ja3.H2Ja3Spec{
+			InitialSetting: []ja3.Setting{
+				{Id: 4, Val: 4194304},
+				{Id: 3, Val: 100},
+			},
+			ConnFlow:     10485760,
+			OrderHeaders: []string{":method", ":scheme", ":path", ":authority"},
+			Priority: ja3.Priority{
+				StreamDep: 0,
+				Exclusive: false,
+				Weight:    254,
+			},
+		},
+		Headers: map[string]string{
+			"Accept":          "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8",
+			"Accept-Encoding": "gzip, deflate, br",
+			"Accept-Language": "zh-CN,zh-Hans;q=0.9",
+		},
+		OrderHeaders: []string{
+			"Host",
+			"Accept",
+			"User-Agent",
+			"Accept-Language",
+			"Accept-Encoding",
+			"Connection",
+			"Cookie",
+		},
+		UserAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.0 Safari/605.1.15",
+	}, ja3.HelloSafari_16_0)
+}
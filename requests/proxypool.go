@@ -0,0 +1,106 @@
+package requests
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"sync"
+	"time"
+
+	"gitee.com/baixudong/gospider/http2"
+)
+
+// 代理池,使用proxypool.NewClient 创建
+type ProxyPool interface {
+	GetProxy(ctx context.Context, href *url.URL) (string, error) //新建连接时获取代理
+	Report(ctx context.Context, result ProxyResult) bool         //上报请求结果,返回true 连接不再复用,下次请求更换代理
+}
+
+// 使用代理的请求结果
+type ProxyResult struct {
+	Proxy      *url.URL      //使用的代理
+	Url        *url.URL      //请求的url
+	StatusCode int           //状态码,有错误时为0
+	Err        error         //错误
+	Latency    time.Duration //收到响应头的耗时
+}
+
+type proxyPoolTransport struct {
+	pool      ProxyPool
+	dialCli   *DialClient
+	transport http.RoundTripper
+	upgs      []*http2.Upg //http2 连接所在的连接池
+}
+
+func newProxyPoolTransport(pool ProxyPool, dialCli *DialClient, transport http.RoundTripper, upgs ...*http2.Upg) http.RoundTripper {
+	return &proxyPoolTransport{pool: pool, dialCli: dialCli, transport: transport, upgs: upgs}
+}
+func (obj *proxyPoolTransport) CloseIdleConnections() {
+	if transport, ok := obj.transport.(interface{ CloseIdleConnections() }); ok {
+		transport.CloseIdleConnections()
+	}
+}
+func (obj *proxyPoolTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var conn net.Conn
+	var connLock sync.Mutex
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			connLock.Lock()
+			conn = info.Conn
+			connLock.Unlock()
+		},
+	}))
+	startTime := time.Now()
+	resp, err := obj.transport.RoundTrip(req)
+	result := ProxyResult{
+		Url:     req.URL,
+		Err:     err,
+		Latency: time.Since(startTime),
+	}
+	connLock.Lock()
+	if conn != nil {
+		result.Proxy = obj.dialCli.ConnProxy(conn)
+	}
+	connLock.Unlock()
+	var dialErr *proxyDialError
+	if result.Proxy == nil && errors.As(err, &dialErr) {
+		result.Proxy = dialErr.proxy
+	}
+	if result.Proxy == nil { //没有使用代理
+		return resp, err
+	}
+	if resp != nil {
+		result.StatusCode = resp.StatusCode
+	}
+	if !obj.pool.Report(req.Context(), result) || conn == nil {
+		return resp, err
+	}
+	//http2 连接上可能还有其它请求,标记不再复用,请求完成后关闭
+	for _, upg := range obj.upgs {
+		if upg != nil && upg.SetDoNotReuse(conn) {
+			return resp, err
+		}
+	}
+	//http1.1 连接读完响应后关闭,下次请求重新建立连接时更换代理
+	if err != nil || resp == nil || resp.Body == nil {
+		conn.Close()
+	} else if resp.StatusCode != http.StatusSwitchingProtocols {
+		resp.Body = &closeConnBody{ReadCloser: resp.Body, conn: conn}
+	}
+	return resp, err
+}
+
+// body 关闭后关闭连接
+type closeConnBody struct {
+	io.ReadCloser
+	conn net.Conn
+}
+
+func (obj *closeConnBody) Close() error {
+	defer obj.conn.Close()
+	return obj.ReadCloser.Close()
+}
@@ -9,11 +9,15 @@ import (
 	"io"
 
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 	_ "unsafe"
 
+	"gitee.com/baixudong/gospider/http2"
 	"gitee.com/baixudong/gospider/re"
 	"gitee.com/baixudong/gospider/tools"
 	"gitee.com/baixudong/gospider/websocket"
@@ -28,6 +32,7 @@ type RequestDebug struct {
 	Header http.Header
 	Proto  string
 	con    *bytes.Buffer
+	proxy  *url.URL
 }
 
 func (obj *RequestDebug) request() (*http.Request, error) {
@@ -161,6 +166,14 @@ type reqCtxData struct {
 	requestCallBack  func(context.Context, *RequestDebug) error
 	disBody          bool
 	responseCallBack func(context.Context, *ResponseDebug) error
+	disCache         bool
+	fromCache        bool
+	timing           *timing
+	proxyChain       []*url.URL
+	h3               bool
+	forceH3          bool
+	h2c              bool
+	h2cUpgrade       bool
 }
 
 func Get(preCtx context.Context, href string, options ...RequestOption) (*Response, error) {
@@ -254,9 +267,19 @@ func (obj *Client) Request(preCtx context.Context, method string, href string, o
 		rawOption = options[0]
 	}
 	optionBak := obj.newRequestOption(rawOption)
+	if optionBak.RetryPolicy != nil {
+		optionBak.TryNum = optionBak.RetryPolicy.maxRetries(optionBak.TryNum)
+	}
 	if rawOption.Body != nil && optionBak.TryNum > 0 {
 		optionBak.TryNum = 0
 	}
+	var attempts []RetryAttempt
+	defer func() {
+		if resp != nil {
+			resp.attempts = attempts
+		}
+	}()
+	startTime := time.Now()
 	//开始请求
 	var tryNum int64
 	for tryNum = 0; tryNum <= optionBak.TryNum; tryNum++ {
@@ -283,22 +306,49 @@ func (obj *Client) Request(preCtx context.Context, method string, href string, o
 					return
 				}
 			}
+			attemptTime := time.Now()
 			resp, err = obj.request(preCtx, option)
+			attempts = append(attempts, newRetryAttempt(tryNum, attemptTime, resp, err))
+			attempt := &attempts[len(attempts)-1]
+			var retry bool
+			if option.RetryPolicy != nil {
+				retry = option.RetryPolicy.retry(preCtx, *attempt)
+			}
 			if err != nil { //有错误
 				if errors.Is(err, ErrFatal) { //致命错误直接返回
 					return
 				} else if option.ErrCallBack != nil && option.ErrCallBack(preCtx, err) != nil { //不是致命错误，有错误回调,有错误,直接返回
 					return
 				}
+			} else if retry { //没有错误，状态码需要重试
 			} else if option.ResultCallBack == nil { //没有错误，且没有回调，直接返回
 				return
 			} else if err = option.ResultCallBack(preCtx, resp); err != nil { //没有错误，有回调，回调错误
+				attempt.Err, attempt.ErrClass = err, GetErrClass(err)
+				if option.RetryPolicy != nil {
+					retry = option.RetryPolicy.retry(preCtx, *attempt)
+				}
 				if option.ErrCallBack != nil && option.ErrCallBack(preCtx, err) != nil { //有错误回调,有错误直接返回
 					return
 				}
 			} else { //没有错误，有回调，没有回调错误，直接返回
 				return
 			}
+			if option.RetryPolicy != nil { //有重试策略,等待后重试
+				if !retry || tryNum >= option.TryNum || !option.RetryPolicy.delay(startTime, attempt) {
+					return
+				}
+				if resp != nil {
+					resp.Close()
+				}
+				timer := time.NewTimer(attempt.Delay)
+				select {
+				case <-obj.ctx.Done():
+				case <-preCtx.Done():
+				case <-timer.C:
+				}
+				timer.Stop()
+			}
 		}
 	}
 	if err != nil { //有错误直接返回错误
@@ -312,7 +362,7 @@ func verifyProxy(proxyUrl string) (*url.URL, error) {
 		return nil, err
 	}
 	switch proxy.Scheme {
-	case "http", "socks5", "https":
+	case "http", "https", "socks5", "socks5h", "socks4", "socks4a", "h2":
 		return proxy, nil
 	default:
 		return nil, tools.WrapError(ErrFatal, "不支持的代理协议")
@@ -334,6 +384,12 @@ func (obj *Client) request(preCtx context.Context, option RequestOption) (respon
 		ctxData.disBody = true
 	}
 	ctxData.disProxy = option.DisProxy
+	ctxData.disCache = option.DisCache
+	ctxData.h3 = option.H3
+	ctxData.forceH3 = option.ForceH3
+	ctxData.h2c = option.H2c
+	ctxData.h2cUpgrade = option.H2cUpgrade
+	ctxData.timing = newTiming()
 	if option.Proxy != "" { //代理相关构造
 		tempProxy, err := verifyProxy(option.Proxy)
 		if err != nil {
@@ -343,6 +399,13 @@ func (obj *Client) request(preCtx context.Context, option RequestOption) (respon
 	} else if tempProxy := obj.dialer.Proxy(); tempProxy != nil {
 		ctxData.proxy = tempProxy
 	}
+	for _, proxy := range option.ProxyChain {
+		tempProxy, err := verifyProxy(proxy)
+		if err != nil {
+			return response, tools.WrapError(ErrFatal, errors.New("tempRequest 构造代理链失败"), err)
+		}
+		ctxData.proxyChain = append(ctxData.proxyChain, tempProxy)
+	}
 	if option.RedirectNum != 0 { //重定向次数
 		ctxData.redirectNum = option.RedirectNum
 	}
@@ -354,6 +417,17 @@ func (obj *Client) request(preCtx context.Context, option RequestOption) (respon
 	} else {
 		reqCtx, cancel = context.WithCancel(context.WithValue(preCtx, keyPrincipalID, ctxData))
 	}
+	reqCtx = httptrace.WithClientTrace(reqCtx, ctxData.timing.clientTrace())
+	if len(option.OrderHeaders) > 0 { //请求头顺序
+		reqCtx = http2.ContextWithOrderHeaders(reqCtx, option.OrderHeaders)
+		reqCtx = httptrace.WithClientTrace(reqCtx, &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				if conn, ok := info.Conn.(*orderConn); ok {
+					conn.setOrderHeaders(option.OrderHeaders)
+				}
+			},
+		})
+	}
 	defer func() {
 		if err != nil {
 			cancel()
@@ -403,6 +477,12 @@ func (obj *Client) request(preCtx context.Context, option RequestOption) (respon
 	if reqs.Header.Get("Content-Type") == "" && reqs.Header.Get("content-type") == "" && option.ContentType != "" {
 		reqs.Header.Set("Content-Type", option.ContentType)
 	}
+	//Body 为io.Reader 时长度未知,使用请求头中的Content-Length
+	if option.Body != nil && reqs.ContentLength == 0 {
+		if contentLength, err := strconv.ParseInt(reqs.Header.Get("Content-Length"), 10, 64); err == nil && contentLength > 0 {
+			reqs.ContentLength = contentLength
+		}
+	}
 	//host构造
 	if option.Host != "" {
 		reqs.Host = option.Host
@@ -426,6 +506,7 @@ func (obj *Client) request(preCtx context.Context, option RequestOption) (respon
 		websocket.SetClientHeaders(reqs.Header, option.WsOption)
 	}
 	r, err = obj.getClient(option).Do(reqs)
+	timings := ctxData.timing.result(r)
 	if r != nil {
 		isSse := r.Header.Get("Content-Type") == "text/event-stream"
 
@@ -450,6 +531,8 @@ func (obj *Client) request(preCtx context.Context, option RequestOption) (respon
 		if response, err2 = obj.newResponse(reqCtx, cancel, r, option); err2 != nil { //创建 response
 			return response, err2
 		}
+		response.fromCache = ctxData.fromCache
+		response.timings = timings
 		if ctxData.ws && r.StatusCode == 101 {
 			if response.webSocket, err2 = websocket.NewClientConn(r); err2 != nil { //创建 websocket
 				return response, err2
@@ -31,6 +31,9 @@ type Response struct {
 	disUnzip  bool
 	filePath  string
 	bar       bool
+	fromCache bool
+	attempts  []RetryAttempt
+	timings   Timings
 }
 
 type SseClient struct {
@@ -142,6 +145,21 @@ func (obj *Response) Response() *http.Response {
 	return obj.response
 }
 
+// 是否是从缓存中返回的
+func (obj *Response) FromCache() bool {
+	return obj.fromCache
+}
+
+// 返回每一次请求的记录,包含重试
+func (obj *Response) Attempts() []RetryAttempt {
+	return obj.attempts
+}
+
+// 返回请求各个阶段的耗时,是否复用连接,使用的协议和远程ip
+func (obj *Response) Timings() Timings {
+	return obj.timings
+}
+
 // 返回websocket 对象,当发送websocket 请求时使用
 func (obj *Response) WebSocket() *websocket.Conn {
 	return obj.webSocket
@@ -0,0 +1,253 @@
+package requests
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 错误类型,用于判断是否需要重试
+type ErrClass int
+
+const (
+	ErrClassNone    ErrClass = iota //没有错误
+	ErrClassTimeout                 //超时
+	ErrClassDns                     //dns 解析错误
+	ErrClassConnect                 //连接错误,例如：连接被拒绝,连接被重置
+	ErrClassTls                     //tls 握手或证书错误
+	ErrClassCancel                  //ctx 被取消
+	ErrClassFatal                   //致命错误,不会重试
+	ErrClassOther                   //其它错误,例如：结果回调返回的错误
+)
+
+func (obj ErrClass) String() string {
+	switch obj {
+	case ErrClassNone:
+		return "none"
+	case ErrClassTimeout:
+		return "timeout"
+	case ErrClassDns:
+		return "dns"
+	case ErrClassConnect:
+		return "connect"
+	case ErrClassTls:
+		return "tls"
+	case ErrClassCancel:
+		return "cancel"
+	case ErrClassFatal:
+		return "fatal"
+	default:
+		return "other"
+	}
+}
+
+// 返回错误的类型
+func GetErrClass(err error) ErrClass {
+	if err == nil {
+		return ErrClassNone
+	}
+	if errors.Is(err, ErrFatal) {
+		return ErrClassFatal
+	}
+	if errors.Is(err, context.Canceled) {
+		return ErrClassCancel
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return ErrClassDns
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrClassTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrClassTimeout
+	}
+	var recordErr tls.RecordHeaderError
+	var certErr *tls.CertificateVerificationError
+	var unknownErr x509.UnknownAuthorityError
+	var hostErr x509.HostnameError
+	if errors.As(err, &recordErr) || errors.As(err, &certErr) || errors.As(err, &unknownErr) || errors.As(err, &hostErr) {
+		return ErrClassTls
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return ErrClassConnect
+	}
+	if errStr := err.Error(); strings.Contains(errStr, "tls: ") {
+		return ErrClassTls
+	} else if strings.Contains(errStr, "connection refused") || strings.Contains(errStr, "connection reset") || strings.Contains(errStr, "EOF") {
+		return ErrClassConnect
+	}
+	return ErrClassOther
+}
+
+// 每一次请求的记录
+type RetryAttempt struct {
+	Attempt    int           //第几次请求,从1 开始
+	StartTime  time.Time     //开始时间
+	Duration   time.Duration //请求耗时
+	StatusCode int           //状态码,有错误时为0
+	Err        error         //错误
+	ErrClass   ErrClass      //错误类型
+	RetryAfter time.Duration //服务端返回的Retry-After
+	Delay      time.Duration //重试前等待的时间,没有重试为0
+}
+
+// 重试策略
+type RetryPolicy struct {
+	MaxRetries    int           //最大重试次数,default:请求的TryNum,TryNum 为0 时为3
+	BaseDelay     time.Duration //第一次重试的等待时间,default:500ms
+	MaxDelay      time.Duration //最大等待时间,Retry-After 超过这个时间时不再重试,default:30s
+	Multiplier    float64       //等待时间的增长倍数,default:2
+	Jitter        float64       //随机抖动比例,0-1,default:0.2
+	DisJitter     bool          //关闭随机抖动
+	Budget        time.Duration //所有重试的总时间预算,超过后不再重试,0:不限制
+	Statuses      []int         //需要重试的状态码,default:408,429,500,502,503,504
+	DisRetryAfter bool          //不使用429,503 响应中的Retry-After
+
+	//是否重试,返回true 重试。默认：致命错误和ctx 取消不重试,其它错误重试,状态码在Statuses 中的重试
+	Decision func(ctx context.Context, attempt RetryAttempt) bool
+}
+
+var defaultRetryStatuses = []int{
+	http.StatusRequestTimeout,
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+func (obj *RetryPolicy) maxRetries(tryNum int64) int64 {
+	if obj.MaxRetries > 0 {
+		return int64(obj.MaxRetries)
+	}
+	if tryNum > 0 {
+		return tryNum
+	}
+	return 3
+}
+
+// 是否需要重试
+func (obj *RetryPolicy) retry(ctx context.Context, attempt RetryAttempt) bool {
+	if obj.Decision != nil {
+		return obj.Decision(ctx, attempt)
+	}
+	switch attempt.ErrClass {
+	case ErrClassFatal, ErrClassCancel:
+		return false
+	case ErrClassNone:
+	default:
+		return true
+	}
+	statuses := obj.Statuses
+	if statuses == nil {
+		statuses = defaultRetryStatuses
+	}
+	for _, status := range statuses {
+		if status == attempt.StatusCode {
+			return true
+		}
+	}
+	return false
+}
+
+func (obj *RetryPolicy) maxDelay() time.Duration {
+	if obj.MaxDelay <= 0 {
+		return time.Second * 30
+	}
+	return obj.MaxDelay
+}
+
+// 第n 次重试前的等待时间,n 从1 开始
+func (obj *RetryPolicy) backoff(n int) time.Duration {
+	baseDelay := obj.BaseDelay
+	if baseDelay <= 0 {
+		baseDelay = time.Millisecond * 500
+	}
+	maxDelay := obj.maxDelay()
+	multiplier := obj.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	delay := float64(baseDelay) * math.Pow(multiplier, float64(n-1))
+	if delay > float64(maxDelay) {
+		delay = float64(maxDelay)
+	}
+	if !obj.DisJitter {
+		jitter := obj.Jitter
+		if jitter <= 0 || jitter > 1 {
+			jitter = 0.2
+		}
+		delay = delay * (1 - jitter + 2*jitter*rand.Float64())
+	}
+	return time.Duration(delay)
+}
+
+// 计算下一次重试的等待时间,返回false 不再重试
+func (obj *RetryPolicy) delay(startTime time.Time, attempt *RetryAttempt) bool {
+	delay := obj.backoff(attempt.Attempt)
+	if !obj.DisRetryAfter && attempt.RetryAfter > 0 {
+		if attempt.RetryAfter > obj.maxDelay() { //服务端要求的等待时间超过MaxDelay,不再重试
+			return false
+		}
+		delay = attempt.RetryAfter
+	}
+	if obj.Budget > 0 && time.Since(startTime)+delay > obj.Budget {
+		return false
+	}
+	attempt.Delay = delay
+	return true
+}
+
+// 解析Retry-After,支持秒数和http 时间
+func parseRetryAfter(resp *Response) time.Duration {
+	if resp == nil || resp.response == nil {
+		return 0
+	}
+	switch resp.StatusCode() {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+	default:
+		return 0
+	}
+	retryAfter := strings.TrimSpace(resp.response.Header.Get("Retry-After"))
+	if retryAfter == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseInt(retryAfter, 10, 64); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(retryAfter); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay
+		}
+	}
+	return 0
+}
+
+func newRetryAttempt(num int64, startTime time.Time, resp *Response, err error) RetryAttempt {
+	attempt := RetryAttempt{
+		Attempt:   int(num) + 1,
+		StartTime: startTime,
+		Duration:  time.Since(startTime),
+		Err:       err,
+		ErrClass:  GetErrClass(err),
+	}
+	if resp != nil && resp.response != nil {
+		attempt.StatusCode = resp.StatusCode()
+		attempt.RetryAfter = parseRetryAfter(resp)
+	}
+	return attempt
+}
@@ -0,0 +1,52 @@
+package requests
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// robots.txt 检查,使用robots.NewClient 创建
+type RobotsChecker interface {
+	Allowed(ctx context.Context, href *url.URL) (bool, error) //url 是否允许访问
+}
+
+// robots.txt 禁止访问的错误,不会重试
+type DisallowedError struct {
+	Url *url.URL
+}
+
+func (obj *DisallowedError) Error() string {
+	return "robots.txt 禁止访问: " + obj.Url.String()
+}
+func (obj *DisallowedError) Unwrap() error {
+	return ErrFatal
+}
+
+type robotsTransport struct {
+	robots    RobotsChecker
+	transport http.RoundTripper
+}
+
+func newRobotsTransport(robots RobotsChecker, transport http.RoundTripper) http.RoundTripper {
+	return &robotsTransport{robots: robots, transport: transport}
+}
+func (obj *robotsTransport) CloseIdleConnections() {
+	if transport, ok := obj.transport.(interface{ CloseIdleConnections() }); ok {
+		transport.CloseIdleConnections()
+	}
+}
+
+// 每一次请求都会检查,包含重定向
+func (obj *robotsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Path != "/robots.txt" {
+		allowed, err := obj.robots.Allowed(req.Context(), req.URL)
+		if err != nil {
+			return nil, err
+		}
+		if !allowed {
+			return nil, &DisallowedError{Url: req.URL}
+		}
+	}
+	return obj.transport.RoundTrip(req)
+}
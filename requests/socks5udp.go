@@ -0,0 +1,160 @@
+package requests
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"gitee.com/baixudong/gospider/tools"
+)
+
+// 通过socks5 代理的UDP ASSOCIATE 连接addr,socks5h 时由代理解析域名
+func (obj *DialClient) socks5UdpDial(ctx context.Context, addr string, proxyUrl *url.URL) (net.Conn, error) {
+	if proxyUrl.Scheme != "socks5" && proxyUrl.Scheme != "socks5h" {
+		return nil, errors.New("udp 只支持socks5 代理")
+	}
+	var err error
+	if proxyUrl.Scheme == "socks5" {
+		if addr, err = obj.AddrToIp(ctx, addr); err != nil {
+			return nil, err
+		}
+	}
+	header, err := socks5AddrBytes(addr)
+	if err != nil {
+		return nil, err
+	}
+	ctrl, err := obj.DialContext(ctx, "tcp", proxyUrl.Host)
+	if err != nil {
+		return nil, err
+	}
+	startTime := time.Now()
+	var relayAddr string
+	if err = verifyWithContext(ctx, func() error {
+		if err := obj.socks5Auth(proxyUrl, ctrl); err != nil {
+			return err
+		}
+		relayAddr, err = socks5Command(ctrl, 3, []byte{1, 0, 0, 0, 0, 0, 0}) //发送udp 的地址未知,使用0.0.0.0:0
+		return err
+	}); err != nil {
+		ctrl.Close()
+		return nil, tools.WrapError(err, "socks5 UDP ASSOCIATE 错误")
+	}
+	relayHost, relayPort, err := net.SplitHostPort(relayAddr)
+	if err != nil {
+		ctrl.Close()
+		return nil, err
+	}
+	if ip := net.ParseIP(relayHost); ip == nil || ip.IsUnspecified() { //代理没有返回转发地址时使用代理的地址
+		relayHost, _, _ = net.SplitHostPort(ctrl.RemoteAddr().String())
+	}
+	relayAddr = net.JoinHostPort(relayHost, relayPort)
+	conn, err := obj.netDialer(ctx, "udp", relayAddr).DialContext(ctx, "udp", relayAddr)
+	if err != nil {
+		ctrl.Close()
+		return nil, err
+	}
+	ctxTiming(ctx).addProxy(startTime)
+	udpConn := &socks5UdpConn{
+		Conn:       conn,
+		ctrl:       ctrl,
+		remoteAddr: socks5UdpAddr(addr),
+		header:     append([]byte{0, 0, 0}, header...),
+		remoteDns:  proxyUrl.Scheme == "socks5h",
+		dialCli:    obj,
+	}
+	go udpConn.keepCtrl()
+	return udpConn, nil
+}
+
+// socks5 转发的udp 地址,可能是域名
+type socks5UdpAddr string
+
+func (obj socks5UdpAddr) Network() string {
+	return "udp"
+}
+func (obj socks5UdpAddr) String() string {
+	return string(obj)
+}
+
+// 通过socks5 代理转发的udp 连接,同时实现net.Conn 和net.PacketConn
+type socks5UdpConn struct {
+	net.Conn            // 到代理转发地址的udp 连接
+	ctrl       net.Conn // UDP ASSOCIATE 的tcp 连接,关闭后代理停止转发
+	remoteAddr net.Addr // Write 的目标地址
+	header     []byte   // Write 的目标地址对应的请求头
+	remoteDns  bool     // WriteTo 时是否由代理解析域名
+	dialCli    *DialClient
+}
+
+// tcp 连接关闭时关闭udp 连接
+func (obj *socks5UdpConn) keepCtrl() {
+	obj.ctrl.Read(make([]byte, 1))
+	obj.Close()
+}
+func (obj *socks5UdpConn) RemoteAddr() net.Addr {
+	return obj.remoteAddr
+}
+func (obj *socks5UdpConn) Close() error {
+	obj.ctrl.Close()
+	return obj.Conn.Close()
+}
+func (obj *socks5UdpConn) Read(b []byte) (int, error) {
+	n, _, err := obj.ReadFrom(b)
+	return n, err
+}
+func (obj *socks5UdpConn) Write(b []byte) (int, error) {
+	if _, err := obj.Conn.Write(append(append([]byte{}, obj.header...), b...)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// udp 数据包的缓冲区,每次读取单独使用,支持并发读取
+var socks5UdpBufPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, 65535)
+		return &buf
+	},
+}
+
+func (obj *socks5UdpConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	bufP := socks5UdpBufPool.Get().(*[]byte)
+	defer socks5UdpBufPool.Put(bufP)
+	buf := *bufP
+	for {
+		n, err := obj.Conn.Read(buf)
+		if err != nil {
+			return 0, nil, err
+		}
+		if n < 4 || buf[2] != 0 { //不支持分片,丢弃
+			continue
+		}
+		reader := bytes.NewReader(buf[3:n])
+		addr, err := readSocks5Addr(reader)
+		if err != nil {
+			continue
+		}
+		return copy(b, buf[n-reader.Len():n]), socks5UdpAddr(addr), nil
+	}
+}
+func (obj *socks5UdpConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	target := addr.String()
+	if !obj.remoteDns {
+		var err error
+		if target, err = obj.dialCli.AddrToIp(context.TODO(), target); err != nil {
+			return 0, err
+		}
+	}
+	header, err := socks5AddrBytes(target)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = obj.Conn.Write(append(append([]byte{0, 0, 0}, header...), b...)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
@@ -0,0 +1,142 @@
+package requests
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// 请求各个阶段的耗时
+type Timings struct {
+	Dns       time.Duration //dns 解析耗时
+	Proxy     time.Duration //代理握手耗时,包含与代理的tls 握手
+	Connect   time.Duration //tcp 连接耗时,使用代理时为连接代理的耗时
+	Tls       time.Duration //tls 握手耗时
+	FirstByte time.Duration //发送完请求到收到第一个字节的耗时
+	Total     time.Duration //开始请求到收到响应头的总耗时
+	Reused    bool          //是否复用连接
+	Proto     string        //使用的协议,例如：HTTP/1.1,HTTP/2.0
+	RemoteIp  net.IP        //连接的远程ip,使用代理时为代理的ip
+}
+
+// 收集一次请求的耗时,在DialClient 和httptrace 中更新
+type timing struct {
+	lock         sync.Mutex
+	timings      Timings
+	start        time.Time
+	wroteRequest time.Time
+	dialed       bool
+	gotConn      bool
+}
+
+func newTiming() *timing {
+	return &timing{start: time.Now()}
+}
+
+// 从ctx 中获取当前请求的timing,不是requests 发起的请求返回nil
+func ctxTiming(ctx context.Context) *timing {
+	if reqData, ok := ctx.Value(keyPrincipalID).(*reqCtxData); ok {
+		return reqData.timing
+	}
+	return nil
+}
+
+// 累加startTime 到现在的耗时
+func (obj *timing) add(val *time.Duration, startTime time.Time) {
+	if obj == nil {
+		return
+	}
+	obj.lock.Lock()
+	*val += time.Since(startTime)
+	obj.dialed = true
+	obj.lock.Unlock()
+}
+func (obj *timing) addDns(startTime time.Time) {
+	if obj != nil {
+		obj.add(&obj.timings.Dns, startTime)
+	}
+}
+func (obj *timing) addProxy(startTime time.Time) {
+	if obj != nil {
+		obj.add(&obj.timings.Proxy, startTime)
+	}
+}
+func (obj *timing) addConnect(startTime time.Time, conn net.Conn) {
+	if obj == nil {
+		return
+	}
+	obj.add(&obj.timings.Connect, startTime)
+	if conn != nil {
+		obj.setRemoteIp(conn.RemoteAddr())
+	}
+}
+func (obj *timing) addTls(startTime time.Time) {
+	if obj != nil {
+		obj.add(&obj.timings.Tls, startTime)
+	}
+}
+
+// 当前累计的tls 握手耗时
+func (obj *timing) tlsDuration() time.Duration {
+	if obj == nil {
+		return 0
+	}
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	return obj.timings.Tls
+}
+func (obj *timing) setRemoteIp(addr net.Addr) {
+	if addr == nil {
+		return
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return
+	}
+	obj.lock.Lock()
+	obj.timings.RemoteIp = net.ParseIP(host)
+	obj.lock.Unlock()
+}
+func (obj *timing) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			obj.lock.Lock()
+			obj.gotConn = true
+			obj.timings.Reused = info.Reused
+			obj.lock.Unlock()
+			if info.Conn != nil {
+				obj.setRemoteIp(info.Conn.RemoteAddr())
+			}
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			obj.lock.Lock()
+			obj.wroteRequest = time.Now()
+			obj.lock.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			obj.lock.Lock()
+			if !obj.wroteRequest.IsZero() {
+				obj.timings.FirstByte = time.Since(obj.wroteRequest)
+			}
+			obj.lock.Unlock()
+		},
+	}
+}
+
+// 收到响应头后返回耗时
+func (obj *timing) result(r *http.Response) Timings {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	timings := obj.timings
+	timings.Total = time.Since(obj.start)
+	if !obj.gotConn { //h2 复用连接时不会触发GotConn
+		timings.Reused = !obj.dialed
+	}
+	if r != nil {
+		timings.Proto = r.Proto
+	}
+	return timings
+}
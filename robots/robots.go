@@ -0,0 +1,343 @@
+package robots
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gitee.com/baixudong/gospider/requests"
+)
+
+// robots.txt 最大解析长度,rfc 9309 要求至少500kb
+const maxRobotsLen = 500 * 1024
+
+// robots.txt 中的一条规则
+type Rule struct {
+	Allow bool   //true:Allow,false:Disallow
+	Path  string //路径,支持通配符 * 和结尾符 $
+}
+
+// 一组user-agent 的规则
+type Group struct {
+	Agents     []string      //user-agent
+	Rules      []Rule        //规则
+	CrawlDelay time.Duration //Crawl-delay
+}
+
+// 解析后的robots.txt
+type Data struct {
+	Groups   []Group
+	Sitemaps []string
+}
+
+// 解析robots.txt
+func Parse(content []byte) *Data {
+	if len(content) > maxRobotsLen {
+		content = content[:maxRobotsLen]
+	}
+	content = bytes.TrimPrefix(content, []byte("\xef\xbb\xbf"))
+	data := &Data{}
+	var group *Group
+	var lastAgent bool //上一行是否是user-agent,连续的user-agent 属于同一组
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 64*1024), maxRobotsLen)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i != -1 {
+			line = line[:i]
+		}
+		key, val, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		val = strings.TrimSpace(val)
+		switch key {
+		case "user-agent":
+			if !lastAgent || group == nil {
+				data.Groups = append(data.Groups, Group{})
+				group = &data.Groups[len(data.Groups)-1]
+			}
+			group.Agents = append(group.Agents, strings.ToLower(val))
+			lastAgent = true
+			continue
+		case "allow", "disallow":
+			if group != nil && val != "" {
+				group.Rules = append(group.Rules, Rule{Allow: key == "allow", Path: normalizePath(val)})
+			}
+		case "crawl-delay":
+			if group != nil {
+				if delay, err := strconv.ParseFloat(val, 64); err == nil && delay > 0 {
+					group.CrawlDelay = time.Duration(delay * float64(time.Second))
+				}
+			}
+		case "sitemap":
+			if val != "" {
+				data.Sitemaps = append(data.Sitemaps, val)
+			}
+		}
+		lastAgent = false
+	}
+	return data
+}
+
+// 对路径中的非ascii 字符进行编码,保证和url 中的路径格式一致
+func normalizePath(val string) string {
+	var builder strings.Builder
+	for i := 0; i < len(val); i++ {
+		if c := val[i]; c >= 0x80 || c == ' ' {
+			builder.WriteString("%" + strings.ToUpper(strconv.FormatUint(uint64(c), 16)))
+		} else {
+			builder.WriteByte(c)
+		}
+	}
+	return builder.String()
+}
+
+// 返回匹配agent 的规则组,匹配最长的user-agent,名称相同的组合并,没有匹配时使用 *
+func (obj *Data) Group(agent string) Group {
+	agent = strings.ToLower(agent)
+	var result Group
+	matchLen := -1
+	for _, group := range obj.Groups {
+		nameLen, name := -1, ""
+		for _, val := range group.Agents {
+			if val == "*" && nameLen < 0 {
+				nameLen, name = 0, val
+			} else if val != "*" && val != "" && strings.Contains(agent, val) && len(val) > nameLen {
+				nameLen, name = len(val), val
+			}
+		}
+		if nameLen < 0 || nameLen < matchLen {
+			continue
+		}
+		if nameLen > matchLen {
+			matchLen = nameLen
+			result = Group{Agents: []string{name}}
+		}
+		result.Rules = append(result.Rules, group.Rules...)
+		if group.CrawlDelay > result.CrawlDelay {
+			result.CrawlDelay = group.CrawlDelay
+		}
+	}
+	return result
+}
+
+// 路径是否允许访问,路径包含query,匹配最长的规则,长度相同时Allow 优先
+func (obj *Data) Allowed(agent string, path string) bool {
+	if path == "" {
+		path = "/"
+	}
+	if path == "/robots.txt" {
+		return true
+	}
+	allowed := true
+	matchLen := -1
+	for _, rule := range obj.Group(agent).Rules {
+		if !matchPath(rule.Path, path) {
+			continue
+		}
+		if len(rule.Path) > matchLen || len(rule.Path) == matchLen && rule.Allow {
+			matchLen = len(rule.Path)
+			allowed = rule.Allow
+		}
+	}
+	return allowed
+}
+
+// 返回agent 的Crawl-delay
+func (obj *Data) CrawlDelay(agent string) time.Duration {
+	return obj.Group(agent).CrawlDelay
+}
+
+// 通配符匹配,* 匹配任意字符,$ 匹配结尾
+func matchPath(pattern string, path string) bool {
+	end := strings.HasSuffix(pattern, "$")
+	if end {
+		pattern = pattern[:len(pattern)-1]
+	}
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	pos := len(parts[0])
+	for i, part := range parts[1:] {
+		if i == len(parts)-2 && end { //最后一段需要匹配结尾
+			return strings.HasSuffix(path[pos:], part)
+		}
+		index := strings.Index(path[pos:], part)
+		if index == -1 {
+			return false
+		}
+		pos += index + len(part)
+	}
+	if end {
+		return pos == len(path)
+	}
+	return true
+}
+
+type hostData struct {
+	data     *Data
+	expireAt time.Time
+	wait     chan struct{} //正在获取robots.txt 时不为nil,获取完成后关闭
+}
+
+// 标记ctx 正在获取的robots.txt,获取时的重定向不再检查,避免死锁
+type fetchKey struct{}
+
+// robots.txt 客户端,通过requests.Client 获取robots.txt,按照host 缓存
+type Client struct {
+	reqCli       *requests.Client
+	userAgent    string
+	cacheTime    time.Duration
+	errCacheTime time.Duration
+	lock         sync.Mutex
+	hosts        map[string]*hostData
+}
+type ClientOption struct {
+	ReqCli    *requests.Client //获取robots.txt 的客户端,默认新建一个
+	UserAgent string           //匹配robots.txt 中User-agent 的名称,例如：Googlebot,default:*
+	CacheTime time.Duration    //缓存时间,default:24h
+
+	ErrCacheTime time.Duration //服务器错误(5xx)时禁止所有的缓存时间,default:1m
+}
+
+func NewClient(preCtx context.Context, options ...ClientOption) (*Client, error) {
+	var option ClientOption
+	if len(options) > 0 {
+		option = options[0]
+	}
+	if option.ReqCli == nil {
+		reqCli, err := requests.NewClient(preCtx)
+		if err != nil {
+			return nil, err
+		}
+		option.ReqCli = reqCli
+	}
+	if option.UserAgent == "" {
+		option.UserAgent = "*"
+	}
+	if option.CacheTime == 0 {
+		option.CacheTime = time.Hour * 24
+	}
+	if option.ErrCacheTime == 0 {
+		option.ErrCacheTime = time.Minute
+	}
+	return &Client{
+		reqCli:       option.ReqCli,
+		userAgent:    option.UserAgent,
+		cacheTime:    option.CacheTime,
+		errCacheTime: option.ErrCacheTime,
+		hosts:        map[string]*hostData{},
+	}, nil
+}
+
+// 获取href 所在网站的robots.txt,有缓存时使用缓存
+func (obj *Client) Get(ctx context.Context, href *url.URL) (*Data, error) {
+	key := href.Scheme + "://" + href.Host
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if fetching, _ := ctx.Value(fetchKey{}).(string); fetching == key { //获取robots.txt 时的重定向,允许访问
+		return &Data{}, nil
+	}
+	for {
+		obj.lock.Lock()
+		host, ok := obj.hosts[key]
+		if !ok {
+			host = &hostData{}
+			obj.hosts[key] = host
+		}
+		if host.data != nil && time.Now().Before(host.expireAt) {
+			obj.lock.Unlock()
+			return host.data, nil
+		}
+		if host.wait == nil {
+			host.wait = make(chan struct{})
+			obj.lock.Unlock()
+			return obj.fetch(ctx, key, host)
+		}
+		wait := host.wait
+		obj.lock.Unlock()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-wait: //其它请求获取完成,重新检查缓存
+		}
+	}
+}
+
+// 获取robots.txt,不持有锁,获取完成后唤醒等待的请求
+func (obj *Client) fetch(ctx context.Context, key string, host *hostData) (*Data, error) {
+	var data *Data
+	var cacheTime time.Duration
+	defer func() {
+		obj.lock.Lock()
+		if data != nil {
+			host.data = data
+			host.expireAt = time.Now().Add(cacheTime)
+		}
+		close(host.wait)
+		host.wait = nil
+		obj.lock.Unlock()
+	}()
+	resp, err := obj.reqCli.Request(context.WithValue(ctx, fetchKey{}, key), "get", key+"/robots.txt", requests.RequestOption{DisDecode: true})
+	if err != nil {
+		return nil, err
+	}
+	cacheTime = obj.cacheTime
+	switch status := resp.StatusCode(); {
+	case status >= 200 && status < 300:
+		data = Parse(resp.Content())
+	case status >= 400 && status < 500: //没有robots.txt,允许所有
+		data = &Data{}
+	default: //服务器错误,禁止所有,短时间后重试
+		data = &Data{Groups: []Group{{Agents: []string{"*"}, Rules: []Rule{{Path: "/"}}}}}
+		cacheTime = obj.errCacheTime
+	}
+	return data, nil
+}
+
+// url 是否允许访问,实现requests.RobotsChecker
+func (obj *Client) Allowed(ctx context.Context, href *url.URL) (bool, error) {
+	data, err := obj.Get(ctx, href)
+	if err != nil {
+		return false, err
+	}
+	path := href.EscapedPath()
+	if href.RawQuery != "" {
+		path += "?" + href.RawQuery
+	}
+	return data.Allowed(obj.userAgent, path), nil
+}
+
+// 返回Crawl-delay,可以用于requests.LimiterOption 的CrawlDelay
+func (obj *Client) CrawlDelay(ctx context.Context, href *url.URL) (time.Duration, error) {
+	data, err := obj.Get(ctx, href)
+	if err != nil {
+		return 0, err
+	}
+	return data.CrawlDelay(obj.userAgent), nil
+}
+
+// 返回网站地图
+func (obj *Client) Sitemaps(ctx context.Context, href *url.URL) ([]string, error) {
+	data, err := obj.Get(ctx, href)
+	if err != nil {
+		return nil, err
+	}
+	return data.Sitemaps, nil
+}
+
+// 清除缓存
+func (obj *Client) Clear() {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	obj.hosts = map[string]*hostData{}
+}
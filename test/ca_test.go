@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gitee.com/baixudong/gospider/ca"
+	"gitee.com/baixudong/gospider/proxy"
+	"gitee.com/baixudong/gospider/requests"
+)
+
+func TestCa(t *testing.T) {
+	dir := t.TempDir()
+	option := ca.ClientOption{
+		CertFile: filepath.Join(dir, "root", "ca.crt"),
+		KeyFile:  filepath.Join(dir, "root", "ca.key"),
+		CacheDir: filepath.Join(dir, "certs"),
+		Validity: time.Hour * 24 * 30,
+	}
+	caCli, err := ca.NewClient(option)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//根证书保存在磁盘中,再次创建时读取
+	caCli2, err := ca.NewClient(option)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !caCli.RootCert().Equal(caCli2.RootCert()) {
+		t.Fatal("根证书没有持久化")
+	}
+	if info, err := os.Stat(option.KeyFile); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatal("私钥文件权限错误: ", err)
+	}
+	//签发的证书支持域名,通配符域名,ip
+	tlsCert, err := caCli.Issue("example.com", "*.example.com", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(caCli.RootCert())
+	for _, name := range []string{"example.com", "www.example.com", "127.0.0.1"} {
+		if _, err = tlsCert.Leaf.Verify(x509.VerifyOptions{DNSName: name, Roots: roots}); err != nil {
+			t.Fatal("证书验证失败: ", name, err)
+		}
+	}
+	if validity := tlsCert.Leaf.NotAfter.Sub(time.Now()); validity > option.Validity || validity < option.Validity-time.Hour {
+		t.Fatal("证书有效期错误: ", validity)
+	}
+	//内存缓存,顺序不影响
+	if tlsCert2, _ := caCli.Issue("127.0.0.1", "example.com", "*.example.com"); tlsCert2 != tlsCert {
+		t.Fatal("证书没有缓存在内存中")
+	}
+	//磁盘缓存
+	tlsCert2, err := caCli2.Issue("*.example.com", "example.com", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsCert2.Leaf.SerialNumber.Cmp(tlsCert.Leaf.SerialNumber) != 0 {
+		t.Fatal("证书没有缓存在磁盘中")
+	}
+	//导出根证书
+	derPath, pemPath := filepath.Join(dir, "ca.cer"), filepath.Join(dir, "ca.pem")
+	if err = caCli.Export(derPath); err != nil {
+		t.Fatal(err)
+	}
+	if err = caCli.Export(pemPath); err != nil {
+		t.Fatal(err)
+	}
+	derData, _ := os.ReadFile(derPath)
+	pemData, _ := os.ReadFile(pemPath)
+	block, _ := pem.Decode(pemData)
+	if cert, err := x509.ParseCertificate(derData); err != nil || !cert.Equal(caCli.RootCert()) || block == nil || string(block.Bytes) != string(derData) {
+		t.Fatal("导出的根证书错误")
+	}
+	//本地tls 服务使用签发的证书
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	server.Listener = tls.NewListener(server.Listener, caCli.TlsConfig())
+	server.Start()
+	defer server.Close()
+	href := "https://" + server.Listener.Addr().String()
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	resp, err := client.Get(href)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	//代理使用证书颁发机构签发证书
+	mitm, err := proxy.NewClient(nil, proxy.ClientOption{Ca: caCli})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mitm.Close()
+	reqCli, err := requests.NewClient(nil, requests.ClientOption{Proxy: "http://" + mitm.Addr()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := reqCli.Request(nil, "get", href)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Text() != "ok" {
+		t.Fatal("代理转发错误: ", response.Text())
+	}
+	if _, err = response.Response().TLS.PeerCertificates[0].Verify(x509.VerifyOptions{DNSName: "127.0.0.1", Roots: roots}); err != nil {
+		t.Fatal("代理的证书不是证书颁发机构签发的: ", err)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"gitee.com/baixudong/gospider/requests"
+)
+
+func TestCache(t *testing.T) {
+	var hits atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		switch r.URL.Path {
+		case "/fresh":
+			w.Header().Set("Cache-Control", "max-age=60")
+		case "/etag":
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/nostore":
+			w.Header().Set("Cache-Control", "no-store")
+		case "/vary":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Vary", "X-V")
+		case "/error":
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		fmt.Fprint(w, "hello ", r.URL.Path)
+	}))
+	defer server.Close()
+	cache, err := requests.NewCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqCli, err := requests.NewClient(nil, requests.ClientOption{Cache: cache})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/fresh", "/etag", "/nostore"} {
+		hits.Store(0)
+		for i := 0; i < 2; i++ {
+			resp, err := reqCli.Request(nil, "get", server.URL+path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Text() != "hello "+path {
+				t.Fatal("内容不一致: ", resp.Text())
+			}
+			if resp.FromCache() != (i == 1 && path != "/nostore") {
+				t.Fatal("缓存状态错误: ", path, i)
+			}
+		}
+		if path == "/fresh" && hits.Load() != 1 || path != "/fresh" && hits.Load() != 2 {
+			t.Fatal("请求次数错误: ", path, hits.Load())
+		}
+	}
+	forceCache, err := requests.NewCache(t.TempDir(), requests.CacheForce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqCli, err = requests.NewClient(nil, requests.ClientOption{Cache: forceCache})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hits.Store(0)
+	for i := 0; i < 2; i++ {
+		if _, err = reqCli.Request(nil, "get", server.URL+"/nostore"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if hits.Load() != 1 {
+		t.Fatal("强制缓存请求次数错误: ", hits.Load())
+	}
+	//强制缓存不保存5xx
+	hits.Store(0)
+	for i := 0; i < 2; i++ {
+		if _, err = reqCli.Request(nil, "get", server.URL+"/error"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if hits.Load() != 2 {
+		t.Fatal("强制缓存保存了5xx: ", hits.Load())
+	}
+	//不安全的方法删除url 所有Vary 的缓存文件
+	varyDir := t.TempDir()
+	varyCache, err := requests.NewCache(varyDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqCli, err = requests.NewClient(nil, requests.ClientOption{Cache: varyCache})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, val := range []string{"1", "2"} {
+		if _, err = reqCli.Request(nil, "get", server.URL+"/vary", requests.RequestOption{Headers: map[string]string{"X-V": val}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if files, _ := os.ReadDir(varyDir); len(files) == 0 {
+		t.Fatal("没有保存缓存文件")
+	}
+	if _, err = reqCli.Request(nil, "post", server.URL+"/vary"); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := os.ReadDir(varyDir); len(files) != 0 {
+		names := []string{}
+		for _, file := range files {
+			names = append(names, file.Name())
+		}
+		t.Fatal("缓存文件没有删除: ", names)
+	}
+	//缓存的响应保持原来的http 版本
+	h2Server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprint(w, "hello")
+	}))
+	h2Server.EnableHTTP2 = true
+	h2Server.StartTLS()
+	defer h2Server.Close()
+	reqCli, err = requests.NewClient(nil, requests.ClientOption{Cache: cache})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		resp, err := reqCli.Request(nil, "get", h2Server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response := resp.Response(); resp.FromCache() != (i == 1) || response.ProtoMajor != 2 || response.ProtoMinor != 0 {
+			t.Fatal("缓存的http 版本错误: ", i, response.Proto, response.ProtoMajor, response.ProtoMinor)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gitee.com/baixudong/gospider/requests"
+)
+
+func TestCurl(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		cookie, _ := r.Cookie("sid")
+		var cookieVal string
+		if cookie != nil {
+			cookieVal = cookie.Value
+		}
+		w.Write([]byte(r.Method + "|" + r.URL.RawQuery + "|" + r.Header.Get("X-Token") + "|" + r.Header.Get("Content-Type") + "|" + cookieVal + "|" + string(body)))
+	}))
+	defer server.Close()
+	method, href, option, err := requests.ParseCurl(`curl '` + server.URL + `/api?a=1' \
+  -H 'x-token: it'\''s' \
+  -H "content-type: application/json" \
+  -b 'sid=abc; lang=en' \
+  --data-raw $'{"name":"gospider中"}' \
+  --compressed -sSL`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if method != "POST" || option.Json == nil {
+		t.Fatal("解析错误: ", method, option)
+	}
+	var curl string
+	option.RequestCallBack = func(ctx context.Context, rd *requests.RequestDebug) error {
+		curl = rd.Curl()
+		return nil
+	}
+	resp, err := requests.Request(nil, method, href, option)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `POST|a=1|it's|application/json|abc|{"name":"gospider中"}`
+	if resp.Text() != want {
+		t.Fatal("请求内容错误: ", resp.Text())
+	}
+	//导出的curl 命令再解析一次,请求结果不变
+	method, href, option, err = requests.ParseCurl(curl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = requests.Request(nil, method, href, option)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Text() != want {
+		t.Fatal("导出的curl 命令错误: ", curl, resp.Text())
+	}
+
+	method, href, option, err = requests.ParseCurl(`curl -G ` + server.URL + ` -d b=2 --data-urlencode 'c=x y' -XPUT`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = requests.Request(nil, method, href, option)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Text() != "PUT|b=2&c=x+y||||" {
+		t.Fatal("-G 解析错误: ", resp.Text())
+	}
+
+	//短选项的参数直接写在选项后面
+	method, href, _, err = requests.ParseCurl(`curl -ofile -w%{http_code} -r0-100 -D- ` + server.URL + `/short`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if method != "GET" || href != server.URL+"/short" {
+		t.Fatal("短选项解析错误: ", method, href)
+	}
+}
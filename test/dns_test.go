@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"gitee.com/baixudong/gospider/requests"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// 所有A 记录都返回127.0.0.1
+func dnsAnswer(t *testing.T, query []byte) []byte {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(query); err != nil {
+		t.Error(err)
+		return nil
+	}
+	msg.Header.Response = true
+	for _, question := range msg.Questions {
+		if question.Type == dnsmessage.TypeA {
+			msg.Answers = append(msg.Answers, dnsmessage.Resource{
+				Header: dnsmessage.ResourceHeader{Name: question.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+				Body:   &dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}},
+			})
+		}
+	}
+	con, err := msg.Pack()
+	if err != nil {
+		t.Error(err)
+	}
+	return con
+}
+
+func TestDnsServers(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	href := "http://gospider.test:" + port
+	var dohGet, dohPost, dot atomic.Int64
+	dohServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var query []byte
+		if r.Method == http.MethodPost {
+			dohPost.Add(1)
+			query, _ = io.ReadAll(r.Body)
+		} else {
+			dohGet.Add(1)
+			query, _ = base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+		}
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(dnsAnswer(t, query))
+	}))
+	defer dohServer.Close()
+	dotListener, err := tls.Listen("tcp", "127.0.0.1:0", dohServer.TLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dotListener.Close()
+	go func() {
+		for {
+			conn, err := dotListener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				head := make([]byte, 2)
+				if _, err := io.ReadFull(conn, head); err != nil {
+					return
+				}
+				query := make([]byte, binary.BigEndian.Uint16(head))
+				if _, err := io.ReadFull(conn, query); err != nil {
+					return
+				}
+				dot.Add(1)
+				answer := dnsAnswer(t, query)
+				binary.BigEndian.PutUint16(head, uint16(len(answer)))
+				conn.Write(append(head, answer...))
+			}()
+		}
+	}()
+	deadListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadAddr := deadListener.Addr().String()
+	deadListener.Close()
+	proxy := newConnectProxy(t, true)
+	defer proxy.Close()
+	var proxyNum atomic.Int64
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(dohServer.Certificate())
+
+	for _, option := range []requests.ClientOption{
+		{DnsServers: []string{"tls://" + deadAddr, dohServer.URL + "/dns-query"}, DnsRootCAs: rootCAs},
+		{DnsServers: []string{dohServer.URL + "/dns-query"}, DnsPost: true, DnsRootCAs: rootCAs},
+		{DnsServers: []string{dotListener.Addr().String()}, DnsRootCAs: rootCAs},
+		{DnsServers: []string{dohServer.URL}, DnsRootCAs: rootCAs, DnsProxy: true, GetProxy: func(ctx context.Context, href *url.URL) (string, error) {
+			if href.Host == dohServer.Listener.Addr().String() { //只有doh 请求使用代理
+				proxyNum.Add(1)
+				return "http://" + proxy.Addr().String(), nil
+			}
+			return "", nil
+		}},
+	} {
+		reqCli, err := requests.NewClient(nil, option)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			reqCli.CloseIdleConnections()
+			resp, err := reqCli.Request(nil, "get", href)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Text() != "ok" {
+				t.Fatal("内容错误: ", resp.Text())
+			}
+		}
+	}
+	//每个客户端只查询一次,第二次使用缓存
+	if dohGet.Load() != 2 || dohPost.Load() != 1 || dot.Load() != 1 {
+		t.Fatal("dns 查询次数错误: ", dohGet.Load(), dohPost.Load(), dot.Load())
+	}
+	if proxyNum.Load() != 1 {
+		t.Fatal("doh 没有使用代理")
+	}
+	//证书不受信任的DoH,DoT 服务不能使用
+	for _, server := range []string{dohServer.URL, dotListener.Addr().String()} {
+		reqCli, err := requests.NewClient(nil, requests.ClientOption{DnsServers: []string{server}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = reqCli.Request(nil, "get", href); err == nil {
+			t.Fatal("没有校验dns 服务的证书: ", server)
+		}
+	}
+	if dohGet.Load() != 2 || dot.Load() != 1 {
+		t.Fatal("证书校验失败后仍然发送了dns 查询")
+	}
+	if _, err = requests.NewClient(nil, requests.ClientOption{DnsServers: []string{"udp://" + deadAddr}}); err == nil {
+		t.Fatal("不支持的dns 协议没有返回错误")
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gitee.com/baixudong/gospider/download"
+)
+
+// 写入超过limit 后中断连接
+type brokenWriter struct {
+	http.ResponseWriter
+	limit int64
+}
+
+func (obj *brokenWriter) Write(con []byte) (int, error) {
+	if obj.limit >= 0 && int64(len(con)) > obj.limit {
+		con = con[:obj.limit]
+		obj.ResponseWriter.Write(con)
+		panic(http.ErrAbortHandler)
+	}
+	obj.limit -= int64(len(con))
+	return obj.ResponseWriter.Write(con)
+}
+
+func TestDownload(t *testing.T) {
+	content := make([]byte, 3*1024*1024+123)
+	rand.Read(content)
+	sum := sha256.Sum256(content)
+	var broken atomic.Bool
+	var ranges []string
+	var rangesLock sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Disposition", `attachment; filename="data.bin"`)
+		if !broken.Load() {
+			rangesLock.Lock()
+			ranges = append(ranges, r.Header.Get("Range"))
+			rangesLock.Unlock()
+		}
+		var limit int64 = -1
+		if broken.Load() && r.Header.Get("Range") != "bytes=0-0" {
+			limit = 100 * 1024
+		}
+		http.ServeContent(&brokenWriter{ResponseWriter: w, limit: limit}, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+	downCli, err := download.NewClient(nil, download.ClientOption{Thread: 3, TryNum: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//连接中断,保存进度
+	broken.Store(true)
+	if _, err = downCli.Download(nil, server.URL+"/file", dir); err == nil {
+		t.Fatal("连接中断时没有返回错误")
+	}
+	if _, err = os.Stat(filepath.Join(dir, "data.bin.part.json")); err != nil {
+		t.Fatal("没有保存下载进度: ", err)
+	}
+	//断点续传
+	broken.Store(false)
+	filePath, err := downCli.Download(nil, server.URL+"/file", dir, download.DownloadOption{Checksum: "sha256:" + hex.EncodeToString(sum[:])})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filePath != filepath.Join(dir, "data.bin") {
+		t.Fatal("文件名错误: ", filePath)
+	}
+	rangesLock.Lock()
+	for _, val := range ranges {
+		if strings.HasPrefix(val, "bytes=0-") && val != "bytes=0-0" {
+			t.Fatal("没有断点续传: ", ranges)
+		}
+	}
+	rangesLock.Unlock()
+	con, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(con, content) {
+		t.Fatal("文件内容错误")
+	}
+	if _, err = os.Stat(filePath + ".part.json"); !os.IsNotExist(err) {
+		t.Fatal("下载完成后没有删除进度文件")
+	}
+	//校验失败
+	if _, err = downCli.Download(nil, server.URL+"/file", filepath.Join(dir, "other.bin"), download.DownloadOption{Checksum: "md5:00"}); err == nil {
+		t.Fatal("校验失败时没有返回错误")
+	}
+}
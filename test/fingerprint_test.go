@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"gitee.com/baixudong/gospider/fingerprint"
+	"gitee.com/baixudong/gospider/ja3"
+	"gitee.com/baixudong/gospider/requests"
+)
+
+func TestFingerprint(t *testing.T) {
+	server, err := fingerprint.NewServer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	ja3Str := "771,4865-4866-4867-49195-49199-49196-49200-52393-52392-49171-49172-156-157-47-53,23-65281-10-11-35-16-5-13-18-51-45-43-27-17513,29-23-24,0"
+	ja3Spec, err := ja3.CreateSpecWithStr(ja3Str)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h2Ja3 := "1:65536;4:131072;5:16384|12517377|3:0:0:201,5:0:0:101,7:0:0:1,9:0:7:1,11:0:3:1,13:0:0:241|m,p,a,s"
+	h2Ja3Spec, err := ja3.CreateH2SpecWithStr(h2Ja3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqCli, err := requests.NewClient(nil, requests.ClientOption{Ja3Spec: ja3Spec, H2Ja3Spec: h2Ja3Spec})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := reqCli.Request(nil, "get", server.Url(), requests.RequestOption{Cookies: "a=1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result fingerprint.Result
+	if _, err = resp.Json(&result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Tls == nil || result.Tls.Ja3 != ja3Str {
+		t.Fatal("ja3 指纹不一致: ", result.Tls)
+	}
+	if result.H2 == nil || result.H2.Akamai != h2Ja3 {
+		t.Fatal("h2 指纹不一致: ", result.H2)
+	}
+	if result.Cookies["a"] != "1" {
+		t.Fatal("cookies 不一致: ", result.Cookies)
+	}
+}
+
+func TestOrderHeaders(t *testing.T) {
+	orderHeaders := []string{"Host", "user-agent", "accept", "Cookie", "X-Test", "accept-encoding"}
+	for _, protos := range [][]string{{"http/1.1"}, {"h2"}} {
+		server, err := fingerprint.NewServer(nil, fingerprint.ServerOption{NextProtos: protos})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer server.Close()
+		reqCli, err := requests.NewClient(nil, requests.ClientOption{OrderHeaders: orderHeaders})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := reqCli.Request(nil, "get", server.Url(), requests.RequestOption{
+			Headers: map[string]string{
+				"User-Agent":      "gospider",
+				"Accept":          "*/*",
+				"Accept-Encoding": "gzip",
+				"X-Test":          "1",
+			},
+			Cookies: "a=1",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var result fingerprint.Result
+		if _, err = resp.Json(&result); err != nil {
+			t.Fatal(err)
+		}
+		want := orderHeaders
+		if protos[0] == "h2" {
+			want = []string{"user-agent", "accept", "cookie", "x-test", "accept-encoding"}
+		}
+		if strings.Join(result.OrderHeaders, ",") != strings.Join(want, ",") {
+			t.Fatal("请求头顺序不一致: ", result.Proto, result.OrderHeaders)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"gitee.com/baixudong/gospider/http2"
+	"gitee.com/baixudong/gospider/ja3"
+	"gitee.com/baixudong/gospider/requests"
+	xhttp2 "golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// 返回请求的协议和请求体,响应头中返回服务端记录的h2 指纹
+var h2cHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	if h2Ja3Data := http2.GetRequestH2Ja3Data(r); h2Ja3Data != nil {
+		w.Header().Set("X-Settings", fmt.Sprint(h2Ja3Data.InitialSetting))
+		if len(h2Ja3Data.OrderHeaders) > 0 {
+			w.Header().Set("X-H2", h2Ja3Data.String())
+		}
+	}
+	body, _ := io.ReadAll(r.Body)
+	w.Write([]byte(r.Proto + " " + string(body)))
+})
+
+// 使用Upg.ServerConn 的h2c 服务,返回地址和连接数
+func newH2cServer(t *testing.T) (string, *atomic.Int64) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	upg := http2.NewUpg(nil, http2.UpgOption{Server: true})
+	var conns atomic.Int64
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conns.Add(1)
+			go func() {
+				defer conn.Close()
+				upg.ServerConn(context.Background(), conn, h2cHandler)
+			}()
+		}
+	}()
+	return "http://" + listener.Addr().String(), &conns
+}
+
+func TestH2c(t *testing.T) {
+	href, conns := newH2cServer(t)
+	h2Ja3 := "1:65536;4:131072;5:16384|12517377|3:0:0:201,5:0:0:101,7:0:0:1,9:0:7:1,11:0:3:1,13:0:0:241|m,p,a,s"
+	h2Ja3Spec, err := ja3.CreateH2SpecWithStr(h2Ja3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := func(reqCli *requests.Client, href string, option requests.RequestOption) *requests.Response {
+		t.Helper()
+		resp, err := reqCli.Request(nil, "post", href, option)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	//prior knowledge
+	reqCli, err := requests.NewClient(nil, requests.ClientOption{H2c: true, H2Ja3Spec: h2Ja3Spec})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reqCli.Close()
+	resp := request(reqCli, href, requests.RequestOption{Data: "pk"})
+	if resp.Text() != "HTTP/2.0 pk" {
+		t.Fatal("prior knowledge 错误: ", resp.Text())
+	}
+	if h2 := resp.Headers().Get("X-H2"); h2 != h2Ja3 {
+		t.Fatal("h2c 指纹错误: ", h2)
+	}
+
+	//Upgrade: h2c,升级后的连接复用
+	reqCli2, err := requests.NewClient(nil, requests.ClientOption{H2cUpgrade: true, H2Ja3Spec: h2Ja3Spec})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reqCli2.Close()
+	connNum := conns.Load()
+	resp = request(reqCli2, href, requests.RequestOption{Data: "upgrade"})
+	if resp.Text() != "HTTP/2.0 upgrade" {
+		t.Fatal("Upgrade 错误: ", resp.Text())
+	}
+	if settings := resp.Headers().Get("X-Settings"); settings != fmt.Sprint(h2Ja3Spec.InitialSetting) {
+		t.Fatal("HTTP2-Settings 错误: ", settings)
+	}
+	resp = request(reqCli2, href, requests.RequestOption{Data: "reuse"})
+	if resp.Text() != "HTTP/2.0 reuse" {
+		t.Fatal("升级后的连接错误: ", resp.Text())
+	}
+	if h2 := resp.Headers().Get("X-H2"); h2 != h2Ja3 {
+		t.Fatal("升级后的h2c 指纹错误: ", h2)
+	}
+	if num := conns.Load() - connNum; num != 1 {
+		t.Fatal("升级后的连接没有复用: ", num)
+	}
+
+	//单独的请求开启h2c,服务端同时支持http1.1
+	reqCli3, err := requests.NewClient(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reqCli3.Close()
+	if text := request(reqCli3, href, requests.RequestOption{Data: "h1"}).Text(); text != "HTTP/1.1 h1" {
+		t.Fatal("http1.1 错误: ", text)
+	}
+	if text := request(reqCli3, href, requests.RequestOption{H2c: true, Data: "pk"}).Text(); text != "HTTP/2.0 pk" {
+		t.Fatal("请求开启h2c 错误: ", text)
+	}
+	if text := request(reqCli3, href, requests.RequestOption{H2cUpgrade: true, Data: "upgrade"}).Text(); text != "HTTP/2.0 upgrade" {
+		t.Fatal("请求开启Upgrade 错误: ", text)
+	}
+
+	//golang.org/x/net 的h2c 服务
+	xServer := httptest.NewServer(h2c.NewHandler(h2cHandler, &xhttp2.Server{}))
+	defer xServer.Close()
+	if text := request(reqCli, xServer.URL, requests.RequestOption{Data: "pk"}).Text(); text != "HTTP/2.0 pk" {
+		t.Fatal("x/net h2c prior knowledge 错误: ", text)
+	}
+	//x/net 升级的请求协议仍然是HTTP/1.1,响应是http2
+	if resp = request(reqCli2, xServer.URL, requests.RequestOption{Data: "upgrade"}); resp.Response().Proto != "HTTP/2.0" || resp.Text() != "HTTP/1.1 upgrade" {
+		t.Fatal("x/net h2c Upgrade 错误: ", resp.Response().Proto, resp.Text())
+	}
+	if text := request(reqCli2, xServer.URL, requests.RequestOption{Data: "reuse"}).Text(); text != "HTTP/2.0 reuse" {
+		t.Fatal("x/net h2c 升级后的连接错误: ", text)
+	}
+
+	//服务端不支持h2c 时使用http1.1 的响应,之后的请求不再升级,复用http1.1 的连接
+	var h1Conns, h1Upgrades atomic.Int64
+	h1Server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "" {
+			h1Upgrades.Add(1)
+		}
+		h2cHandler(w, r)
+	}))
+	h1Server.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			h1Conns.Add(1)
+		}
+	}
+	h1Server.Start()
+	defer h1Server.Close()
+	for i := 0; i < 3; i++ {
+		if text := request(reqCli2, h1Server.URL, requests.RequestOption{Data: "fallback"}).Text(); text != "HTTP/1.1 fallback" {
+			t.Fatal("不支持h2c 时没有回退: ", text)
+		}
+	}
+	if h1Upgrades.Load() != 1 || h1Conns.Load() != 2 {
+		t.Fatal("拒绝升级后没有使用http1.1: ", h1Upgrades.Load(), h1Conns.Load())
+	}
+
+	//请求体太大时不升级,按http1.1 处理
+	bigBody := strings.Repeat("a", 1<<17)
+	reqCli4, err := requests.NewClient(nil, requests.ClientOption{H2cUpgrade: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reqCli4.Close()
+	if text := request(reqCli4, href, requests.RequestOption{Data: bigBody}).Text(); text != "HTTP/1.1 "+bigBody {
+		t.Fatal("请求体太大时没有使用http1.1: ", text[:min(len(text), 20)])
+	}
+}
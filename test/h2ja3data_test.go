@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"gitee.com/baixudong/gospider/http2"
+	"gitee.com/baixudong/gospider/ja3"
+	"gitee.com/baixudong/gospider/requests"
+	"gitee.com/baixudong/gospider/tools"
+)
+
+func TestH2Ja3Data(t *testing.T) {
+	key, err := tools.CreateCertKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootCert, err := tools.CreateRootCert(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := tools.GetCertWithCN(rootCert, key, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsCert, err := tools.GetTlsCert(cert, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{tlsCert}, NextProtos: []string{"h2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	upg := http2.NewUpg(nil, http2.UpgOption{Server: true})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(http2.GetRequestH2Ja3Data(r))
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if err := conn.(*tls.Conn).Handshake(); err != nil {
+					return
+				}
+				upg.ServerConn(context.Background(), conn, handler)
+			}()
+		}
+	}()
+	h2Ja3 := "1:65536;4:131072;5:16384|12517377|3:0:0:201,5:0:0:101,7:0:0:1,9:0:7:1,11:0:3:1,13:0:0:241|m,p,a,s"
+	h2Ja3Spec, err := ja3.CreateH2SpecWithStr(h2Ja3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqCli, err := requests.NewClient(nil, requests.ClientOption{H2Ja3Spec: h2Ja3Spec})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reqCli.Close()
+	resp, err := reqCli.Request(nil, "get", "https://"+listener.Addr().String(), requests.RequestOption{
+		Headers:      map[string]string{"X-A": "1", "X-B": "2"},
+		OrderHeaders: []string{"x-b", "x-a"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var h2Ja3Data http2.H2Ja3ContextData
+	if _, err = resp.Json(&h2Ja3Data); err != nil {
+		t.Fatal(err, resp.Text())
+	}
+	//SETTINGS
+	if len(h2Ja3Data.InitialSetting) != len(h2Ja3Spec.InitialSetting) {
+		t.Fatal("SETTINGS 不一致: ", h2Ja3Data.InitialSetting)
+	}
+	for i, setting := range h2Ja3Spec.InitialSetting {
+		if h2Ja3Data.InitialSetting[i] != setting {
+			t.Fatal("SETTINGS 不一致: ", h2Ja3Data.InitialSetting)
+		}
+	}
+	//WINDOW_UPDATE
+	if h2Ja3Data.ConnFlow != h2Ja3Spec.ConnFlow {
+		t.Fatal("WINDOW_UPDATE 不一致: ", h2Ja3Data.ConnFlow)
+	}
+	//伪标头和请求头顺序
+	if strings.Join(h2Ja3Data.OrderHeaders, ",") != ":method,:path,:authority,:scheme" {
+		t.Fatal("伪标头顺序不一致: ", h2Ja3Data.OrderHeaders)
+	}
+	if len(h2Ja3Data.Headers) < 2 || h2Ja3Data.Headers[0] != "x-b" || h2Ja3Data.Headers[1] != "x-a" {
+		t.Fatal("请求头顺序不一致: ", h2Ja3Data.Headers)
+	}
+	if h2Ja3Data.String() != h2Ja3 {
+		t.Fatal("h2 指纹不一致: ", h2Ja3Data.String())
+	}
+}
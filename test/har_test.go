@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"gitee.com/baixudong/gospider/requests"
+)
+
+func TestHar(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/redirect":
+			http.Redirect(w, r, "/echo", http.StatusFound)
+		case "/echo":
+			body, _ := io.ReadAll(r.Body)
+			fmt.Fprint(w, r.Method, " ", string(body))
+		}
+	}))
+	recorder := requests.NewHarRecorder()
+	reqCli, err := requests.NewClient(nil, requests.ClientOption{HarRecorder: recorder})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := reqCli.Request(nil, "get", server.URL+"/redirect"); err != nil {
+		t.Fatal(err)
+	} else if resp.Text() != "GET " {
+		t.Fatal("内容不一致: ", resp.Text())
+	}
+	for _, data := range []string{"a=1", "a=2"} {
+		if _, err = reqCli.Request(nil, "post", server.URL+"/echo", requests.RequestOption{Data: data}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//二进制的请求体使用base64 保存
+	binaryBody := []byte{0xff, 0xfe, 0x00, 0x80, 'a'}
+	if _, err = reqCli.Request(nil, "post", server.URL+"/echo", requests.RequestOption{Raw: binaryBody}); err != nil {
+		t.Fatal(err)
+	}
+	har := recorder.Har()
+	if len(har.Log.Entries) != 5 {
+		t.Fatal("记录数量错误: ", len(har.Log.Entries))
+	}
+	if postData := har.Log.Entries[4].Request.PostData; postData == nil || postData.Encoding != "base64" || postData.Text != base64.StdEncoding.EncodeToString(binaryBody) {
+		t.Fatal("二进制请求体没有使用base64: ", postData)
+	}
+	if har.Log.Entries[0].Response.Status != http.StatusFound || har.Log.Entries[0].Response.RedirectURL != "/echo" {
+		t.Fatal("重定向没有记录")
+	}
+	harPath := filepath.Join(t.TempDir(), "test.har")
+	if err = recorder.Save(harPath); err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+
+	replay, err := requests.NewHarReplayWithFile(harPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqCli, err = requests.NewClient(nil, requests.ClientOption{HarReplay: replay})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := reqCli.Request(nil, "get", server.URL+"/redirect"); err != nil {
+		t.Fatal(err)
+	} else if resp.Text() != "GET " {
+		t.Fatal("回放内容不一致: ", resp.Text())
+	}
+	for _, data := range []string{"a=2", "a=1"} {
+		resp, err := reqCli.Request(nil, "post", server.URL+"/echo", requests.RequestOption{Data: data})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Text() != "POST "+data {
+			t.Fatal("回放内容不一致: ", resp.Text())
+		}
+	}
+	if resp, err := reqCli.Request(nil, "post", server.URL+"/echo", requests.RequestOption{Raw: binaryBody}); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(resp.Content(), append([]byte("POST "), binaryBody...)) {
+		t.Fatal("二进制请求体回放内容不一致: ", resp.Content())
+	}
+	if _, err = reqCli.Request(nil, "get", server.URL+"/none"); err == nil {
+		t.Fatal("没有记录的请求应该返回错误")
+	}
+
+	//https 请求记录tls 握手耗时,复用连接时为-1
+	tlsServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer tlsServer.Close()
+	tlsRecorder := requests.NewHarRecorder()
+	reqCli, err = requests.NewClient(nil, requests.ClientOption{HarRecorder: tlsRecorder})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reqCli.Close()
+	for i := 0; i < 2; i++ {
+		if _, err = reqCli.Request(nil, "get", tlsServer.URL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries := tlsRecorder.Har().Log.Entries
+	if timings := entries[0].Timings; timings.Ssl <= 0 || timings.Connect < timings.Ssl {
+		t.Fatal("没有记录tls 握手耗时: ", timings.Ssl, timings.Connect)
+	}
+	if timings := entries[1].Timings; timings.Ssl != -1 {
+		t.Fatal("复用连接时tls 握手耗时错误: ", timings.Ssl)
+	}
+}
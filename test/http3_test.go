@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gitee.com/baixudong/gospider/ca"
+	"gitee.com/baixudong/gospider/ja3"
+	"gitee.com/baixudong/gospider/requests"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/quic-go/logging"
+	"github.com/quic-go/quic-go/qlog"
+)
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// 记录客户端发送的quic 传输参数
+type paramsTracer struct {
+	logging.ConnectionTracer
+	lock   *sync.Mutex
+	params *[]*logging.TransportParameters
+}
+
+func (obj *paramsTracer) ReceivedTransportParameters(params *logging.TransportParameters) {
+	obj.lock.Lock()
+	*obj.params = append(*obj.params, params)
+	obj.lock.Unlock()
+	obj.ConnectionTracer.ReceivedTransportParameters(params)
+}
+
+// 同一个端口的tcp 和udp 服务,tcp 服务返回Alt-Svc,参数alt 可以指定http3 服务的端口
+func newH3Server(t *testing.T) (*httptest.Server, func() []*logging.TransportParameters) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, port, _ := net.SplitHostPort(r.Host)
+		if alt := r.URL.Query().Get("alt"); alt != "" {
+			port = alt
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Alt-Svc", `h3-29=":1"; ma=60, h3=":`+port+`"; ma=60`)
+		w.Write([]byte(r.Proto + " " + string(body)))
+	})
+	server := httptest.NewUnstartedServer(handler)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	caCli, err := ca.NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	udpConn, err := net.ListenPacket("udp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lock sync.Mutex
+	var params []*logging.TransportParameters
+	h3Server := &http3.Server{
+		Handler:   handler,
+		TLSConfig: caCli.TlsConfig(),
+		QuicConfig: &quic.Config{
+			Tracer: func(ctx context.Context, p logging.Perspective, connId quic.ConnectionID) logging.ConnectionTracer {
+				return &paramsTracer{
+					ConnectionTracer: qlog.NewConnectionTracer(nopWriteCloser{io.Discard}, p, connId),
+					lock:             &lock,
+					params:           &params,
+				}
+			},
+		},
+	}
+	go h3Server.Serve(udpConn)
+	t.Cleanup(func() {
+		h3Server.Close()
+		udpConn.Close()
+	})
+	return server, func() []*logging.TransportParameters {
+		lock.Lock()
+		defer lock.Unlock()
+		return params
+	}
+}
+
+// 读取socks5 的地址,atyp+地址+端口
+func readSocks5Addr(reader io.Reader) (string, error) {
+	head := make([]byte, 1)
+	if _, err := io.ReadFull(reader, head); err != nil {
+		return "", err
+	}
+	var host string
+	switch head[0] {
+	case 1, 4:
+		ip := make([]byte, 4)
+		if head[0] == 4 {
+			ip = make([]byte, 16)
+		}
+		if _, err := io.ReadFull(reader, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case 3:
+		if _, err := io.ReadFull(reader, head); err != nil {
+			return "", err
+		}
+		domain := make([]byte, head[0])
+		if _, err := io.ReadFull(reader, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(reader, port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// 只支持UDP ASSOCIATE 的socks5 代理,使用同一个udp 连接转发,响应带上目标的地址,可以承载quic,返回udp 转发时收到的地址
+func newQuicSocks5Proxy(t *testing.T) (net.Listener, func() []string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lock sync.Mutex
+	var targets []string
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				head := make([]byte, 3)
+				if _, err := io.ReadFull(conn, head[:2]); err != nil {
+					return
+				}
+				if _, err := io.ReadFull(conn, make([]byte, head[1])); err != nil {
+					return
+				}
+				conn.Write([]byte{5, 0})
+				if _, err := io.ReadFull(conn, head); err != nil {
+					return
+				}
+				if _, err := readSocks5Addr(conn); err != nil || head[1] != 3 {
+					return
+				}
+				relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+				if err != nil {
+					return
+				}
+				defer relay.Close()
+				remote, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+				if err != nil {
+					return
+				}
+				defer remote.Close()
+				port := relay.LocalAddr().(*net.UDPAddr).Port
+				conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, byte(port >> 8), byte(port)})
+				var client atomic.Pointer[net.UDPAddr]
+				go func() { //客户端 -> 目标地址
+					buf := make([]byte, 65535)
+					for {
+						n, clientAddr, err := relay.ReadFromUDP(buf)
+						if err != nil {
+							return
+						}
+						client.Store(clientAddr)
+						reader := bytes.NewReader(buf[3:n])
+						target, err := readSocks5Addr(reader)
+						if err != nil {
+							continue
+						}
+						lock.Lock()
+						targets = append(targets, target)
+						lock.Unlock()
+						targetAddr, err := net.ResolveUDPAddr("udp4", target)
+						if err != nil {
+							continue
+						}
+						remote.WriteToUDP(buf[n-reader.Len():n], targetAddr)
+					}
+				}()
+				go func() { //目标地址 -> 客户端
+					buf := make([]byte, 65535)
+					for {
+						n, from, err := remote.ReadFromUDP(buf)
+						if err != nil {
+							return
+						}
+						if clientAddr := client.Load(); clientAddr != nil {
+							header := append([]byte{0, 0, 0, 1}, from.IP.To4()...)
+							header = binary.BigEndian.AppendUint16(header, uint16(from.Port))
+							relay.WriteToUDP(append(header, buf[:n]...), clientAddr)
+						}
+					}
+				}()
+				io.Copy(io.Discard, conn) //tcp 连接关闭后停止转发
+			}()
+		}
+	}()
+	return listener, func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]string{}, targets...)
+	}
+}
+
+func TestHttp3(t *testing.T) {
+	server, params := newH3Server(t)
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	request := func(reqCli *requests.Client, option requests.RequestOption, query ...string) string {
+		t.Helper()
+		href := server.URL
+		if len(query) > 0 {
+			href += "?" + query[0]
+		}
+		resp, err := reqCli.Request(nil, "post", href, option)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Text()
+	}
+	//根据Alt-Svc 发现http3 服务
+	reqCli, err := requests.NewClient(nil, requests.ClientOption{H3: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reqCli.Close()
+	if text := request(reqCli, requests.RequestOption{}); text != "HTTP/2.0 " {
+		t.Fatal("第一次请求没有使用http2: ", text)
+	}
+	if text := request(reqCli, requests.RequestOption{Data: "alt"}); text != "HTTP/3.0 alt" {
+		t.Fatal("没有根据Alt-Svc 使用http3: ", text)
+	}
+	if nowParams := params(); len(nowParams) != 1 || nowParams[0].InitialMaxData != 15728640 || nowParams[0].MaxUniStreamNum != 103 || nowParams[0].MaxIdleTimeout != time.Second*30 {
+		t.Fatal("默认的quic 传输参数错误: ", len(nowParams))
+	}
+	//没有开启http3 的客户端,单独的请求开启
+	reqCli2, err := requests.NewClient(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reqCli2.Close()
+	if text := request(reqCli2, requests.RequestOption{}); text != "HTTP/2.0 " {
+		t.Fatal("没有开启http3 时使用了http3: ", text)
+	}
+	if text := request(reqCli2, requests.RequestOption{}); text != "HTTP/2.0 " {
+		t.Fatal("没有开启http3 时使用了http3: ", text)
+	}
+	if text := request(reqCli2, requests.RequestOption{ForceH3: true, Data: "force"}); text != "HTTP/3.0 force" {
+		t.Fatal("强制使用http3 错误: ", text)
+	}
+
+	//自定义quic 传输参数
+	reqCli3, err := requests.NewClient(nil, requests.ClientOption{
+		ForceH3: true,
+		H3Spec: ja3.H3Spec{
+			InitialMaxData:        1 << 21,
+			InitialMaxStreamData:  1 << 20,
+			InitialMaxStreamsBidi: 10,
+			InitialMaxStreamsUni:  20,
+			MaxIdleTimeout:        time.Second * 10,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reqCli3.Close()
+	if text := request(reqCli3, requests.RequestOption{}); text != "HTTP/3.0 " {
+		t.Fatal("强制使用http3 错误: ", text)
+	}
+	nowParams := params()
+	lastParams := nowParams[len(nowParams)-1]
+	if lastParams.InitialMaxData != 1<<21 || lastParams.InitialMaxStreamDataBidiLocal != 1<<20 || lastParams.InitialMaxStreamDataUni != 1<<20 ||
+		lastParams.MaxBidiStreamNum != 10 || lastParams.MaxUniStreamNum != 20 || lastParams.MaxIdleTimeout != time.Second*10 {
+		t.Fatal("quic 传输参数错误: ", lastParams.InitialMaxData, lastParams.MaxBidiStreamNum, lastParams.MaxUniStreamNum, lastParams.MaxIdleTimeout)
+	}
+	if _, err = requests.NewClient(nil, requests.ClientOption{H3Spec: ja3.H3Spec{Settings: map[uint64]uint64{1: 4096}}}); err == nil {
+		t.Fatal("qpack 动态表没有返回错误")
+	}
+
+	//http3 通过socks5 代理
+	proxy, targets := newQuicSocks5Proxy(t)
+	defer proxy.Close()
+	reqCli4, err := requests.NewClient(nil, requests.ClientOption{ForceH3: true, Proxy: "socks5h://" + proxy.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reqCli4.Close()
+	if text := request(reqCli4, requests.RequestOption{}); text != "HTTP/3.0 " {
+		t.Fatal("通过代理使用http3 错误: ", text)
+	}
+	if nowTargets := targets(); len(nowTargets) == 0 || nowTargets[len(nowTargets)-1] != "127.0.0.1:"+port {
+		t.Fatal("http3 没有使用代理: ", nowTargets)
+	}
+
+	//http3 不支持其它代理
+	if _, err = requests.NewClient(nil, requests.ClientOption{H3: true, Proxy: "http://" + proxy.Addr().String()}); err == nil {
+		t.Fatal("http3 使用http 代理没有返回错误")
+	}
+	if _, err = reqCli2.Request(nil, "get", server.URL, requests.RequestOption{ForceH3: true, Proxy: "http://" + proxy.Addr().String()}); !errors.Is(err, requests.ErrFatal) {
+		t.Fatal("强制使用http3 时http 代理没有返回错误: ", err)
+	}
+
+	//http3 不可用时回退
+	reqCli5, err := requests.NewClient(nil, requests.ClientOption{H3: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reqCli5.Close()
+	for i := 0; i < 3; i++ {
+		if text := request(reqCli5, requests.RequestOption{Data: "fallback"}, "alt=1"); text != "HTTP/2.0 fallback" {
+			t.Fatal("http3 不可用时没有回退: ", text)
+		}
+	}
+}
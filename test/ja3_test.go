@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"gitee.com/baixudong/gospider/ja3"
+	"golang.org/x/crypto/cryptobyte"
+)
+
+func TestJa4Spec(t *testing.T) {
+	spec, err := ja3.CreateSpecWithStr("t13d1516h2_002f,0035,009c,009d,1301,1302,1303,c013,c014,c02b,c02c,c02f,c030,cca8,cca9_0005,000a,000b,000d,0012,0015,0017,001b,0023,002b,002d,0033,4469,ff01_0403,0804,0401,0503,0805,0501,0806,0601")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(spec.CipherSuites) != 16 {
+		t.Fatal("ja4 密码套件错误")
+	}
+	if _, err = ja3.CreateSpecWithStr("q13d1516h2_002f_0005"); err == nil {
+		t.Fatal("不支持quic 的ja4")
+	}
+}
+
+func TestJa3StrVersion(t *testing.T) {
+	//标准ja3 中tls1.3 的版本也是771,通过supported_versions(43) 扩展判断
+	spec, err := ja3.CreateSpecWithStr("771,4865-4866-4867-49195-49199,0-23-65281-10-11-35-16-5-13-18-51-45-43-27-17513,29-23-24,0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.TLSVersMax != tls.VersionTLS13 {
+		t.Fatal("tls1.3 版本错误: ", spec.TLSVersMax)
+	}
+	spec, err = ja3.CreateSpecWithStr("771,49195-49199,0-23-65281-10-11-35-16-5-13,29-23-24,0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.TLSVersMax != tls.VersionTLS12 {
+		t.Fatal("tls1.2 版本错误: ", spec.TLSVersMax)
+	}
+}
+
+// chrome 的ClientHello,包含GREASE,扩展顺序和ja4 文档中的示例一致
+func chromeClientHello() []byte {
+	addExt := func(b *cryptobyte.Builder, extId uint16, f func(b *cryptobyte.Builder)) {
+		b.AddUint16(extId)
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			if f != nil {
+				f(b)
+			}
+		})
+	}
+	b := cryptobyte.NewBuilder(nil)
+	b.AddUint8(1)
+	b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddUint16(0x0303)
+		b.AddBytes(make([]byte, 32))
+		b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(make([]byte, 32)) })
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			for _, cipher := range []uint16{0x0a0a, 4865, 4866, 4867, 49195, 49199, 49196, 49200, 52393, 52392, 49171, 49172, 156, 157, 47, 53} {
+				b.AddUint16(cipher)
+			}
+		})
+		b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) { b.AddUint8(0) })
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			addExt(b, 0x1a1a, nil)
+			addExt(b, 0, func(b *cryptobyte.Builder) {
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+					b.AddUint8(0)
+					b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes([]byte("example.com")) })
+				})
+			})
+			addExt(b, 23, nil)
+			addExt(b, 65281, func(b *cryptobyte.Builder) { b.AddUint8(0) })
+			addExt(b, 10, func(b *cryptobyte.Builder) {
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+					for _, curve := range []uint16{0x2a2a, 29, 23, 24} {
+						b.AddUint16(curve)
+					}
+				})
+			})
+			addExt(b, 11, func(b *cryptobyte.Builder) {
+				b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) { b.AddUint8(0) })
+			})
+			addExt(b, 35, nil)
+			addExt(b, 16, func(b *cryptobyte.Builder) {
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+					for _, alpn := range []string{"h2", "http/1.1"} {
+						b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes([]byte(alpn)) })
+					}
+				})
+			})
+			addExt(b, 5, func(b *cryptobyte.Builder) { b.AddBytes([]byte{1, 0, 0, 0, 0}) })
+			addExt(b, 13, func(b *cryptobyte.Builder) {
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+					for _, sig := range []uint16{0x0403, 0x0804, 0x0401, 0x0503, 0x0805, 0x0501, 0x0806, 0x0601} {
+						b.AddUint16(sig)
+					}
+				})
+			})
+			addExt(b, 18, nil)
+			addExt(b, 51, func(b *cryptobyte.Builder) {
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+					b.AddUint16(29)
+					b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(make([]byte, 32)) })
+				})
+			})
+			addExt(b, 45, func(b *cryptobyte.Builder) {
+				b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) { b.AddUint8(1) })
+			})
+			addExt(b, 43, func(b *cryptobyte.Builder) {
+				b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+					for _, ver := range []uint16{0x3a3a, 0x0304, 0x0303} {
+						b.AddUint16(ver)
+					}
+				})
+			})
+			addExt(b, 27, func(b *cryptobyte.Builder) {
+				b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) { b.AddUint16(2) })
+			})
+			addExt(b, 17513, func(b *cryptobyte.Builder) {
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+					b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes([]byte("h2")) })
+				})
+			})
+			addExt(b, 0x4a4a, func(b *cryptobyte.Builder) { b.AddUint8(0) })
+			addExt(b, 21, func(b *cryptobyte.Builder) { b.AddBytes(make([]byte, 100)) })
+		})
+	})
+	return b.BytesOrPanic()
+}
+
+func TestJa4Reference(t *testing.T) {
+	tlsData, err := ja3.ParseClientHello(chromeClientHello())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsData.ServerName != "example.com" {
+		t.Fatal("sni 错误: ", tlsData.ServerName)
+	}
+	//GREASE 不参与计算
+	if ja3Str := "771,4865-4866-4867-49195-49199-49196-49200-52393-52392-49171-49172-156-157-47-53,0-23-65281-10-11-35-16-5-13-18-51-45-43-27-17513-21,29-23-24,0"; tlsData.Ja3() != ja3Str {
+		t.Fatal("ja3 错误: ", tlsData.Ja3())
+	}
+	if tlsData.Ja3Md5() != "cd08e31494f9531f560d64c695473da9" {
+		t.Fatal("ja3 md5 错误: ", tlsData.Ja3Md5())
+	}
+	//ja4 文档中chrome 的示例
+	if tlsData.Ja4() != "t13d1516h2_8daaf6152771_e5627efa2ab1" {
+		t.Fatal("ja4 错误: ", tlsData.Ja4())
+	}
+	if ja4r := "t13d1516h2_002f,0035,009c,009d,1301,1302,1303,c013,c014,c02b,c02c,c02f,c030,cca8,cca9_0005,000a,000b,000d,0012,0015,0017,001b,0023,002b,002d,0033,4469,ff01_0403,0804,0401,0503,0805,0501,0806,0601"; tlsData.Ja4r() != ja4r {
+		t.Fatal("ja4_r 错误: ", tlsData.Ja4r())
+	}
+}
+
+func TestH2Ja3Spec(t *testing.T) {
+	for _, h2Ja3 := range []string{
+		"1:65536;2:0;4:6291456;6:262144|15663105|0|m,a,s,p",
+		"1:65536;4:131072;5:16384|12517377|3:0:0:201,5:0:0:101,7:0:0:1,9:0:7:1,11:0:3:1,13:0:0:241|m,p,a,s",
+	} {
+		spec, err := ja3.CreateH2SpecWithStr(h2Ja3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if spec.String() != h2Ja3 {
+			t.Fatal("h2 指纹不一致: ", spec.String())
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gitee.com/baixudong/gospider/requests"
+)
+
+func TestJar(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", HttpOnly: true, SameSite: http.SameSiteLaxMode})
+			http.SetCookie(w, &http.Cookie{Name: "token", Value: "123", Path: "/api", Expires: time.Now().Add(time.Hour)})
+		default:
+			if cookie, err := r.Cookie("session"); err == nil {
+				w.Write([]byte(cookie.Value))
+			}
+		}
+	}))
+	defer server.Close()
+	jar := requests.NewJar()
+	reqCli, err := requests.NewClient(nil, requests.ClientOption{Jar: jar})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = reqCli.Request(nil, "get", server.URL+"/login"); err != nil {
+		t.Fatal(err)
+	}
+	cookies := jar.All()
+	if len(cookies) != 2 {
+		t.Fatal("cookies 数量错误: ", len(cookies))
+	}
+	if cookies[0].Name != "session" || !cookies[0].HttpOnly || cookies[0].SameSite != "Lax" || !cookies[0].HostOnly || !cookies[0].Session() {
+		t.Fatal("cookie 属性错误: ", cookies[0])
+	}
+	if cookies[1].Path != "/api" || cookies[1].Session() {
+		t.Fatal("cookie 属性错误: ", cookies[1])
+	}
+
+	dir := t.TempDir()
+	for _, save := range []func(*requests.Jar, string) (*requests.Jar, error){
+		func(jar *requests.Jar, filePath string) (*requests.Jar, error) {
+			if err := jar.Save(filePath); err != nil {
+				return nil, err
+			}
+			return requests.NewJarWithFile(filePath)
+		},
+		func(jar *requests.Jar, filePath string) (*requests.Jar, error) {
+			if err := jar.SaveNetscape(filePath); err != nil {
+				return nil, err
+			}
+			newJar := requests.NewJar()
+			return newJar, newJar.LoadNetscape(filePath)
+		},
+	} {
+		newJar, err := save(jar, filepath.Join(dir, "cookies"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		newCookies := newJar.All()
+		if len(newCookies) != 2 || newCookies[0].Value != "abc" || !newCookies[0].HttpOnly || newCookies[1].Expires.Unix() != cookies[1].Expires.Unix() {
+			t.Fatal("cookies 加载错误: ", newCookies)
+		}
+		resp, err := reqCli.Request(nil, "get", server.URL+"/", requests.RequestOption{Jar: newJar})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Text() != "abc" {
+			t.Fatal("加载的cookies 没有发送: ", resp.Text())
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"gitee.com/baixudong/gospider/ca"
+	"gitee.com/baixudong/gospider/requests"
+)
+
+// 并发安全的密钥日志
+type keyLogBuffer struct {
+	buf  bytes.Buffer
+	lock sync.Mutex
+}
+
+func (obj *keyLogBuffer) Write(b []byte) (int, error) {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	return obj.buf.Write(b)
+}
+func (obj *keyLogBuffer) String() string {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	return obj.buf.String()
+}
+func (obj *keyLogBuffer) Reset() {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	obj.buf.Reset()
+}
+
+// 服务端记录的密钥客户端都有记录,说明客户端写入了真实连接的密钥
+func checkKeyLog(t *testing.T, name string, clientLog string, serverLog string) {
+	if !strings.Contains(clientLog, "CLIENT_HANDSHAKE_TRAFFIC_SECRET") {
+		t.Fatal("没有写入密钥日志: ", name)
+	}
+	if serverLog == "" {
+		t.Fatal("服务端没有密钥日志: ", name)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(serverLog), "\n") {
+		if !strings.Contains(clientLog, line) {
+			t.Fatal("客户端密钥日志不完整: ", name, line)
+		}
+	}
+}
+
+func TestKeyLog(t *testing.T) {
+	serverLog := new(keyLogBuffer)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+	server.EnableHTTP2 = true
+	server.TLS = &tls.Config{KeyLogWriter: serverLog}
+	server.StartTLS()
+	defer server.Close()
+
+	//https 代理,tls 解密后转发到http CONNECT 代理
+	httpProxy := newConnectProxy(t, true)
+	defer httpProxy.Close()
+	caCli, err := ca.NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyLog := new(keyLogBuffer)
+	httpsProxy, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{GetCertificate: caCli.GetCertificate, KeyLogWriter: proxyLog})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer httpsProxy.Close()
+	go func() {
+		for {
+			conn, err := httpsProxy.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				remote, err := net.Dial("tcp", httpProxy.Addr().String())
+				if err != nil {
+					return
+				}
+				defer remote.Close()
+				go io.Copy(remote, conn)
+				io.Copy(conn, remote)
+			}()
+		}
+	}()
+
+	//tls,utls,代理的tls
+	for _, option := range []requests.ClientOption{
+		{},
+		{Ja3: true},
+		{Proxy: "https://" + httpsProxy.Addr().String()},
+	} {
+		clientLog := new(keyLogBuffer)
+		option.KeyLogWriter = clientLog
+		reqCli, err := requests.NewClient(nil, option)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := reqCli.Request(nil, "get", server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Text() != "HTTP/2.0" {
+			t.Fatal("请求错误: ", resp.Text())
+		}
+		reqCli.Close()
+		checkKeyLog(t, "target", clientLog.String(), serverLog.String())
+		if option.Proxy != "" {
+			checkKeyLog(t, "proxy", clientLog.String(), proxyLog.String())
+		}
+		serverLog.Reset()
+	}
+	//环境变量SSLKEYLOGFILE
+	keyLogFile := filepath.Join(t.TempDir(), "sslkeylog.txt")
+	t.Setenv("SSLKEYLOGFILE", keyLogFile)
+	reqCli, err := requests.NewClient(nil, requests.ClientOption{Ja3: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reqCli.Close()
+	if _, err = reqCli.Request(nil, "get", server.URL); err != nil {
+		t.Fatal(err)
+	}
+	fileLog, err := os.ReadFile(keyLogFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkKeyLog(t, "SSLKEYLOGFILE", string(fileLog), serverLog.String())
+}
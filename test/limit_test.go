@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gitee.com/baixudong/gospider/requests"
+)
+
+func TestLimiter(t *testing.T) {
+	var active, maxActive int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		num := atomic.AddInt64(&active, 1)
+		defer atomic.AddInt64(&active, -1)
+		for {
+			old := atomic.LoadInt64(&maxActive)
+			if num <= old || atomic.CompareAndSwapInt64(&maxActive, old, num) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond * 50)
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	limiter := requests.NewLimiter(requests.LimiterOption{LimitRule: requests.LimitRule{Concurrency: 2}})
+	reqCli, err := requests.NewClient(nil, requests.ClientOption{Limiter: limiter})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := reqCli.Request(nil, "get", server.URL); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if maxActive != 2 {
+		t.Fatal("并发限制错误: ", maxActive)
+	}
+	serverUrl, _ := url.Parse(server.URL)
+	limiter.SetRule(limiter.Key(serverUrl), requests.LimitRule{Rps: 10})
+	startTime := time.Now()
+	for i := 0; i < 4; i++ {
+		if _, err := reqCli.Request(nil, "get", server.URL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if time.Since(startTime) < time.Millisecond*300 {
+		t.Fatal("rps 限制错误: ", time.Since(startTime))
+	}
+}
+
+func TestLimiterIdle(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	limiter := requests.NewLimiter(requests.LimiterOption{
+		LimitRule: requests.LimitRule{MinDelay: time.Millisecond * 10},
+		IdleTime:  time.Millisecond * 100,
+	})
+	reqCli, err := requests.NewClient(nil, requests.ClientOption{Limiter: limiter})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reqCli.Close()
+	localUrl := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+	for _, href := range []string{server.URL, localUrl} {
+		if _, err = reqCli.Request(nil, "get", href); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keys := limiter.Keys()
+	sort.Strings(keys)
+	if strings.Join(keys, ",") != "127.0.0.1,localhost" {
+		t.Fatal("限速的host 错误: ", keys)
+	}
+	time.Sleep(time.Millisecond * 250)
+	if _, err = reqCli.Request(nil, "get", localUrl); err != nil {
+		t.Fatal(err)
+	}
+	if keys = limiter.Keys(); len(keys) != 1 || keys[0] != "localhost" {
+		t.Fatal("空闲的host 没有删除: ", keys)
+	}
+}
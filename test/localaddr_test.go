@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gitee.com/baixudong/gospider/requests"
+)
+
+func TestLocalAddrs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		w.Write([]byte(host))
+	}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	//每次新建连接,返回服务端看到的出口ip
+	localIps := func(reqCli *requests.Client, hrefs ...string) []string {
+		var ips []string
+		for _, href := range hrefs {
+			reqCli.CloseIdleConnections()
+			resp, err := reqCli.Request(nil, "get", href)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ips = append(ips, resp.Text())
+		}
+		return ips
+	}
+	ipHref := server.URL
+	hostHref := "http://localhost:" + port
+
+	//按顺序轮换,网段跳过网络地址和广播地址
+	reqCli, err := requests.NewClient(nil, requests.ClientOption{
+		LocalAddrs:    []string{"127.0.0.2", "127.0.1.0/30"},
+		LocalAddrMode: requests.LocalAddrRoundRobin,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, ip := range localIps(reqCli, ipHref, ipHref, ipHref, ipHref, ipHref) {
+		if want := []string{"127.0.0.2", "127.0.1.1", "127.0.0.2", "127.0.1.2", "127.0.0.2"}[i]; ip != want {
+			t.Fatal("轮换出口ip 错误: ", i, ip, want)
+		}
+	}
+	//同一个host 使用同一个出口ip
+	reqCli, err = requests.NewClient(nil, requests.ClientOption{
+		LocalAddrs:    []string{"127.0.2.0/24"},
+		LocalAddrMode: requests.LocalAddrSticky,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ips := localIps(reqCli, ipHref, hostHref, ipHref, hostHref, ipHref, hostHref)
+	if ips[0] != ips[2] || ips[0] != ips[4] || ips[1] != ips[3] || ips[1] != ips[5] {
+		t.Fatal("同一个host 的出口ip 不一致: ", ips)
+	}
+	//随机选择
+	reqCli, err = requests.NewClient(nil, requests.ClientOption{LocalAddrs: []string{"127.0.3.0/24"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ips = localIps(reqCli, ipHref, ipHref, ipHref, ipHref)
+	if ips[0] == ips[1] && ips[0] == ips[2] && ips[0] == ips[3] {
+		t.Fatal("随机出口ip 没有变化: ", ips)
+	}
+	for _, ip := range ips {
+		if _, cidr, _ := net.ParseCIDR("127.0.3.0/24"); !cidr.Contains(net.ParseIP(ip)) {
+			t.Fatal("随机出口ip 不在网段中: ", ip)
+		}
+	}
+	//只有ipv4 出口ip 时优先解析ipv4 地址,没有对应类型的出口ip 时使用默认出口
+	reqCli, err = requests.NewClient(nil, requests.ClientOption{LocalAddrs: []string{"127.0.0.5"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ips = localIps(reqCli, hostHref); ips[0] != "127.0.0.5" {
+		t.Fatal("出口ip 类型错误: ", ips)
+	}
+	reqCli, err = requests.NewClient(nil, requests.ClientOption{LocalAddrs: []string{"::1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ips = localIps(reqCli, ipHref); ips[0] != "127.0.0.1" {
+		t.Fatal("没有使用默认出口: ", ips)
+	}
+	if _, err = requests.NewClient(nil, requests.ClientOption{LocalAddrs: []string{"127.0.0"}}); err == nil {
+		t.Fatal("出口ip 格式错误没有返回错误")
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"gitee.com/baixudong/gospider/ja3"
+)
+
+func TestPipeDeadline(t *testing.T) {
+	localConn, remoteConn := ja3.Pipe(context.Background())
+	defer localConn.Close()
+	//零值表示没有超时
+	localConn.SetReadDeadline(time.Time{})
+	go remoteConn.Write([]byte("ok"))
+	buf := make([]byte, 2)
+	if n, err := localConn.Read(buf); err != nil || string(buf[:n]) != "ok" {
+		t.Fatal("读取错误: ", err)
+	}
+	//未来的时间到期后超时
+	localConn.SetReadDeadline(time.Now().Add(time.Millisecond * 100))
+	startTime := time.Now()
+	if _, err := localConn.Read(buf); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatal("没有超时: ", err)
+	}
+	if useTime := time.Since(startTime); useTime < time.Millisecond*50 || useTime > time.Second {
+		t.Fatal("超时时间错误: ", useTime)
+	}
+}
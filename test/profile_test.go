@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gitee.com/baixudong/gospider/fingerprint"
+	"gitee.com/baixudong/gospider/requests"
+)
+
+func TestProfile(t *testing.T) {
+	ja3Str := "771,4865-4866-4867-49195-49199-49196-49200-52393-52392-49171-49172-156-157-47-53,23-65281-10-11-35-16-5-13-18-51-45-43-27-17513,29-23-24,0"
+	err := requests.LoadProfiles([]byte(`[{
+		"name": "test_profile",
+		"ja3": "` + ja3Str + `",
+		"headers": {"Accept": "*/*", "X-Test": "1"},
+		"orderHeaders": ["Host", "X-Test", "User-Agent", "Accept", "Accept-Encoding", "Cookie"],
+		"userAgent": "gospider-profile"
+	}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = requests.LoadProfiles([]byte(`{"name": "bad_profile", "ja3": "771,1"}`)); err == nil {
+		t.Fatal("错误的ja3 没有返回错误")
+	}
+	for _, name := range []string{"chrome_114", "edge_106", "firefox_105", "safari_16"} {
+		profile, ok := requests.GetProfile(name)
+		if !ok {
+			t.Fatal("内置的配置不存在: ", name, requests.ProfileNames())
+		}
+		if !profile.Ja3Spec.IsSet() || !profile.H2Ja3Spec.IsSet() || profile.UserAgent == "" {
+			t.Fatal("内置的配置不完整: ", name)
+		}
+	}
+	profile, _ := requests.GetProfile("test_profile")
+	if !profile.Ja3Spec.IsSet() {
+		t.Fatal("ja3 没有生成")
+	}
+	//返回的指纹是副本,修改后不影响配置
+	spec := profile.GetJa3Spec()
+	spec.CipherSuites[0] = 0
+	if profile.Ja3Spec.CipherSuites[0] == 0 {
+		t.Fatal("ja3 指纹没有复制")
+	}
+	if orderHeaders := profile.GetH2Ja3Spec().OrderHeaders; len(orderHeaders) < 2 || orderHeaders[0] != "host" || orderHeaders[1] != "x-test" {
+		t.Fatal("h2 请求头顺序错误: ", orderHeaders)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("User-Agent") + "," + r.Header.Get("X-Test")))
+	}))
+	defer server.Close()
+	reqCli, err := requests.NewClient(nil, requests.ClientOption{Profile: "test_profile"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reqCli.Close()
+	resp, err := reqCli.Request(nil, "get", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := resp.Text(); text != "gospider-profile,1" {
+		t.Fatal("请求头不一致: ", text)
+	}
+	if _, err = requests.NewClient(nil, requests.ClientOption{Profile: "not_exists"}); err == nil || !strings.Contains(err.Error(), "not_exists") {
+		t.Fatal("不存在的配置没有返回错误: ", err)
+	}
+}
+
+func TestProfileAkamai(t *testing.T) {
+	server, err := fingerprint.NewServer(nil, fingerprint.ServerOption{NextProtos: []string{"h2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	//真实浏览器发送的akamai 指纹
+	akamais := map[string]string{
+		"chrome_114":  "1:65536;2:0;3:1000;4:6291456;6:262144|15663105|0|m,a,s,p",
+		"edge_106":    "1:65536;2:0;3:1000;4:6291456;6:262144|15663105|0|m,a,s,p",
+		"firefox_105": "1:65536;4:131072;5:16384|12517377|3:0:0:201,5:0:0:101,7:0:0:1,9:0:7:1,11:0:3:1,13:0:0:241|m,p,a,s",
+		"safari_16":   "4:4194304;3:100|10485760|0|m,s,p,a",
+	}
+	for _, name := range requests.ProfileNames() {
+		akamai, ok := akamais[name]
+		if !ok {
+			continue
+		}
+		delete(akamais, name)
+		reqCli, err := requests.NewClient(nil, requests.ClientOption{Profile: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := reqCli.Request(nil, "get", server.Url())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var result fingerprint.Result
+		if _, err = resp.Json(&result); err != nil {
+			t.Fatal(err)
+		}
+		reqCli.Close()
+		if result.H2 == nil || result.H2.Akamai != akamai {
+			t.Fatal("h2 指纹不一致: ", name, result.H2)
+		}
+		if profile, _ := requests.GetProfile(name); result.Headers.Get("User-Agent") != profile.UserAgent {
+			t.Fatal("User-Agent 不一致: ", name, result.Headers.Get("User-Agent"))
+		}
+	}
+	if len(akamais) > 0 {
+		t.Fatal("内置的配置不存在: ", akamais)
+	}
+}
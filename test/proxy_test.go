@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"gitee.com/baixudong/gospider/ca"
+	"gitee.com/baixudong/gospider/proxy"
+	"gitee.com/baixudong/gospider/requests"
+	"gitee.com/baixudong/gospider/tools"
+)
+
+func TestMitmProxy(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Server-Proto", r.Proto)
+		w.Write([]byte(r.Method + " " + r.URL.Path + " " + r.Header.Get("X-Mitm") + " " + string(body)))
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+	plainServer := httptest.NewServer(server.Config.Handler)
+	defer plainServer.Close()
+	caCli, err := ca.NewClient(ca.ClientOption{CertFile: filepath.Join(t.TempDir(), "ca.pem")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mitm, err := proxy.NewClient(nil, proxy.ClientOption{
+		Usr: "usr",
+		Pwd: "pwd",
+		Ca:  caCli,
+		RequestCallBack: func(r *http.Request) (*http.Response, error) {
+			switch r.URL.Path {
+			case "/local": //不转发,直接返回
+				return &http.Response{StatusCode: 200, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("local"))}, nil
+			case "/error":
+				return nil, errors.New("refused")
+			}
+			r.Header.Set("X-Mitm", "1")
+			return nil, nil
+		},
+		ResponseCallBack: func(r *http.Request, resp *http.Response) error {
+			resp.Header.Set("X-Mitm-Scheme", r.URL.Scheme)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mitm.Close()
+	//客户端使用requests,通过http 和socks5 代理
+	for _, proxyUrl := range []string{"http://usr:pwd@" + mitm.Addr(), "socks5://usr:pwd@" + mitm.Addr()} {
+		reqCli, err := requests.NewClient(nil, requests.ClientOption{Proxy: proxyUrl})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, href := range []string{server.URL, plainServer.URL} {
+			resp, err := reqCli.Request(nil, "post", href+"/path", requests.RequestOption{Data: "body"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Text() != "POST /path 1 body" {
+				t.Fatal("转发的请求错误: ", proxyUrl, href, resp.Text())
+			}
+			if scheme, _, _ := strings.Cut(href, ":"); resp.Headers().Get("X-Mitm-Scheme") != scheme {
+				t.Fatal("响应回调错误: ", resp.Headers())
+			}
+			if scheme, _, _ := strings.Cut(href, ":"); scheme == "https" {
+				if resp.Response().TLS.PeerCertificates[0].Issuer.CommonName != mitm.RootCert().Subject.CommonName {
+					t.Fatal("证书不是代理签发的")
+				}
+				if resp.Response().ProtoMajor != 2 || resp.Headers().Get("Server-Proto") != "HTTP/2.0" {
+					t.Fatal("没有使用http2: ", resp.Response().Proto, resp.Headers().Get("Server-Proto"))
+				}
+			}
+		}
+		resp, err := reqCli.Request(nil, "get", server.URL+"/local")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Text() != "local" {
+			t.Fatal("请求回调直接返回错误: ", resp.Text())
+		}
+		resp, err = reqCli.Request(nil, "get", server.URL+"/error")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode() != http.StatusBadGateway {
+			t.Fatal("请求回调返回错误时状态码错误: ", resp.StatusCode())
+		}
+	}
+	//客户端使用标准库http1.1,验证代理的证书
+	proxyUrl, _ := url.Parse("http://usr:pwd@" + mitm.Addr())
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(mitm.RootCert())
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyURL(proxyUrl),
+		TLSClientConfig: &tls.Config{RootCAs: rootCAs, ServerName: "127.0.0.1"},
+	}}
+	resp, err := client.Get(server.URL + "/h1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "GET /h1 1 " || resp.ProtoMajor != 1 {
+		t.Fatal("http1.1 转发错误: ", string(body), resp.Proto)
+	}
+	//代理验证失败
+	proxyUrl.User = url.UserPassword("usr", "bad")
+	resp, err = client.Get(plainServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusProxyAuthRequired {
+		t.Fatal("代理验证错误: ", resp.StatusCode)
+	}
+}
+
+func TestMitmProxyCa(t *testing.T) {
+	//默认的根证书保存在用户配置目录,各个系统的配置目录都指向临时目录
+	configDir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configDir)
+	t.Setenv("HOME", configDir)
+	t.Setenv("AppData", configDir)
+	certFile, err := ca.DefaultCertFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(certFile, configDir) {
+		t.Skip("配置目录不在临时目录中: ", certFile)
+	}
+	builtinCert, err := tools.LoadCertData(tools.CrtFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rootCerts [][]byte
+	for _, builtin := range []bool{false, false, true} {
+		mitm, err := proxy.NewClient(nil, proxy.ClientOption{BuiltinCa: builtin})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rootCerts = append(rootCerts, mitm.RootCert().Raw)
+		mitm.Close()
+	}
+	if _, err = os.Stat(certFile); err != nil {
+		t.Fatal("默认的根证书没有保存: ", err)
+	}
+	if !bytes.Equal(rootCerts[0], rootCerts[1]) {
+		t.Fatal("默认的根证书没有复用")
+	}
+	if bytes.Equal(rootCerts[0], builtinCert.Raw) || !bytes.Equal(rootCerts[2], builtinCert.Raw) {
+		t.Fatal("只有BuiltinCa 时才使用内置的根证书")
+	}
+}
+
+func TestMitmProxySocks5Greeting(t *testing.T) {
+	caCli, err := ca.NewClient(ca.ClientOption{CertFile: filepath.Join(t.TempDir(), "ca.pem")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mitm, err := proxy.NewClient(nil, proxy.ClientOption{Ca: caCli})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mitm.Close()
+	conn, err := net.Dial("tcp", mitm.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	//最多255 个验证方式
+	greeting := []byte{5, 255}
+	for i := 0; i < 255; i++ {
+		greeting = append(greeting, byte(254-i)) //无需验证的方式0 在最后
+	}
+	if _, err = conn.Write(greeting); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 2)
+	if _, err = io.ReadFull(conn, reply); err != nil {
+		t.Fatal("255 个验证方式的握手错误: ", err)
+	}
+	if reply[0] != 5 || reply[1] != 0 {
+		t.Fatal("握手响应错误: ", reply)
+	}
+}
+
+func TestMitmProxyRelay(t *testing.T) {
+	//客户端先发送数据的非http 协议
+	echoListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echoListener.Close()
+	go func() {
+		for {
+			conn, err := echoListener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	//服务端先发送数据的协议,例如：ssh,smtp
+	bannerListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bannerListener.Close()
+	go func() {
+		for {
+			conn, err := bannerListener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("SSH-2.0-test\r\n"))
+			conn.Close()
+		}
+	}()
+	caCli, err := ca.NewClient(ca.ClientOption{CertFile: filepath.Join(t.TempDir(), "ca.pem")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mitm, err := proxy.NewClient(nil, proxy.ClientOption{Ca: caCli})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mitm.Close()
+	connect := func(addr string) net.Conn {
+		conn, err := net.Dial("tcp", mitm.Addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = conn.Write([]byte("CONNECT " + addr + " HTTP/1.1\r\nHost: " + addr + "\r\n\r\n")); err != nil {
+			t.Fatal(err)
+		}
+		reply := make([]byte, len("HTTP/1.1 200"))
+		if _, err = io.ReadFull(conn, reply); err != nil || string(reply) != "HTTP/1.1 200" {
+			t.Fatal("CONNECT 错误: ", string(reply), err)
+		}
+		for end := []byte{}; !bytes.HasSuffix(end, []byte("\r\n\r\n")); { //跳过剩下的响应头
+			b := make([]byte, 1)
+			if _, err = conn.Read(b); err != nil {
+				t.Fatal(err)
+			}
+			end = append(end, b[0])
+		}
+		return conn
+	}
+	conn := connect(echoListener.Addr().String())
+	data := []byte{0, 1, 2, 3, 255, 'G', 'E'}
+	if _, err = conn.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	echo := make([]byte, len(data))
+	if _, err = io.ReadFull(conn, echo); err != nil {
+		t.Fatal("非http 的数据没有转发: ", err)
+	}
+	conn.Close()
+	if !bytes.Equal(echo, data) {
+		t.Fatal("转发的数据错误: ", echo)
+	}
+	conn = connect(bannerListener.Addr().String())
+	banner, err := io.ReadAll(conn)
+	conn.Close()
+	if err != nil || string(banner) != "SSH-2.0-test\r\n" {
+		t.Fatal("服务端先发送的数据没有转发: ", string(banner), err)
+	}
+	//没有请求回调时请求体直接转发,保留Content-Length
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := io.Copy(io.Discard, r.Body)
+		w.Write([]byte(strconv.FormatInt(r.ContentLength, 10) + " " + strconv.FormatInt(n, 10)))
+	}))
+	defer server.Close()
+	reqCli, err := requests.NewClient(nil, requests.ClientOption{Proxy: "http://" + mitm.Addr()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reqCli.Close()
+	resp, err := reqCli.Request(nil, "post", server.URL, requests.RequestOption{Raw: bytes.Repeat([]byte{0}, 1<<20)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Text() != "1048576 1048576" {
+		t.Fatal("请求体转发错误: ", resp.Text())
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gitee.com/baixudong/gospider/requests"
+)
+
+// 简单的socks4a 代理
+func newSocks4Proxy(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				head := make([]byte, 8)
+				if _, err := io.ReadFull(reader, head); err != nil || head[0] != 4 {
+					return
+				}
+				if _, err := reader.ReadString(0); err != nil { //userid
+					return
+				}
+				host := net.IP(head[4:8]).String()
+				if head[4] == 0 && head[5] == 0 && head[6] == 0 && head[7] != 0 { //socks4a
+					domain, err := reader.ReadString(0)
+					if err != nil {
+						return
+					}
+					host = domain[:len(domain)-1]
+				}
+				remote, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(head[2:4])))))
+				if err != nil {
+					conn.Write([]byte{0, 91, 0, 0, 0, 0, 0, 0})
+					return
+				}
+				defer remote.Close()
+				conn.Write([]byte{0, 90, 0, 0, 0, 0, 0, 0})
+				go io.Copy(remote, reader)
+				io.Copy(conn, remote)
+			}()
+		}
+	}()
+	return listener
+}
+
+// http2 CONNECT 代理,返回新建连接的数量
+// http2 CONNECT 代理,返回建立过的连接数和当前打开的连接数
+func newH2Proxy(t *testing.T) (*httptest.Server, *atomic.Int64, *atomic.Int64) {
+	var connNum, openNum atomic.Int64
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect || r.ProtoMajor != 2 {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		remote, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer remote.Close()
+		go func() {
+			<-r.Context().Done()
+			remote.Close()
+		}()
+		w.WriteHeader(200)
+		w.(http.Flusher).Flush()
+		go io.Copy(remote, r.Body)
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := remote.Read(buf)
+			if n > 0 {
+				w.Write(buf[:n])
+				w.(http.Flusher).Flush()
+			}
+			if err != nil {
+				return
+			}
+		}
+	}))
+	server.EnableHTTP2 = true
+	server.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			connNum.Add(1)
+			openNum.Add(1)
+		case http.StateClosed, http.StateHijacked:
+			openNum.Add(-1)
+		}
+	}
+	server.StartTLS()
+	return server, &connNum, &openNum
+}
+
+func TestProxyChain(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	socks4Proxy := newSocks4Proxy(t)
+	defer socks4Proxy.Close()
+	httpProxy := newConnectProxy(t, true)
+	defer httpProxy.Close()
+	h2Proxy, h2ConnNum, _ := newH2Proxy(t)
+	defer h2Proxy.Close()
+	h2Addr := h2Proxy.Listener.Addr().String()
+
+	//socks4a -> http -> 目标地址
+	reqCli, err := requests.NewClient(nil, requests.ClientOption{
+		ProxyChain: []string{"socks4a://" + socks4Proxy.Addr().String()},
+		Proxy:      "http://" + httpProxy.Addr().String(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := reqCli.Request(nil, "get", "http://localhost:"+port+"/chain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Text() != "/chain" || resp.Timings().Proxy <= 0 {
+		t.Fatal("代理链错误: ", resp.Text())
+	}
+	//请求单独设置的代理链,http -> h2 -> socks4 -> 目标地址
+	resp, err = reqCli.Request(nil, "get", "http://localhost:"+port+"/request", requests.RequestOption{
+		ProxyChain: []string{"http://" + httpProxy.Addr().String(), "h2://" + h2Addr},
+		Proxy:      "socks4://" + socks4Proxy.Addr().String(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Text() != "/request" {
+		t.Fatal("请求的代理链错误: ", resp.Text())
+	}
+	reqCli.Close()
+	//多个连接共用一个http2 代理连接
+	h2ConnNum.Store(0)
+	reqCli, err = requests.NewClient(nil, requests.ClientOption{Proxy: "h2://" + h2Addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, href := range []string{server.URL + "/h2", "http://localhost:" + port + "/h2"} {
+		resp, err = reqCli.Request(nil, "get", href)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Text() != "/h2" {
+			t.Fatal("http2 代理错误: ", resp.Text())
+		}
+	}
+	if h2ConnNum.Load() != 1 {
+		t.Fatal("http2 代理没有共用连接: ", h2ConnNum.Load())
+	}
+	reqCli.Close()
+}
+
+func TestH2ProxyStream(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	h2Proxy, h2ConnNum, h2OpenNum := newH2Proxy(t)
+	defer h2Proxy.Close()
+	proxyUrl, _ := url.Parse("h2://" + h2Proxy.Listener.Addr().String())
+	dialCli, err := requests.NewDail(nil, requests.DialOption{ProxyJa3: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//并发建立到代理的连接,只保留一个
+	conns := make([]net.Conn, 5)
+	var wg sync.WaitGroup
+	for i := range conns {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conn, err := dialCli.DialContextWithProxys(context.TODO(), "tcp", "http", echo.Addr().String(), echo.Addr().String(), []*url.URL{proxyUrl})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			conns[i] = conn
+		}(i)
+	}
+	wg.Wait()
+	if t.Failed() {
+		return
+	}
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	time.Sleep(time.Millisecond * 100)
+	if h2ConnNum.Load() < 1 || h2OpenNum.Load() != 1 {
+		t.Fatal("并发建立的http2 代理连接没有关闭: ", h2ConnNum.Load(), h2OpenNum.Load())
+	}
+	//读超时只取消当前stream
+	conns[0].SetReadDeadline(time.Now().Add(time.Millisecond * 100))
+	if _, err = conns[0].Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatal("读超时错误: ", err)
+	}
+	conns[1].SetDeadline(time.Now().Add(time.Second * 5))
+	if _, err = conns[1].Write([]byte("ok")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 2)
+	if _, err = io.ReadFull(conns[1], buf); err != nil || string(buf) != "ok" {
+		t.Fatal("其它stream 被超时影响: ", err)
+	}
+	conns[1].SetDeadline(time.Time{})
+	time.Sleep(time.Millisecond * 200)
+	if _, err = conns[1].Write([]byte("ok")); err != nil {
+		t.Fatal("清除超时后连接不可用: ", err)
+	}
+}
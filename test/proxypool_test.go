@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gitee.com/baixudong/gospider/proxypool"
+	"gitee.com/baixudong/gospider/requests"
+)
+
+// 简单的http CONNECT 代理,ok 为false 时拒绝所有连接
+func newConnectProxy(t *testing.T, ok bool) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil {
+					return
+				}
+				if !ok {
+					conn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
+					return
+				}
+				remote, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					return
+				}
+				defer remote.Close()
+				conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+				go io.Copy(remote, conn)
+				io.Copy(conn, remote)
+			}()
+		}
+	}()
+	return listener
+}
+
+func TestProxyPool(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	badProxy := newConnectProxy(t, false)
+	defer badProxy.Close()
+	goodProxy := newConnectProxy(t, true)
+	defer goodProxy.Close()
+	var refreshNum int
+	pool, err := proxypool.NewClient(nil, proxypool.ClientOption{
+		Refresh: func(ctx context.Context) ([]string, error) {
+			refreshNum++
+			if refreshNum <= 2 { //第一次请求只能使用坏的代理,重试时更换代理
+				return []string{badProxy.Addr().String()}, nil
+			}
+			return []string{badProxy.Addr().String(), "http://" + goodProxy.Addr().String()}, nil
+		},
+		RefreshTime: time.Nanosecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqCli, err := requests.NewClient(nil, requests.ClientOption{ProxyPool: pool, TryNum: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		reqCli.CloseIdleConnections()
+		resp, err := reqCli.Request(nil, "get", server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Text() != "ok" {
+			t.Fatal("内容错误: ", resp.Text())
+		}
+	}
+	for _, proxy := range pool.Proxys() {
+		switch proxy.Proxy {
+		case "http://" + goodProxy.Addr().String():
+			if proxy.Success != 5 {
+				t.Fatal("代理成功次数错误: ", proxy.Success)
+			}
+		case "http://" + badProxy.Addr().String():
+			if proxy.Fail != 1 || proxy.Success != 0 {
+				t.Fatal("代理失败次数错误: ", proxy.Fail)
+			}
+		default:
+			t.Fatal("未知的代理: ", proxy.Proxy)
+		}
+	}
+}
+
+func TestProxyPoolRefreshEvicted(t *testing.T) {
+	pool, err := proxypool.NewClient(nil, proxypool.ClientOption{
+		Refresh: func(ctx context.Context) ([]string, error) {
+			return []string{"http://127.0.0.1:8080"}, nil
+		},
+		RefreshTime:    time.Nanosecond,
+		MinSamples:     1,
+		MinSuccessRate: 0.5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy, _ := url.Parse("http://127.0.0.1:8080")
+	if !pool.Report(nil, requests.ProxyResult{Proxy: proxy, Err: errors.New("test")}) {
+		t.Fatal("失败的请求没有更换代理")
+	}
+	if proxys := pool.Proxys(); len(proxys) != 1 || !proxys[0].Evicted {
+		t.Fatal("代理没有被淘汰: ", proxys)
+	}
+	if _, err = pool.GetProxy(nil, nil); err != nil {
+		t.Fatal("刷新后被淘汰的代理没有恢复: ", err)
+	}
+	if proxys := pool.Proxys(); len(proxys) != 1 || proxys[0].Evicted || proxys[0].Fail != 0 {
+		t.Fatal("刷新后被淘汰的代理没有重新统计: ", proxys)
+	}
+}
+
+// 固定使用一个代理,状态码429 时更换代理
+type banPool struct {
+	proxy string
+}
+
+func (obj *banPool) GetProxy(ctx context.Context, href *url.URL) (string, error) {
+	return obj.proxy, nil
+}
+func (obj *banPool) Report(ctx context.Context, result requests.ProxyResult) bool {
+	return result.StatusCode == http.StatusTooManyRequests
+}
+
+func TestProxyPoolHttp2Drain(t *testing.T) {
+	var connNum atomic.Int64
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ban":
+			w.WriteHeader(http.StatusTooManyRequests)
+		case "/slow":
+			time.Sleep(time.Millisecond * 300)
+			w.Write([]byte("ok"))
+		default:
+			w.Write([]byte(r.Proto))
+		}
+	}))
+	server.EnableHTTP2 = true
+	server.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			connNum.Add(1)
+		}
+	}
+	server.StartTLS()
+	defer server.Close()
+	proxy := newConnectProxy(t, true)
+	defer proxy.Close()
+	reqCli, err := requests.NewClient(nil, requests.ClientOption{ProxyPool: &banPool{proxy: "http://" + proxy.Addr().String()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := reqCli.Request(nil, "get", server.URL); err != nil || resp.Text() != "HTTP/2.0" {
+		t.Fatal("http2 请求失败: ", err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		resp, err := reqCli.Request(nil, "get", server.URL+"/slow")
+		if err != nil || resp.Text() != "ok" {
+			t.Error("同一个连接上的其它请求被中断: ", err)
+		}
+	}()
+	time.Sleep(time.Millisecond * 100)
+	if resp, err := reqCli.Request(nil, "get", server.URL+"/ban"); err != nil || resp.StatusCode() != http.StatusTooManyRequests {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if connNum.Load() != 1 {
+		t.Fatal("请求没有复用http2 连接: ", connNum.Load())
+	}
+	if _, err := reqCli.Request(nil, "get", server.URL); err != nil {
+		t.Fatal(err)
+	}
+	if connNum.Load() != 2 {
+		t.Fatal("更换代理后没有建立新的连接: ", connNum.Load())
+	}
+}
+
+func TestProxyPoolStickyIdle(t *testing.T) {
+	pool, err := proxypool.NewClient(nil, proxypool.ClientOption{
+		Proxys:     []string{"127.0.0.1:7890"},
+		StickyKey:  func(ctx context.Context, href *url.URL) string { return href.Path },
+		StickyIdle: time.Millisecond * 100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/a", "/b"} {
+		if _, err = pool.GetProxy(nil, &url.URL{Path: path}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stickys := pool.Stickys(); len(stickys) != 2 || stickys["/a"] != "http://127.0.0.1:7890" {
+		t.Fatal("会话保持错误: ", stickys)
+	}
+	for i := 0; i < 4; i++ { //一直使用的会话不会删除
+		time.Sleep(time.Millisecond * 50)
+		if _, err = pool.GetProxy(nil, &url.URL{Path: "/a"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stickys := pool.Stickys(); len(stickys) != 1 || stickys["/a"] == "" {
+		t.Fatal("空闲的会话没有删除: ", stickys)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gitee.com/baixudong/gospider/requests"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	var hits atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		switch r.URL.Path {
+		case "/unavailable":
+			if hits.Load() < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		case "/limit":
+			if hits.Load() < 2 {
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+		case "/later":
+			w.Header().Set("Retry-After", "86400")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		case "/notfound":
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	reqCli, err := requests.NewClient(nil, requests.ClientOption{
+		RetryPolicy: &requests.RetryPolicy{BaseDelay: time.Millisecond * 10, MaxRetries: 3},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hits.Store(0)
+	resp, err := reqCli.Request(nil, "get", server.URL+"/unavailable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Text() != "ok" || len(resp.Attempts()) != 3 || resp.Attempts()[0].StatusCode != http.StatusServiceUnavailable {
+		t.Fatal("重试错误: ", resp.Text(), len(resp.Attempts()))
+	}
+
+	hits.Store(0)
+	startTime := time.Now()
+	if resp, err = reqCli.Request(nil, "get", server.URL+"/limit"); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(startTime) < time.Second || resp.Attempts()[0].Delay != time.Second {
+		t.Fatal("没有使用Retry-After: ", time.Since(startTime))
+	}
+
+	hits.Store(0)
+	if resp, err = reqCli.Request(nil, "get", server.URL+"/limit", requests.RequestOption{
+		RetryPolicy: &requests.RetryPolicy{Budget: time.Millisecond * 500},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode() != http.StatusTooManyRequests || len(resp.Attempts()) != 1 {
+		t.Fatal("超过预算后不应该重试")
+	}
+
+	//Retry-After 超过MaxDelay 时不再重试
+	startTime = time.Now()
+	if resp, err = reqCli.Request(nil, "get", server.URL+"/later"); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode() != http.StatusServiceUnavailable || len(resp.Attempts()) != 1 || time.Since(startTime) > time.Second {
+		t.Fatal("Retry-After 超过MaxDelay 后不应该重试: ", len(resp.Attempts()), time.Since(startTime))
+	}
+
+	hits.Store(0)
+	if resp, err = reqCli.Request(nil, "get", server.URL+"/notfound", requests.RequestOption{
+		RetryPolicy: &requests.RetryPolicy{
+			BaseDelay: time.Millisecond * 10,
+			Decision: func(ctx context.Context, attempt requests.RetryAttempt) bool {
+				return attempt.StatusCode == http.StatusNotFound
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if hits.Load() != 4 || len(resp.Attempts()) != 4 {
+		t.Fatal("重试次数错误: ", hits.Load())
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"gitee.com/baixudong/gospider/requests"
+	"gitee.com/baixudong/gospider/robots"
+)
+
+const robotsTxt = `# test
+User-agent: *
+Disallow: /private
+Allow: /private/public
+Disallow: /*.pdf$
+Crawl-delay: 2
+
+User-agent: Googlebot
+User-agent: Bingbot
+Disallow: /nogoogle
+
+Sitemap: https://example.com/sitemap.xml
+`
+
+func TestRobotsParse(t *testing.T) {
+	data := robots.Parse([]byte(robotsTxt))
+	for path, allowed := range map[string]bool{
+		"/":                    true,
+		"/private":             false,
+		"/private/a":           false,
+		"/private/public/a":    true,
+		"/a/b.pdf":             false,
+		"/a/b.pdf?x=1":         true,
+		"/nogoogle":            true,
+		"/robots.txt":          true,
+		"/private/public?a=1":  true,
+		"/privateer/something": false,
+	} {
+		if data.Allowed("gospider", path) != allowed {
+			t.Fatal("规则匹配错误: ", path)
+		}
+	}
+	if data.Allowed("Mozilla/5.0 (compatible; Googlebot/2.1)", "/nogoogle") || !data.Allowed("Googlebot", "/private") {
+		t.Fatal("user-agent 匹配错误")
+	}
+	if data.CrawlDelay("gospider") != time.Second*2 || data.CrawlDelay("bingbot") != 0 {
+		t.Fatal("Crawl-delay 错误")
+	}
+	if len(data.Sitemaps) != 1 {
+		t.Fatal("Sitemap 错误")
+	}
+}
+
+func TestRobotsClient(t *testing.T) {
+	var robotsHits int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			robotsHits++
+			w.Write([]byte(robotsTxt))
+			return
+		}
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/private", http.StatusFound)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	robotsCli, err := robots.NewClient(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqCli, err := requests.NewClient(nil, requests.ClientOption{Robots: robotsCli})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := reqCli.Request(nil, "get", server.URL+"/index"); err != nil || resp.Text() != "ok" {
+		t.Fatal("允许的url 请求失败: ", err)
+	}
+	for _, path := range []string{"/private", "/redirect"} {
+		_, err = reqCli.Request(nil, "get", server.URL+path)
+		var disErr *requests.DisallowedError
+		if !errors.As(err, &disErr) || !errors.Is(err, requests.ErrFatal) {
+			t.Fatal("禁止的url 没有返回DisallowedError: ", err)
+		}
+	}
+	if robotsHits != 1 {
+		t.Fatal("robots.txt 没有缓存: ", robotsHits)
+	}
+}
+
+// 延迟设置的robots.Client,使获取robots.txt 和检查使用同一个requests.Client
+type lazyRobots struct {
+	cli *robots.Client
+}
+
+func (obj *lazyRobots) Allowed(ctx context.Context, href *url.URL) (bool, error) {
+	return obj.cli.Allowed(ctx, href)
+}
+
+func TestRobotsClientRedirect(t *testing.T) {
+	var lock sync.Mutex
+	var robotsHits int
+	failed := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			lock.Lock()
+			robotsHits++
+			fail := failed
+			lock.Unlock()
+			if fail {
+				time.Sleep(time.Millisecond * 100)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			http.Redirect(w, r, "/robots-real.txt", http.StatusFound)
+		case "/robots-real.txt":
+			w.Write([]byte(robotsTxt))
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer server.Close()
+	checker := &lazyRobots{}
+	reqCli, err := requests.NewClient(nil, requests.ClientOption{Robots: checker})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checker.cli, err = robots.NewClient(nil, robots.ClientOption{ReqCli: reqCli, ErrCacheTime: time.Millisecond * 200}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cnl := context.WithTimeout(context.TODO(), time.Second*5)
+	defer cnl()
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := reqCli.Request(ctx, "get", server.URL+"/index")
+			var disErr *requests.DisallowedError
+			if !errors.As(err, &disErr) {
+				t.Error("robots.txt 服务器错误时没有禁止访问: ", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if robotsHits != 1 {
+		t.Fatal("并发请求没有合并robots.txt 的获取: ", robotsHits)
+	}
+	lock.Lock()
+	failed = false
+	lock.Unlock()
+	time.Sleep(time.Millisecond * 300)
+	if resp, err := reqCli.Request(ctx, "get", server.URL+"/index"); err != nil || resp.Text() != "ok" {
+		t.Fatal("服务器错误的缓存没有过期,或者重定向的robots.txt 获取失败: ", err)
+	}
+	if _, err = reqCli.Request(ctx, "get", server.URL+"/private"); !errors.Is(err, requests.ErrFatal) {
+		t.Fatal("重定向的robots.txt 没有生效: ", err)
+	}
+	if robotsHits != 2 {
+		t.Fatal("robots.txt 获取次数错误: ", robotsHits)
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gitee.com/baixudong/gospider/requests"
+)
+
+// 支持UDP ASSOCIATE 的socks5 代理,返回udp 转发时收到的地址
+func newSocks5Proxy(t *testing.T) (net.Listener, func() []string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lock sync.Mutex
+	var targets []string
+	readAddr := func(reader io.Reader) (string, error) {
+		head := make([]byte, 1)
+		if _, err := io.ReadFull(reader, head); err != nil {
+			return "", err
+		}
+		var host string
+		switch head[0] {
+		case 1, 4:
+			ip := make([]byte, 4)
+			if head[0] == 4 {
+				ip = make([]byte, 16)
+			}
+			if _, err := io.ReadFull(reader, ip); err != nil {
+				return "", err
+			}
+			host = net.IP(ip).String()
+		case 3:
+			if _, err := io.ReadFull(reader, head); err != nil {
+				return "", err
+			}
+			domain := make([]byte, head[0])
+			if _, err := io.ReadFull(reader, domain); err != nil {
+				return "", err
+			}
+			host = string(domain)
+		}
+		port := make([]byte, 2)
+		if _, err := io.ReadFull(reader, port); err != nil {
+			return "", err
+		}
+		return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				head := make([]byte, 3)
+				if _, err := io.ReadFull(conn, head[:2]); err != nil {
+					return
+				}
+				if _, err := io.ReadFull(conn, make([]byte, head[1])); err != nil {
+					return
+				}
+				conn.Write([]byte{5, 0})
+				if _, err := io.ReadFull(conn, head); err != nil {
+					return
+				}
+				addr, err := readAddr(conn)
+				if err != nil {
+					return
+				}
+				switch head[1] {
+				case 1:
+					remote, err := net.Dial("tcp", addr)
+					if err != nil {
+						conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+						return
+					}
+					defer remote.Close()
+					conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+					go io.Copy(remote, conn)
+					io.Copy(conn, remote)
+				case 3:
+					relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+					if err != nil {
+						return
+					}
+					defer relay.Close()
+					port := relay.LocalAddr().(*net.UDPAddr).Port
+					conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, byte(port >> 8), byte(port)})
+					go func() {
+						buf := make([]byte, 65535)
+						for {
+							n, client, err := relay.ReadFromUDP(buf)
+							if err != nil {
+								return
+							}
+							reader := bytes.NewReader(buf[3:n])
+							target, err := readAddr(reader)
+							if err != nil {
+								continue
+							}
+							lock.Lock()
+							targets = append(targets, target)
+							lock.Unlock()
+							remote, err := net.Dial("udp", target)
+							if err != nil {
+								continue
+							}
+							remote.Write(buf[n-reader.Len() : n])
+							rn, err := remote.Read(buf)
+							remote.Close()
+							if err != nil {
+								continue
+							}
+							relay.WriteToUDP(append([]byte{0, 0, 0, 1, 127, 0, 0, 1, 0, 0}, buf[:rn]...), client)
+						}
+					}()
+					io.Copy(io.Discard, conn) //tcp 连接关闭后停止转发
+				}
+			}()
+		}
+	}()
+	return listener, func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]string{}, targets...)
+	}
+}
+
+// udp dns 服务,所有A 记录都返回127.0.0.1
+func newUdpDnsServer(t *testing.T) net.PacketConn {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := server.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			server.WriteTo(dnsAnswer(t, buf[:n]), addr)
+		}
+	}()
+	return server
+}
+
+func TestSocks5Udp(t *testing.T) {
+	proxy, targets := newSocks5Proxy(t)
+	defer proxy.Close()
+	echo, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := echo.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			echo.WriteTo(buf[:n], addr)
+		}
+	}()
+	_, port, _ := net.SplitHostPort(echo.LocalAddr().String())
+	//socks5 本地解析域名,socks5h 由代理解析域名
+	for scheme, target := range map[string]string{"socks5": "127.0.0.1:" + port, "socks5h": "localhost:" + port} {
+		dialCli, err := requests.NewDail(nil, requests.DialOption{Proxy: scheme + "://" + proxy.Addr().String(), AddrType: requests.Ipv4})
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := dialCli.DialContext(context.TODO(), "udp", "localhost:"+port)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = conn.Write([]byte(scheme)); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 1024)
+		n, err := conn.Read(buf)
+		conn.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != scheme {
+			t.Fatal("udp 转发内容错误: ", string(buf[:n]))
+		}
+		if nowTargets := targets(); nowTargets[len(nowTargets)-1] != target {
+			t.Fatal("udp 转发地址错误: ", nowTargets)
+		}
+	}
+	//dns 查询通过socks5 代理转发
+	dnsServer := newUdpDnsServer(t)
+	defer dnsServer.Close()
+	dialCli, err := requests.NewDail(nil, requests.DialOption{
+		Proxy:    "socks5h://" + proxy.Addr().String(),
+		Dns:      dnsServer.LocalAddr().String(),
+		DnsProxy: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	num := len(targets())
+	addr, err := dialCli.AddrToIp(context.TODO(), "gospider.test:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr != "127.0.0.1:80" {
+		t.Fatal("dns 解析错误: ", addr)
+	}
+	if nowTargets := targets(); len(nowTargets) == num || nowTargets[len(nowTargets)-1] != dnsServer.LocalAddr().String() {
+		t.Fatal("dns 查询没有通过代理: ", nowTargets)
+	}
+	//tcp 连接使用CONNECT
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	resp, err := requests.Get(nil, server.URL, requests.RequestOption{Proxy: "socks5h://" + proxy.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Text() != "ok" {
+		t.Fatal("socks5 代理错误: ", resp.Text())
+	}
+}
+
+func TestSocks5UdpConcurrentRead(t *testing.T) {
+	proxy, _ := newSocks5Proxy(t)
+	defer proxy.Close()
+	echo, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := echo.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			echo.WriteTo(buf[:n], addr)
+		}
+	}()
+	dialCli, err := requests.NewDail(nil, requests.DialOption{Proxy: "socks5://" + proxy.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := dialCli.DialContext(context.TODO(), "udp", echo.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second * 5))
+	//多个协程同时读取,每个数据包都要完整
+	num := 20
+	results := make(chan string, num)
+	for i := 0; i < num; i++ {
+		go func() {
+			buf := make([]byte, 1024)
+			n, _, err := conn.(net.PacketConn).ReadFrom(buf)
+			if err != nil {
+				results <- err.Error()
+				return
+			}
+			results <- string(buf[:n])
+		}()
+	}
+	for i := 0; i < num; i++ {
+		if _, err = conn.Write([]byte(strings.Repeat(strconv.Itoa(i%10), 10+i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < num; i++ {
+		result := <-results
+		if len(result) < 10 || strings.Count(result, result[:1]) != len(result) {
+			t.Fatal("并发读取的数据包错误: ", result)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gitee.com/baixudong/gospider/requests"
+)
+
+func TestTimings(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	href := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+	reqCli, err := requests.NewClient(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := reqCli.Request(nil, "get", href)
+	if err != nil {
+		t.Fatal(err)
+	}
+	timings := resp.Timings()
+	if timings.Reused || timings.Dns <= 0 || timings.Connect <= 0 || timings.Tls <= 0 || timings.FirstByte <= 0 || timings.Total < timings.Connect+timings.Tls {
+		t.Fatal("新连接的耗时错误: ", timings)
+	}
+	if timings.Proto == "" || timings.RemoteIp.String() != "127.0.0.1" {
+		t.Fatal("协议或远程ip 错误: ", timings.Proto, timings.RemoteIp)
+	}
+	if resp, err = reqCli.Request(nil, "get", href); err != nil {
+		t.Fatal(err)
+	}
+	if timings = resp.Timings(); !timings.Reused || timings.Connect != 0 || timings.Tls != 0 {
+		t.Fatal("复用连接的耗时错误: ", timings)
+	}
+	//通过代理
+	proxy := newConnectProxy(t, true)
+	defer proxy.Close()
+	if resp, err = reqCli.Request(nil, "get", server.URL+"/proxy", requests.RequestOption{Proxy: "http://" + proxy.Addr().String()}); err != nil {
+		t.Fatal(err)
+	}
+	if timings = resp.Timings(); timings.Proxy <= 0 || timings.RemoteIp.String() != "127.0.0.1" {
+		t.Fatal("代理的耗时错误: ", timings)
+	}
+}
@@ -748,24 +748,25 @@ func CopyWitchContext(ctx context.Context, writer io.Writer, reader io.ReadClose
 		}
 		return
 	}
-	p := make(chan struct{})
+	p := make(chan error, 1) //拷贝的协程使用自己的err,ctx 取消后不会和返回值竞争
 	go func() {
+		var copyErr error
 		defer func() {
-			if recErr := recover(); recErr != nil && err == nil {
-				err = errors.New(fmt.Sprint(recErr))
+			if recErr := recover(); recErr != nil && copyErr == nil {
+				copyErr = errors.New(fmt.Sprint(recErr))
 			}
-			close(p)
+			p <- copyErr
 		}()
-		_, err = io.Copy(writer, reader)
-		if err != nil && errors.Is(err, io.ErrUnexpectedEOF) {
-			err = nil
+		_, copyErr = io.Copy(writer, reader)
+		if copyErr != nil && errors.Is(copyErr, io.ErrUnexpectedEOF) {
+			copyErr = nil
 		}
 	}()
 	select {
 	case <-ctx.Done():
 		reader.Close()
 		err = ctx.Err()
-	case <-p:
+	case err = <-p:
 	}
 	return
 }